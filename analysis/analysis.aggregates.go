@@ -0,0 +1,203 @@
+// analysis.aggregates.go
+// Economy-wide Marxian aggregates, computed from a user's history.
+//
+// Surplus value is created in production, so it is measured across the
+// Produce stage: it is the increase in the capital of all industries
+// between the stage before Produce and the stage after. Constant and
+// variable capital are the stocks of means of production and labour
+// power that the industries advanced to that production. The ratios are
+// built from these three magnitudes, once in value terms and once in
+// price terms:
+//
+//	rate of surplus value  s/v
+//	organic composition    c/v
+//	general rate of profit s/(c+v)
+//
+// Until the history contains a Produce stage there is no surplus value,
+// and the ratios are computed from the capital the industries hold.
+
+package analysis
+
+import (
+	"capfront/models"
+	"fmt"
+	"math"
+)
+
+// A magnitude measured in both value and price terms.
+type Magnitude struct {
+	Value float64
+	Price float64
+}
+
+func (m Magnitude) plus(n Magnitude) Magnitude {
+	return Magnitude{Value: m.Value + n.Value, Price: m.Price + n.Price}
+}
+
+func (m Magnitude) minus(n Magnitude) Magnitude {
+	return Magnitude{Value: m.Value - n.Value, Price: m.Price - n.Price}
+}
+
+// Formats one term of the magnitude ("value" or "price") for a table,
+// with the given number of decimal places. An undefined ratio is shown as a dash.
+func (m Magnitude) Format(term string, decimals int) string {
+	x := m.Value
+	if term == "price" {
+		x = m.Price
+	}
+	return Number(x).Format(decimals)
+}
+
+// A figure that may be undefined (NaN), such as a ratio whose denominator is zero.
+type Number float64
+
+// Formats the number for a table, with the given number of decimal places.
+// An undefined number is shown as a dash.
+func (n Number) Format(decimals int) string {
+	if math.IsNaN(float64(n)) {
+		return "–"
+	}
+	return fmt.Sprintf("%.*f", decimals, float64(n))
+}
+
+// m/n in each term. NaN where n is zero, because there is no meaningful ratio.
+func ratio(m Magnitude, n Magnitude) Magnitude {
+	return Magnitude{Value: divide(m.Value, n.Value), Price: divide(m.Price, n.Price)}
+}
+
+func divide(a float64, b float64) float64 {
+	if b == 0 {
+		return math.NaN()
+	}
+	return a / b
+}
+
+// The aggregates of the economy at one stage.
+type Aggregates struct {
+	TimeStamp int
+
+	ConstantCapitalHeld Magnitude // means of production held by industries at this stage
+	VariableCapitalHeld Magnitude // labour power held by industries at this stage
+
+	ProductionStage int       // time stamp of the latest Produce, or -1 if there has been none
+	ConstantCapital Magnitude // c: advanced to the latest production
+	VariableCapital Magnitude // v: advanced to the latest production
+	SurplusValue    Magnitude // s: created in the latest production
+	ReportedProfit  float64   // the total profit reported by the server, for comparison with s in price terms
+	ConsumptionFund Magnitude // consumer goods held by all classes
+	RateOfSurplus   Magnitude // s/v
+	Composition     Magnitude // c/v
+	RateOfProfit    Magnitude // s/(c+v)
+}
+
+// Sums the constant and variable capital held by all industries in a dataset.
+func capitalHeld(d *models.Dataset, ix *models.Index) (constant Magnitude, variable Magnitude) {
+	for _, ind := range d.Industries.List {
+		c := ind.ConstantCapital(ix)
+		v := ind.VariableCapital(ix)
+		constant = constant.plus(Magnitude{Value: float64(c.Value), Price: float64(c.Price)})
+		variable = variable.plus(Magnitude{Value: float64(v.Value), Price: float64(v.Price)})
+	}
+	return constant, variable
+}
+
+// The total capital of all industries: every stock they own.
+func industryCapital(d *models.Dataset) Magnitude {
+	var total Magnitude
+	for _, s := range d.IndustryStocks.List {
+		total = total.plus(Magnitude{Value: float64(s.Value), Price: float64(s.Price)})
+	}
+	return total
+}
+
+// The consumer goods held by all classes.
+func consumptionFund(d *models.Dataset) Magnitude {
+	var total Magnitude
+	for _, s := range d.ClassStocks.List {
+		if s.Usage_type == `Consumption` {
+			total = total.plus(Magnitude{Value: float64(s.Value), Price: float64(s.Price)})
+		}
+	}
+	return total
+}
+
+// The time stamp of the latest Produce stage at or before timeStamp:
+// the stage that records the result of production. -1 if there is none.
+func latestProduction(history []models.HistoryEntry, timeStamp int) int {
+	for ts := timeStamp; ts > 0 && ts < len(history); ts-- {
+		if models.ParseStage(history[ts-1].State) == models.Produce {
+			return ts
+		}
+	}
+	return -1
+}
+
+// Computes the aggregates at the given stage of the user's history.
+func AggregatesAt(u *models.User, timeStamp int) Aggregates {
+	return aggregatesAt(u, u.History(), timeStamp)
+}
+
+// Computes the aggregates at the given stage, given the user's history,
+// so that a calculation at many stages lists the history only once.
+func aggregatesAt(u *models.User, history []models.HistoryEntry, timeStamp int) Aggregates {
+	d := u.Dataset(timeStamp)
+	ix := u.Index(timeStamp)
+	a := Aggregates{TimeStamp: timeStamp, ProductionStage: latestProduction(history, timeStamp)}
+	a.ConstantCapitalHeld, a.VariableCapitalHeld = capitalHeld(d, ix)
+	a.ConsumptionFund = consumptionFund(d)
+	for _, ind := range d.Industries.List {
+		a.ReportedProfit += float64(ind.Profit)
+	}
+
+	if a.ProductionStage < 0 {
+		a.ConstantCapital, a.VariableCapital = a.ConstantCapitalHeld, a.VariableCapitalHeld
+	} else {
+		before, after := a.ProductionStage-1, a.ProductionStage
+		a.ConstantCapital, a.VariableCapital = capitalHeld(u.Dataset(before), u.Index(before))
+		a.SurplusValue = industryCapital(u.Dataset(after)).minus(industryCapital(u.Dataset(before)))
+	}
+
+	a.RateOfSurplus = ratio(a.SurplusValue, a.VariableCapital)
+	a.Composition = ratio(a.ConstantCapital, a.VariableCapital)
+	a.RateOfProfit = ratio(a.SurplusValue, a.ConstantCapital.plus(a.VariableCapital))
+	return a
+}
+
+// One line of the aggregates panel, comparing two stages.
+type Row struct {
+	Name  string
+	Value models.Pair
+	Price models.Pair
+}
+
+// Lays out the aggregates at stage a, compared with those at stage c, for display.
+func Compare(a Aggregates, c Aggregates) []Row {
+	row := func(name string, v Magnitude, w Magnitude) Row {
+		return Row{
+			Name:  name,
+			Value: models.Pair{Viewed: float32(v.Value), Compared: float32(w.Value)},
+			Price: models.Pair{Viewed: float32(v.Price), Compared: float32(w.Price)},
+		}
+	}
+	return []Row{
+		row("Constant capital held (C)", a.ConstantCapitalHeld, c.ConstantCapitalHeld),
+		row("Variable capital held (V)", a.VariableCapitalHeld, c.VariableCapitalHeld),
+		row("Constant capital advanced (c)", a.ConstantCapital, c.ConstantCapital),
+		row("Variable capital advanced (v)", a.VariableCapital, c.VariableCapital),
+		row("Surplus value (s)", a.SurplusValue, c.SurplusValue),
+		row("Consumption fund of classes", a.ConsumptionFund, c.ConsumptionFund),
+		row("Rate of surplus value (s/v)", a.RateOfSurplus, c.RateOfSurplus),
+		row("Organic composition (c/v)", a.Composition, c.Composition),
+		row("General rate of profit (s/(c+v))", a.RateOfProfit, c.RateOfProfit),
+	}
+}
+
+// The aggregates at each of the given stages, for a history table.
+func AggregatesOver(u *models.User, timeStamps []int) []Aggregates {
+	history := u.History()
+	list := make([]Aggregates, len(timeStamps))
+	for i, ts := range timeStamps {
+		list[i] = aggregatesAt(u, history, ts)
+	}
+	return list
+}
@@ -0,0 +1,313 @@
+// analysis.leontief.go
+// An independent calculation of unit values, to check the server's.
+//
+// The unit value of a commodity is the labour needed to produce it,
+// directly and through the means of production it uses. With A the
+// matrix of technical coefficients (A[i][j] is the quantity of
+// commodity i used to make one unit of commodity j) and l the direct
+// labour per unit of each commodity, the unit values λ satisfy
+//
+//	λ = λA + l,  so  λ = l(I - A)⁻¹
+//
+// where (I - A)⁻¹ is the Leontief inverse. Only commodities that some
+// industry produces take part: labour power and money are not produced
+// by industries, so they have no row or column in A.
+//
+// The coefficients are built from each industry's Requirement per unit
+// of output, weighting industries that make the same commodity by their
+// Output_Scale. Direct labour is the labour power consumed per unit of
+// output, times the value that one unit of it creates when consumed.
+//
+// That value is not the value of labour power (v) but the value it
+// adds (v+s). It is read from the history, across a Produce stage: the
+// value of every stock together grows by s, since the value of the
+// means of production is only passed on, and the labour power used up
+// is v. Dividing v+s by the quantity of labour power used up gives the
+// value created by each unit, in the same money terms as Unit_Value.
+//
+// The server may legitimately differ: it may, for instance, carry
+// unit values over from earlier periods. The point of the check is to
+// show where, and by how much.
+
+package analysis
+
+import (
+	"capfront/models"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// The relative difference below which the server's unit value counts as
+// agreeing with the calculation, allowing for rounding.
+const valueTolerance = 0.005
+
+// A square matrix of float64, stored by rows.
+type SquareMatrix [][]float64
+
+func identity(n int) SquareMatrix {
+	m := make(SquareMatrix, n)
+	for i := range m {
+		m[i] = make([]float64, n)
+		m[i][i] = 1
+	}
+	return m
+}
+
+// The inverse of the matrix, by Gauss-Jordan elimination with partial pivoting.
+// An error if the matrix is singular.
+func (m SquareMatrix) Inverse() (SquareMatrix, error) {
+	n := len(m)
+	a := make(SquareMatrix, n)
+	for i := range m {
+		a[i] = append([]float64{}, m[i]...)
+	}
+	inv := identity(n)
+	for col := 0; col < n; col++ {
+		pivot := col
+		for row := col + 1; row < n; row++ {
+			if math.Abs(a[row][col]) > math.Abs(a[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-12 {
+			return nil, errors.New("the matrix is singular")
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		inv[col], inv[pivot] = inv[pivot], inv[col]
+		p := a[col][col]
+		for j := 0; j < n; j++ {
+			a[col][j] /= p
+			inv[col][j] /= p
+		}
+		for row := 0; row < n; row++ {
+			if row == col || a[row][col] == 0 {
+				continue
+			}
+			f := a[row][col]
+			for j := 0; j < n; j++ {
+				a[row][j] -= f * a[col][j]
+				inv[row][j] -= f * inv[col][j]
+			}
+		}
+	}
+	return inv, nil
+}
+
+// The unit value of one commodity, as the server reports it and as calculated here.
+type UnitValueCheck struct {
+	CommodityId int
+	Name        string
+	Server      float64
+	Calculated  float64
+}
+
+// Server - Calculated
+func (c UnitValueCheck) Difference() float64 {
+	return c.Server - c.Calculated
+}
+
+// The difference as a percentage of the calculated value. NaN if that is zero.
+func (c UnitValueCheck) PercentDifference() float64 {
+	return 100 * divide(c.Difference(), c.Calculated)
+}
+
+// True if the server agrees with the calculation, within rounding.
+func (c UnitValueCheck) Agrees() bool {
+	return math.Abs(c.Difference()) <= valueTolerance*math.Max(math.Abs(c.Server), math.Abs(c.Calculated))
+}
+
+// The calculation of unit values at one stage.
+type LeontiefValues struct {
+	TimeStamp    int
+	Commodities  []MatrixColumn   // the produced commodities, in display order; they index the matrices
+	Coefficients SquareMatrix     // A
+	Labour       []float64        // l, in money terms
+	Intensity    float64          // the value created by consuming one unit of labour power
+	MeasuredAt   int              // the Produce stage across which Intensity was measured
+	Inverse      SquareMatrix     // (I - A)⁻¹; nil if the economy cannot reproduce itself
+	Checks       []UnitValueCheck // one for each commodity
+	Err          error            // why the calculation could not be made, if it could not
+}
+
+// True if every commodity's unit value agrees with the calculation.
+func (l LeontiefValues) Agrees() bool {
+	if l.Err != nil {
+		return false
+	}
+	for _, c := range l.Checks {
+		if !c.Agrees() {
+			return false
+		}
+	}
+	return true
+}
+
+// The check of the commodity with the given id, or nil if it was not calculated.
+func (l LeontiefValues) CheckOf(commodityId int) *UnitValueCheck {
+	for i := range l.Checks {
+		if l.Checks[i].CommodityId == commodityId {
+			return &l.Checks[i]
+		}
+	}
+	return nil
+}
+
+// The total value of every stock, owned by industries and by classes.
+func stockValue(d *models.Dataset) float64 {
+	var total float64
+	for _, s := range d.IndustryStocks.List {
+		total += float64(s.Value)
+	}
+	for _, s := range d.ClassStocks.List {
+		total += float64(s.Value)
+	}
+	return total
+}
+
+// The value created by consuming one unit of labour power, measured
+// across the production recorded at stage after, as (v+s)/L: s is the
+// growth in the value of all stocks, v is the value of the labour power
+// L that the industries used up.
+//
+//	Returns: an error if no labour power was used up.
+func valueCreatedAt(u *models.User, after int) (float64, error) {
+	var labour, v float64
+	for _, a := range productionAccounts(u, after) {
+		labour += a.labour
+		v += a.v
+	}
+	if labour <= 0 {
+		return 0, fmt.Errorf("no labour power was used up in the production recorded at stage %d", after)
+	}
+	s := stockValue(u.Dataset(after)) - stockValue(u.Dataset(after-1))
+	return (v + s) / labour, nil
+}
+
+// The Produce stage across which to measure the value created by labour
+// power for a calculation at timeStamp: the latest at or before it or,
+// before the first production, the earliest after it. -1 if the history
+// contains none.
+func measuringProduction(history []models.HistoryEntry, timeStamp int) int {
+	if after := latestProduction(history, timeStamp); after > 0 {
+		return after
+	}
+	for after := timeStamp + 1; after < len(history); after++ {
+		if models.ParseStage(history[after-1].State) == models.Produce {
+			return after
+		}
+	}
+	return -1
+}
+
+// Calculates the unit values at the given stage of the user's history.
+func UnitValuesAt(u *models.User, timeStamp int) LeontiefValues {
+	return unitValuesAt(u, u.History(), timeStamp)
+}
+
+// Calculates the unit values at the given stage, given the user's history.
+func unitValuesAt(u *models.User, history []models.HistoryEntry, timeStamp int) LeontiefValues {
+	d := u.Dataset(timeStamp)
+	ix := u.Index(timeStamp)
+	result := LeontiefValues{TimeStamp: timeStamp, MeasuredAt: measuringProduction(history, timeStamp)}
+
+	// The produced commodities, and the output of each
+	produced := make(map[int]float64) // commodity id -> total output scale
+	for _, ind := range d.Industries.List {
+		if c := ind.OutputCommodity(ix); c.Id != 0 {
+			produced[c.Id] += float64(ind.Output_Scale)
+		}
+	}
+	commodities := make([]models.Commodity, 0, len(produced))
+	for _, c := range d.Commodities.List {
+		if _, ok := produced[c.Id]; ok {
+			commodities = append(commodities, c)
+		}
+	}
+	sort.SliceStable(commodities, func(i, j int) bool { return commodities[i].Display_Order < commodities[j].Display_Order })
+	position := make(map[int]int, len(commodities))
+	for i, c := range commodities {
+		position[c.Id] = i
+		result.Commodities = append(result.Commodities, MatrixColumn{Id: c.Id, Name: c.Name})
+	}
+	n := len(commodities)
+	if n == 0 {
+		result.Err = errors.New("no industry produces anything at this stage")
+		return result
+	}
+
+	// The value created by each unit of labour power
+	if result.MeasuredAt < 0 {
+		result.Err = errors.New("there has been no production yet, so the value created by labour power is not known")
+		return result
+	}
+	var err error
+	if result.Intensity, err = valueCreatedAt(u, result.MeasuredAt); err != nil {
+		result.Err = err
+		return result
+	}
+
+	// The coefficients, as the output-weighted average over the industries making each commodity
+	result.Coefficients = make(SquareMatrix, n)
+	for i := range result.Coefficients {
+		result.Coefficients[i] = make([]float64, n)
+	}
+	result.Labour = make([]float64, n)
+	for _, ind := range d.Industries.List {
+		out := ind.OutputCommodity(ix).Id
+		j, ok := position[out]
+		if !ok || produced[out] == 0 {
+			continue
+		}
+		weight := float64(ind.Output_Scale) / produced[out]
+		for _, s := range ix.IndustryStocks(ind.Id, `Production`) {
+			c := ix.Commodity(s.Commodity_id)
+			switch {
+			case c != nil && c.Origin == `SOCIAL`:
+				result.Labour[j] += weight * float64(s.Requirement) * result.Intensity
+			default:
+				if i, ok := position[s.Commodity_id]; ok {
+					result.Coefficients[i][j] += weight * float64(s.Requirement)
+				}
+			}
+		}
+	}
+
+	// λ = l(I - A)⁻¹
+	leontief := identity(n)
+	for i := range leontief {
+		for j := range leontief[i] {
+			leontief[i][j] -= result.Coefficients[i][j]
+		}
+	}
+	inverse, err := leontief.Inverse()
+	if err != nil {
+		result.Err = fmt.Errorf("I - A cannot be inverted (%v): the economy cannot reproduce itself with these techniques", err)
+		return result
+	}
+	result.Inverse = inverse
+	for j, c := range commodities {
+		var value float64
+		for i := 0; i < n; i++ {
+			value += result.Labour[i] * inverse[i][j]
+		}
+		result.Checks = append(result.Checks, UnitValueCheck{
+			CommodityId: c.Id,
+			Name:        c.Name,
+			Server:      float64(c.Unit_Value),
+			Calculated:  value,
+		})
+	}
+	return result
+}
+
+// Calculates the unit values at each of the given stages, for a history table.
+func UnitValuesOver(u *models.User, timeStamps []int) []LeontiefValues {
+	history := u.History()
+	list := make([]LeontiefValues, len(timeStamps))
+	for i, ts := range timeStamps {
+		list[i] = unitValuesAt(u, history, ts)
+	}
+	return list
+}
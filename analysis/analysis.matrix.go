@@ -0,0 +1,178 @@
+// analysis.matrix.go
+// The input-output matrix: what each industry uses of each commodity.
+//
+// The matrix is built from the industries' Production stocks, with one
+// row for each industry and one column for each commodity that any
+// industry uses. Each cell is one magnitude of the stocks (chosen by a
+// Measure) compared between two stages, so the matrix shows how the
+// structure of production changes as well as what it is.
+
+package analysis
+
+import (
+	"capfront/models"
+	"sort"
+	"strconv"
+)
+
+// The magnitude of a stock that the cells of a matrix show.
+type Measure string
+
+const (
+	Requirement Measure = `requirement` // input per unit of output
+	Demand      Measure = `demand`      // what the industry wants to buy
+	Size        Measure = `size`        // what it holds
+	Value       Measure = `value`       // what that is worth, in value
+	Price       Measure = `price`       // what that is worth, in price
+)
+
+// The measures a user can choose, in the order they are offered.
+var Measures = []Measure{Requirement, Demand, Size, Value, Price}
+
+// Converts a measure named in a URL into a Measure.
+// ok is false if there is no such measure.
+func ParseMeasure(measure string) (m Measure, ok bool) {
+	for _, m := range Measures {
+		if string(m) == measure {
+			return m, true
+		}
+	}
+	return Size, false
+}
+
+// The measure of one stock.
+func (m Measure) of(s models.Industry_Stock) float32 {
+	switch m {
+	case Requirement:
+		return s.Requirement
+	case Demand:
+		return s.Demand
+	case Value:
+		return s.Value
+	case Price:
+		return s.Price
+	}
+	return s.Size
+}
+
+// A column of the matrix.
+type MatrixColumn struct {
+	Id   int
+	Name string
+}
+
+// A row of the matrix.
+type MatrixRow struct {
+	Id       int
+	Name     string
+	Presence models.Presence // whether the industry exists at both stages
+	Cells    []models.Pair   // one for each column
+	Total    models.Pair
+}
+
+// The input-output matrix, compared between two stages.
+type Matrix struct {
+	Measure      Measure
+	Columns      []MatrixColumn
+	Rows         []MatrixRow
+	ColumnTotals []models.Pair
+	Total        models.Pair
+}
+
+// The production stocks of a dataset, summed by industry and commodity.
+func inputs(d *models.Dataset, m Measure) map[[2]int]float32 {
+	cells := make(map[[2]int]float32)
+	for _, s := range d.IndustryStocks.List {
+		if s.Usage_type == `Production` {
+			cells[[2]int{s.Industry_id, s.Commodity_id}] += m.of(s)
+		}
+	}
+	return cells
+}
+
+// Builds the input-output matrix of the given measure at the viewed
+// stage, compared with the compared stage. Industries and commodities
+// that exist at only one of the two stages are included, so that
+// nothing disappears from the comparison.
+func NewMatrix(u *models.User, viewed int, compared int, m Measure) Matrix {
+	vd, cd := u.Dataset(viewed), u.Dataset(compared)
+	vCells, cCells := inputs(vd, m), inputs(cd, m)
+
+	// Columns: every commodity used by any industry at either stage, in display order
+	used := make(map[int]bool)
+	for key := range vCells {
+		used[key[1]] = true
+	}
+	for key := range cCells {
+		used[key[1]] = true
+	}
+	commodities := append(append([]models.Commodity{}, vd.Commodities.List...), cd.Commodities.List...)
+	sort.SliceStable(commodities, func(i, j int) bool { return commodities[i].Display_Order < commodities[j].Display_Order })
+	matrix := Matrix{Measure: m}
+	for _, c := range commodities {
+		if used[c.Id] {
+			matrix.Columns = append(matrix.Columns, MatrixColumn{Id: c.Id, Name: c.Name})
+			delete(used, c.Id) // a commodity present at both stages gets one column
+		}
+	}
+	matrix.ColumnTotals = make([]models.Pair, len(matrix.Columns))
+
+	// Rows: the viewed industries, then any that have since been removed
+	addRow := func(ind models.Industry, presence models.Presence) {
+		row := MatrixRow{Id: ind.Id, Name: ind.Name, Presence: presence, Cells: make([]models.Pair, len(matrix.Columns))}
+		for j, col := range matrix.Columns {
+			key := [2]int{ind.Id, col.Id}
+			cell := models.Pair{Viewed: vCells[key], Compared: cCells[key]}
+			row.Cells[j] = cell
+			row.Total = addPairs(row.Total, cell)
+			matrix.ColumnTotals[j] = addPairs(matrix.ColumnTotals[j], cell)
+		}
+		matrix.Total = addPairs(matrix.Total, row.Total)
+		matrix.Rows = append(matrix.Rows, row)
+	}
+	seen := make(map[int]bool)
+	for _, ind := range vd.Industries.List {
+		seen[ind.Id] = true
+		presence := models.Matched
+		if u.Index(compared).Industry(ind.Id) == nil {
+			presence = models.Added
+		}
+		addRow(ind, presence)
+	}
+	for _, ind := range cd.Industries.List {
+		if !seen[ind.Id] {
+			addRow(ind, models.Removed)
+		}
+	}
+	return matrix
+}
+
+func addPairs(p models.Pair, q models.Pair) models.Pair {
+	return models.Pair{Viewed: p.Viewed + q.Viewed, Compared: p.Compared + q.Compared}
+}
+
+// The matrix as records for a CSV file, one for each cell, with a header.
+// The long layout keeps the two stages and the change side by side,
+// and is easy to load into a spreadsheet pivot table or a data frame.
+func (m Matrix) Records(viewed int, compared int) [][]string {
+	records := [][]string{{`industry`, `commodity`, `measure`, `viewed_stage`, `viewed`, `compared_stage`, `compared`, `change`}}
+	for _, row := range m.Rows {
+		for j, cell := range row.Cells {
+			records = append(records, []string{
+				row.Name,
+				m.Columns[j].Name,
+				string(m.Measure),
+				strconv.Itoa(viewed),
+				formatFloat(cell.Viewed),
+				strconv.Itoa(compared),
+				formatFloat(cell.Compared),
+				formatFloat(cell.Delta()),
+			})
+		}
+	}
+	return records
+}
+
+func formatFloat(x float32) string {
+	return strconv.FormatFloat(float64(x), 'g', -1, 32)
+}
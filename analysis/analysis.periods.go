@@ -0,0 +1,142 @@
+// analysis.periods.go
+// The economy period by period, rather than stage by stage.
+//
+// Each period (one circuit from Demand to Invest; see models.Period) is
+// summarised by a snapshot of the economy when it opened and when it
+// closed, the flows that took place during it, and the growth from one
+// snapshot to the other.
+//
+// Growth rates and the rate of profit are per period. They are also
+// annualised, by compounding over the simulation's Periods_Per_Year:
+//
+//	annual rate = (1 + rate per period)^(periods per year) - 1
+//
+// Rates are only calculated for complete periods, since a period in
+// progress has not yet had the chance to grow.
+
+package analysis
+
+import (
+	"capfront/models"
+	"math"
+)
+
+// The state of the economy at one stage.
+type Snapshot struct {
+	TimeStamp           int
+	Output              float64   // the output scale of all industries together
+	ConstantCapitalHeld Magnitude // means of production held by industries
+	VariableCapitalHeld Magnitude // labour power held by industries
+	IndustryCapital     Magnitude // every stock that industries own
+	ConsumptionFund     Magnitude // consumer goods held by classes
+	Wealth              Magnitude // the total value and price of every commodity
+}
+
+func snapshotAt(u *models.User, timeStamp int) Snapshot {
+	d := u.Dataset(timeStamp)
+	s := Snapshot{TimeStamp: timeStamp}
+	s.ConstantCapitalHeld, s.VariableCapitalHeld = capitalHeld(d, u.Index(timeStamp))
+	s.IndustryCapital = industryCapital(d)
+	s.ConsumptionFund = consumptionFund(d)
+	for _, ind := range d.Industries.List {
+		s.Output += float64(ind.Output_Scale)
+	}
+	for _, c := range d.Commodities.List {
+		s.Wealth = s.Wealth.plus(Magnitude{Value: float64(c.Total_Value), Price: float64(c.Total_Price)})
+	}
+	return s
+}
+
+// What happened during a period.
+type Flows struct {
+	ProductionStage  int       // the stage recording the period's production; -1 if it has not happened
+	ConstantCapital  Magnitude // c: advanced to production
+	VariableCapital  Magnitude // v: advanced to production
+	SurplusValue     Magnitude // s: created in production
+	Profit           float64   // the total profit reported by the server after production
+	ConsumptionStage int       // the stage recording the period's consumption; -1 if it has not happened
+	Consumed         Magnitude // the consumer goods used up by classes
+}
+
+// A rate per period, and the same rate over a year.
+type Rate struct {
+	PerPeriod Number
+	Annual    Number
+}
+
+func annualise(perPeriod float64, periodsPerYear float64) Rate {
+	return Rate{PerPeriod: Number(perPeriod), Annual: Number(math.Pow(1+perPeriod, periodsPerYear) - 1)}
+}
+
+func growth(opening float64, closing float64, periodsPerYear float64) Rate {
+	return annualise(divide(closing-opening, opening), periodsPerYear)
+}
+
+// One period, summarised.
+type PeriodAccount struct {
+	models.Period
+	PeriodsPerYear float64
+	Start          Snapshot // when the period opened
+	End            Snapshot // when it closed, or the latest stage if it has not
+	Flows
+	OutputGrowth  Rate // of the output scale of all industries
+	CapitalGrowth Rate // of the price of industry capital
+	WealthGrowth  Rate // of the total value of all commodities
+	RateOfProfit  Rate // s/(c+v) in price terms
+}
+
+// Summarises the given period of the user's history.
+func PeriodAccountOf(u *models.User, p models.Period) PeriodAccount {
+	return periodAccountOf(u, u.History(), p)
+}
+
+// Summarises the given period, given the user's history.
+func periodAccountOf(u *models.User, history []models.HistoryEntry, p models.Period) PeriodAccount {
+	a := PeriodAccount{
+		Period:         p,
+		PeriodsPerYear: u.PeriodsPerYear(p.Closing),
+		Start:          snapshotAt(u, p.Opening),
+		End:            snapshotAt(u, p.Closing),
+	}
+	a.ProductionStage, a.ConsumptionStage = -1, -1
+	for ts := p.Opening + 1; ts <= p.Closing; ts++ {
+		switch models.ParseStage(history[ts-1].State) {
+		case models.Produce:
+			a.ProductionStage = ts
+		case models.Consume:
+			a.ConsumptionStage = ts
+		}
+	}
+	if a.ProductionStage >= 0 {
+		production := aggregatesAt(u, history, a.ProductionStage)
+		a.ConstantCapital = production.ConstantCapital
+		a.VariableCapital = production.VariableCapital
+		a.SurplusValue = production.SurplusValue
+		a.Profit = production.ReportedProfit
+	}
+	if a.ConsumptionStage >= 0 {
+		before := consumptionFund(u.Dataset(a.ConsumptionStage - 1))
+		a.Consumed = before.minus(consumptionFund(u.Dataset(a.ConsumptionStage)))
+	}
+
+	undefined := Rate{PerPeriod: Number(math.NaN()), Annual: Number(math.NaN())}
+	a.OutputGrowth, a.CapitalGrowth, a.WealthGrowth, a.RateOfProfit = undefined, undefined, undefined, undefined
+	if !p.Complete {
+		return a
+	}
+	a.OutputGrowth = growth(a.Start.Output, a.End.Output, a.PeriodsPerYear)
+	a.CapitalGrowth = growth(a.Start.IndustryCapital.Price, a.End.IndustryCapital.Price, a.PeriodsPerYear)
+	a.WealthGrowth = growth(a.Start.Wealth.Value, a.End.Wealth.Value, a.PeriodsPerYear)
+	a.RateOfProfit = annualise(divide(a.SurplusValue.Price, a.ConstantCapital.Price+a.VariableCapital.Price), a.PeriodsPerYear)
+	return a
+}
+
+// Summarises every period of the user's history, earliest first.
+func PeriodAccounts(u *models.User) []PeriodAccount {
+	periods, history := u.Periods(), u.History()
+	list := make([]PeriodAccount, len(periods))
+	for i, p := range periods {
+		list[i] = periodAccountOf(u, history, p)
+	}
+	return list
+}
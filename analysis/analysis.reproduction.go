@@ -0,0 +1,210 @@
+// analysis.reproduction.go
+// The reproduction schema: the output of each department, divided into
+// c + v + s, and the conditions under which the economy can reproduce
+// itself.
+//
+// An industry belongs to Department I if its output is used
+// productively (means of production), and to Department II if its
+// output is consumed. The schema for a period is read from the views
+// of the industries across that period's Produce stage:
+//
+//	c  the value of the means of production used up
+//	v  the value of the labour power used up
+//	w  the value of the output, the increase in the sales stock
+//	s  w - c - v
+//
+// Department I must replace the constant capital used up in both
+// departments. It replaces its own from its own output, so what it
+// offers to Department II, I(v+s), must at least equal II(c). When the
+// two are equal the economy can reproduce itself on the same scale
+// (simple reproduction). When I(v+s) is greater, the surplus means of
+// production allow accumulation (expanded reproduction). When it is
+// less, Department II cannot replace its constant capital and the
+// economy cannot continue on its present scale.
+//
+// The schema is in value terms, as Marx presents it.
+
+package analysis
+
+import (
+	"capfront/models"
+	"math"
+)
+
+// The departments of the schema.
+type Department string
+
+const (
+	DepartmentI     Department = `I`
+	DepartmentII    Department = `II`
+	OtherDepartment Department = `other` // output that is neither produced nor consumed, such as money
+)
+
+// The department to which an industry belongs, decided by the usage of its output.
+func departmentOf(view models.IndustryView, ix *models.Index) Department {
+	c := ix.Commodity(view.OutputCommodityId)
+	if c == nil {
+		return OtherDepartment
+	}
+	switch c.Usage {
+	case `PRODUCTIVE`:
+		return DepartmentI
+	case `CONSUMPTION`:
+		return DepartmentII
+	}
+	return OtherDepartment
+}
+
+// One line of the schema: a department, or the whole economy.
+type SchemaLine struct {
+	Department Department
+	Industries []string // names of the industries in the department
+	C          float64
+	V          float64
+	S          float64
+}
+
+// The value of the department's output, c + v + s.
+func (l SchemaLine) W() float64 {
+	return l.C + l.V + l.S
+}
+
+func (l *SchemaLine) add(c float64, v float64, s float64) {
+	l.C += c
+	l.V += v
+	l.S += s
+}
+
+// How far a period's schema satisfies the balance condition I(v+s) = II(c).
+type Balance string
+
+const (
+	Simple   Balance = `simple`   // I(v+s) = II(c): reproduction on the same scale
+	Expanded Balance = `expanded` // I(v+s) > II(c): surplus means of production for accumulation
+	Deficit  Balance = `deficit`  // I(v+s) < II(c): Department II cannot replace its constant capital
+)
+
+// The relative difference below which I(v+s) and II(c) count as equal,
+// allowing for rounding in the server's calculations.
+const balanceTolerance = 0.005
+
+// The schema for one period.
+type Schema struct {
+	Period          int
+	ProductionStage int // the stage recording the result of the period's production
+	I               SchemaLine
+	II              SchemaLine
+	Other           SchemaLine
+	Total           SchemaLine
+}
+
+// The lines of the departments, in order, leaving out any that have no industries.
+func (s Schema) Lines() []SchemaLine {
+	var lines []SchemaLine
+	for _, l := range []SchemaLine{s.I, s.II, s.Other} {
+		if len(l.Industries) > 0 {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
+
+// I(v+s): what Department I offers in exchange for consumer goods.
+func (s Schema) Offered() float64 {
+	return s.I.V + s.I.S
+}
+
+// II(c): the means of production that Department II must replace.
+func (s Schema) Required() float64 {
+	return s.II.C
+}
+
+// I(v+s) - II(c)
+func (s Schema) Surplus() float64 {
+	return s.Offered() - s.Required()
+}
+
+// Classifies the schema by the balance condition.
+func (s Schema) Balance() Balance {
+	scale := math.Max(math.Abs(s.Offered()), math.Abs(s.Required()))
+	switch {
+	case math.Abs(s.Surplus()) <= balanceTolerance*scale:
+		return Simple
+	case s.Surplus() > 0:
+		return Expanded
+	}
+	return Deficit
+}
+
+// What one industry used and produced in a Produce stage, in value terms.
+type productionAccount struct {
+	view     models.IndustryView // compares the industry after production with before
+	c        float64             // means of production used up
+	v        float64             // labour power used up
+	labour   float64             // the quantity of labour power used up
+	w        float64             // value of the output
+	quantity float64             // size of the output
+}
+
+func (a productionAccount) s() float64 {
+	return a.w - a.c - a.v
+}
+
+// The accounts of every industry that existed on both sides of the
+// production recorded at stage after.
+func productionAccounts(u *models.User, after int) []productionAccount {
+	var accounts []productionAccount
+	for _, view := range *u.IndustryViewsBetween(after, after-1) {
+		if view.Presence != models.Matched {
+			continue
+		}
+		accounts = append(accounts, productionAccount{
+			view:     view,
+			c:        float64(view.ConstantCapitalValue.Compared - view.ConstantCapitalValue.Viewed),
+			v:        float64(view.VariableCapitalValue.Compared - view.VariableCapitalValue.Viewed),
+			labour:   float64(view.VariableCapitalSize.Compared - view.VariableCapitalSize.Viewed),
+			w:        float64(view.SalesStockValue.Viewed - view.SalesStockValue.Compared),
+			quantity: float64(view.SalesStockSize.Viewed - view.SalesStockSize.Compared),
+		})
+	}
+	return accounts
+}
+
+// Builds the schema of the production recorded at stage after.
+func schemaAt(u *models.User, after int, period int) Schema {
+	schema := Schema{
+		Period:          period,
+		ProductionStage: after,
+		I:               SchemaLine{Department: DepartmentI},
+		II:              SchemaLine{Department: DepartmentII},
+		Other:           SchemaLine{Department: OtherDepartment},
+		Total:           SchemaLine{Department: `total`},
+	}
+	ix := u.Index(after - 1)
+	for _, a := range productionAccounts(u, after) {
+		line := &schema.Other
+		switch departmentOf(a.view, ix) {
+		case DepartmentI:
+			line = &schema.I
+		case DepartmentII:
+			line = &schema.II
+		}
+		line.add(a.c, a.v, a.s())
+		line.Industries = append(line.Industries, a.view.Name)
+		schema.Total.add(a.c, a.v, a.s())
+	}
+	return schema
+}
+
+// The schema of every period in the user's history that has completed
+// its Produce stage, in order.
+func Schemas(u *models.User) []Schema {
+	var list []Schema
+	history := u.History()
+	for ts := 1; ts < len(history); ts++ {
+		if models.ParseStage(history[ts-1].State) == models.Produce {
+			list = append(list, schemaAt(u, ts, history[ts].Period))
+		}
+	}
+	return list
+}
@@ -0,0 +1,224 @@
+// analysis.transformation.go
+// How prices deviate from values: the transformation problem.
+//
+// At each stage this compares, for every commodity, the server's unit
+// price with its unit value, and with the price of production it would
+// have if every industry earned the same rate of profit. The prices of
+// production are Marx's: each industry's cost price, c + v at values,
+// is marked up by the general rate of profit
+//
+//	r = Σs / Σ(c + v)
+//
+// taken from the latest production. Marked-up cost prices in total
+// equal total value, and the total mark-up equals total surplus value,
+// so these prices satisfy both of Marx's invariance conditions by
+// construction. The server's own prices need not; the invariance checks
+// show whether they do.
+
+package analysis
+
+import (
+	"capfront/models"
+	"math"
+)
+
+// The relative difference below which the two sides of an invariance
+// condition count as equal, allowing for rounding.
+const invarianceTolerance = 0.005
+
+// One commodity's price compared with its value.
+type PriceValue struct {
+	CommodityId       int
+	Name              string
+	UnitValue         float64
+	UnitPrice         float64
+	PriceOfProduction Number // per unit; NaN if the commodity was not produced
+}
+
+// Unit price / unit value. NaN if the value is zero.
+func (p PriceValue) Ratio() Number {
+	return Number(divide(p.UnitPrice, p.UnitValue))
+}
+
+// Price of production / unit value. NaN if either is undefined.
+func (p PriceValue) ProductionRatio() Number {
+	return Number(divide(float64(p.PriceOfProduction), p.UnitValue))
+}
+
+// Unit price / price of production: how far the market is from equalising profit rates.
+func (p PriceValue) MarketRatio() Number {
+	return Number(divide(p.UnitPrice, float64(p.PriceOfProduction)))
+}
+
+// One industry's rate of profit, as the server reports it.
+type IndustryProfit struct {
+	Name    string
+	Rate    float64
+	Profit  float64
+	Capital float64 // Initial_Capital
+}
+
+// How widely the industries' rates of profit are spread.
+type ProfitDispersion struct {
+	Industries []IndustryProfit
+	Mean       Number // the unweighted mean of the rates
+	Min        Number
+	Max        Number
+	StdDev     Number // population standard deviation of the rates
+	General    Number // total profit / total capital; NaN if there is no capital
+}
+
+// StdDev / Mean. NaN if the mean is zero.
+func (d ProfitDispersion) Variation() Number {
+	return Number(divide(float64(d.StdDev), math.Abs(float64(d.Mean))))
+}
+
+func newProfitDispersion(industries []models.Industry) ProfitDispersion {
+	undefined := Number(math.NaN())
+	d := ProfitDispersion{Min: undefined, Max: undefined, Mean: undefined, StdDev: undefined}
+	var profit, capital float64
+	for _, ind := range industries {
+		d.Industries = append(d.Industries, IndustryProfit{
+			Name:    ind.Name,
+			Rate:    float64(ind.Profit_Rate),
+			Profit:  float64(ind.Profit),
+			Capital: float64(ind.Initial_Capital),
+		})
+		profit += float64(ind.Profit)
+		capital += float64(ind.Initial_Capital)
+	}
+	d.General = Number(divide(profit, capital))
+	n := float64(len(d.Industries))
+	if n == 0 {
+		return d
+	}
+	var sum float64
+	min, max := math.Inf(1), math.Inf(-1)
+	for _, i := range d.Industries {
+		sum += i.Rate
+		min, max = math.Min(min, i.Rate), math.Max(max, i.Rate)
+	}
+	mean := sum / n
+	var squares float64
+	for _, i := range d.Industries {
+		squares += (i.Rate - mean) * (i.Rate - mean)
+	}
+	d.Min, d.Max, d.Mean, d.StdDev = Number(min), Number(max), Number(mean), Number(math.Sqrt(squares/n))
+	return d
+}
+
+// One of Marx's invariance conditions: two totals that should be equal.
+type Invariance struct {
+	Name      string
+	LeftName  string
+	Left      float64
+	RightName string
+	Right     float64
+}
+
+// Left - Right
+func (i Invariance) Difference() float64 {
+	return i.Left - i.Right
+}
+
+// True if the two totals are equal, within rounding.
+func (i Invariance) Holds() bool {
+	return math.Abs(i.Difference()) <= invarianceTolerance*math.Max(math.Abs(i.Left), math.Abs(i.Right))
+}
+
+// The transformation analysis at one stage.
+type Transformation struct {
+	TimeStamp       int
+	ProductionStage int    // the production from which prices of production are calculated; -1 if none
+	GeneralRate     Number // r, the rate of profit in prices of production
+	Commodities     []PriceValue
+	Dispersion      ProfitDispersion
+	Invariances     []Invariance
+}
+
+// True if every invariance condition holds.
+func (t Transformation) InvariancesHold() bool {
+	for _, i := range t.Invariances {
+		if !i.Holds() {
+			return false
+		}
+	}
+	return true
+}
+
+// Analyses the relation of prices to values at the given stage of the user's history.
+func TransformationAt(u *models.User, timeStamp int) Transformation {
+	return transformationAt(u, u.History(), timeStamp)
+}
+
+// Analyses the relation of prices to values at the given stage, given the user's history.
+func transformationAt(u *models.User, history []models.HistoryEntry, timeStamp int) Transformation {
+	d := u.Dataset(timeStamp)
+	aggregates := aggregatesAt(u, history, timeStamp)
+	t := Transformation{
+		TimeStamp:       timeStamp,
+		ProductionStage: aggregates.ProductionStage,
+		GeneralRate:     Number(math.NaN()),
+		Dispersion:      newProfitDispersion(d.Industries.List),
+	}
+
+	// Prices of production, per unit of each commodity produced
+	costs := make(map[int]float64)    // commodity id -> total cost price of its output
+	quantity := make(map[int]float64) // commodity id -> quantity produced
+	if t.ProductionStage >= 0 {
+		accounts := productionAccounts(u, t.ProductionStage)
+		var s, k float64
+		for _, a := range accounts {
+			s += a.s()
+			k += a.c + a.v
+		}
+		t.GeneralRate = Number(divide(s, k))
+		for _, a := range accounts {
+			costs[a.view.OutputCommodityId] += a.c + a.v
+			quantity[a.view.OutputCommodityId] += a.quantity
+		}
+	}
+
+	var totalValue, totalPrice float64
+	for _, c := range d.Commodities.List {
+		p := PriceValue{
+			CommodityId:       c.Id,
+			Name:              c.Name,
+			UnitValue:         float64(c.Unit_Value),
+			UnitPrice:         float64(c.Unit_Price),
+			PriceOfProduction: Number(math.NaN()),
+		}
+		if q := quantity[c.Id]; q > 0 {
+			p.PriceOfProduction = Number(costs[c.Id] * (1 + float64(t.GeneralRate)) / q)
+		}
+		t.Commodities = append(t.Commodities, p)
+		totalValue += float64(c.Total_Value)
+		totalPrice += float64(c.Total_Price)
+	}
+
+	t.Invariances = []Invariance{
+		{Name: `Total value = total price`, LeftName: `total value`, Left: totalValue, RightName: `total price`, Right: totalPrice},
+		{Name: `Total surplus value = total profit`, LeftName: `surplus value`, Left: aggregates.SurplusValue.Value, RightName: `profit`, Right: aggregates.ReportedProfit},
+	}
+	return t
+}
+
+// The comparison for the commodity with the given id, or nil if there is no such commodity.
+func (t Transformation) CommodityOf(commodityId int) *PriceValue {
+	for i := range t.Commodities {
+		if t.Commodities[i].CommodityId == commodityId {
+			return &t.Commodities[i]
+		}
+	}
+	return nil
+}
+
+// The analysis at each of the given stages, for a history table.
+func TransformationsOver(u *models.User, timeStamps []int) []Transformation {
+	history := u.History()
+	list := make([]Transformation, len(timeStamps))
+	for i, ts := range timeStamps {
+		list[i] = transformationAt(u, history, ts)
+	}
+	return list
+}
@@ -0,0 +1,327 @@
+package analysis_test
+
+import (
+	"capfront/analysis"
+	"capfront/api"
+	"capfront/fake"
+	"capfront/fetch"
+	"capfront/models"
+	"context"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestInverse(t *testing.T) {
+	tests := []struct {
+		name string
+		m    analysis.SquareMatrix
+		want analysis.SquareMatrix // nil if the matrix is singular
+	}{
+		{"identity", analysis.SquareMatrix{{1, 0}, {0, 1}}, analysis.SquareMatrix{{1, 0}, {0, 1}}},
+		{"two by two", analysis.SquareMatrix{{4, 7}, {2, 6}}, analysis.SquareMatrix{{0.6, -0.7}, {-0.2, 0.4}}},
+		{"needs a pivot", analysis.SquareMatrix{{0, 1}, {1, 0}}, analysis.SquareMatrix{{0, 1}, {1, 0}}},
+		{"Leontief", analysis.SquareMatrix{{0.8, -0.1, 0}, {-0.2, 0.9, -0.3}, {0, -0.1, 1}},
+			analysis.SquareMatrix{{435.0 / 338, 25.0 / 169, 15.0 / 338}, {50.0 / 169, 200.0 / 169, 60.0 / 169}, {5.0 / 169, 20.0 / 169, 175.0 / 169}}},
+		{"singular", analysis.SquareMatrix{{1, 2}, {2, 4}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.m.Inverse()
+			if tt.want == nil {
+				if err == nil {
+					t.Errorf("Inverse of a singular matrix returned %v and no error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Inverse: %v", err)
+			}
+			for i := range tt.want {
+				for j := range tt.want[i] {
+					if math.Abs(got[i][j]-tt.want[i][j]) > 1e-9 {
+						t.Errorf("Inverse[%d][%d] = %.4f, want %.4f", i, j, got[i][j], tt.want[i][j])
+					}
+				}
+			}
+
+			// M M⁻¹ = I
+			for i := range tt.m {
+				for j := range tt.m {
+					var sum float64
+					for k := range tt.m {
+						sum += tt.m[i][k] * got[k][j]
+					}
+					want := 0.0
+					if i == j {
+						want = 1
+					}
+					if math.Abs(sum-want) > 1e-9 {
+						t.Errorf("(M M⁻¹)[%d][%d] = %g, want %g", i, j, sum, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+// A user of a fresh fake server, who has played the given number of
+// stages of a simulation cloned from the template.
+func play(t *testing.T, template int, stages int) models.User {
+	t.Helper()
+	ctx := context.Background()
+	api.Backend = fake.NewServer()
+	user := models.NewUser(`alice`, 0, `alicekey`)
+	if _, err := fetch.Clone(ctx, &user, template); err != nil {
+		t.Fatalf("Clone(%d): %v", template, err)
+	}
+	for i := 0; i < stages; i++ {
+		if _, err := fetch.Act(ctx, &user, user.CurrentStage().Action()); err != nil {
+			t.Fatalf("Act at stage %d: %v", user.TimeStamp, err)
+		}
+	}
+	return user
+}
+
+// The fake server's unit values are right, so the calculation must agree
+// with them at every stage of every fixture template, once there has been
+// a production to measure the value that labour power creates.
+func TestUnitValuesAgreeWithTheFixtures(t *testing.T) {
+	tests := []struct {
+		name      string
+		template  int
+		intensity float64 // the value created by one unit of labour power, in the fixtures
+	}{
+		{"simple reproduction", 1, 2},
+		{"expanded reproduction", 2, 2},
+		{"four sectors", 3, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := play(t, tt.template, 2*len(models.Circuit))
+
+			for ts := 0; ts <= user.TimeStamp; ts++ {
+				l := analysis.UnitValuesAt(&user, ts)
+				if l.Err != nil {
+					t.Errorf("stage %d: %v", ts, l.Err)
+					continue
+				}
+				if math.Abs(l.Intensity-tt.intensity) > 1e-3 {
+					t.Errorf("stage %d: labour power creates %.4f, want %.4f", ts, l.Intensity, tt.intensity)
+				}
+				for _, c := range l.Checks {
+					if !c.Agrees() {
+						t.Errorf("stage %d: %s has unit value %.4f, calculated %.4f", ts, c.Name, c.Server, c.Calculated)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestInvariance(t *testing.T) {
+	tests := []struct {
+		name        string
+		left, right float64
+		want        bool
+	}{
+		{"equal", 1200, 1200, true},
+		{"within rounding", 1200, 1200.0001, true},
+		{"apart", 1200, 1210, false},
+		{"both nothing", 0, 0, true},
+		{"something and nothing", 5, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := analysis.Invariance{Left: tt.left, Right: tt.right}
+			if got := i.Holds(); got != tt.want {
+				t.Errorf("Holds() for %g and %g = %v, want %v", tt.left, tt.right, got, tt.want)
+			}
+		})
+	}
+}
+
+// Where prices equal values, as in the simple reproduction fixture,
+// total value equals total price and total surplus value equals total
+// profit at every stage. The other fixtures sell some commodities above
+// their value, so total price exceeds total value, and the analysis must
+// say that the invariance does not hold.
+func TestTransformationInvariances(t *testing.T) {
+	tests := []struct {
+		name     string
+		template int
+		hold     bool
+	}{
+		{"prices equal values", 1, true},
+		{"consumer goods above value", 2, false},
+		{"luxuries above value", 3, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := play(t, tt.template, 2*len(models.Circuit))
+			for ts := 0; ts <= user.TimeStamp; ts++ {
+				tr := analysis.TransformationAt(&user, ts)
+				if len(tr.Invariances) != 2 {
+					t.Fatalf("stage %d: %d invariances, want 2", ts, len(tr.Invariances))
+				}
+				if got := tr.InvariancesHold(); got != tt.hold {
+					t.Errorf("stage %d: InvariancesHold() = %v, want %v", ts, got, tt.hold)
+				}
+				values := tr.Invariances[0]
+				if values.Holds() != tt.hold || (!tt.hold && values.Difference() >= 0) {
+					t.Errorf("stage %d: %s %g, %s %g", ts, values.LeftName, values.Left, values.RightName, values.Right)
+				}
+				if profit := tr.Invariances[1]; tt.hold && !profit.Holds() {
+					t.Errorf("stage %d: %s %g, %s %g", ts, profit.LeftName, profit.Left, profit.RightName, profit.Right)
+				}
+			}
+		})
+	}
+}
+
+func TestBalance(t *testing.T) {
+	tests := []struct {
+		name string
+		s    analysis.Schema
+		want analysis.Balance
+	}{
+		{"simple", analysis.Schema{I: analysis.SchemaLine{V: 1000, S: 1000}, II: analysis.SchemaLine{C: 2000}}, analysis.Simple},
+		{"within rounding", analysis.Schema{I: analysis.SchemaLine{V: 1000, S: 1005}, II: analysis.SchemaLine{C: 2000}}, analysis.Simple},
+		{"expanded", analysis.Schema{I: analysis.SchemaLine{V: 1000, S: 1000}, II: analysis.SchemaLine{C: 1500}}, analysis.Expanded},
+		{"deficit", analysis.Schema{I: analysis.SchemaLine{V: 1000, S: 500}, II: analysis.SchemaLine{C: 2000}}, analysis.Deficit},
+		{"nothing produced", analysis.Schema{}, analysis.Simple},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.s.Balance(); got != tt.want {
+				t.Errorf("Balance() of I(v+s) = %g and II(c) = %g is %s, want %s", tt.s.Offered(), tt.s.Required(), got, tt.want)
+			}
+		})
+	}
+}
+
+// Each period's schema divides the output of the fixtures' departments
+// into c + v + s. Where nothing is invested, the economy reproduces
+// itself on the same scale in every period. The expanded reproduction
+// fixture starts from the same balance, but sells consumer goods above
+// their value, so Department II makes more profit, invests more and
+// grows faster than Department I can supply it: II(c) outgrows I(v+s).
+func TestSchemas(t *testing.T) {
+	tests := []struct {
+		name     string
+		template int
+		want     []analysis.Balance // in each period
+	}{
+		{"simple reproduction", 1, []analysis.Balance{analysis.Simple, analysis.Simple, analysis.Simple}},
+		{"expanded reproduction", 2, []analysis.Balance{analysis.Simple, analysis.Deficit, analysis.Deficit}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Two periods, and the third as far as its production.
+			user := play(t, tt.template, 2*len(models.Circuit)+4)
+			schemas := analysis.Schemas(&user)
+			if len(schemas) != len(tt.want) {
+				t.Fatalf("%d schemas after %d productions", len(schemas), len(tt.want))
+			}
+			for i, s := range schemas {
+				if s.Period != i+1 || models.ParseStage(user.History()[s.ProductionStage-1].State) != models.Produce {
+					t.Errorf("schema %d is of period %d, recorded at stage %d", i, s.Period, s.ProductionStage)
+				}
+				if len(s.I.Industries) != 1 || len(s.II.Industries) != 1 || len(s.Lines()) != 2 {
+					t.Errorf("period %d: Department I is %v and Department II is %v, want one industry each", s.Period, s.I.Industries, s.II.Industries)
+				}
+				if s.I.C <= 0 || s.I.V <= 0 || s.II.C <= 0 || s.II.V <= 0 {
+					t.Errorf("period %d: the departments used up %+v and %+v", s.Period, s.I, s.II)
+				}
+				total := s.I.W() + s.II.W() + s.Other.W()
+				if math.Abs(s.Total.W()-total) > 1e-6 || math.Abs(s.Total.C-(s.I.C+s.II.C+s.Other.C)) > 1e-6 {
+					t.Errorf("period %d: the total %+v is not the sum of the departments", s.Period, s.Total)
+				}
+				if got := s.Balance(); got != tt.want[i] {
+					t.Errorf("period %d: I(v+s) = %g and II(c) = %g is %s, want %s", s.Period, s.Offered(), s.Required(), got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// Industry 1 uses corn at both stages, from two stocks, and coal only
+// at the later one. Industry 2 has gone by then, and industry 3 has
+// arrived. Iron is used by nobody, and has no column.
+func TestNewMatrix(t *testing.T) {
+	user := models.User{UserName: `tester`}
+	commodities := []models.Commodity{
+		{Id: 1, Name: `Corn`, Display_Order: 2},
+		{Id: 3, Name: `Coal`, Display_Order: 1},
+		{Id: 5, Name: `Iron`, Display_Order: 3},
+	}
+	before, after := models.NewDataset(``), models.NewDataset(``)
+	before.Commodities.List, after.Commodities.List = commodities, commodities
+	before.Industries.List = []models.Industry{{Id: 1, Name: `Farming`}, {Id: 2, Name: `Mining`}}
+	before.IndustryStocks.List = []models.Industry_Stock{
+		{Id: 1, Industry_id: 1, Commodity_id: 1, Usage_type: `Production`, Size: 10},
+		{Id: 2, Industry_id: 1, Commodity_id: 1, Usage_type: `Production`, Size: 5},
+		{Id: 3, Industry_id: 1, Commodity_id: 1, Usage_type: `Money`, Size: 100},
+		{Id: 4, Industry_id: 2, Commodity_id: 3, Usage_type: `Production`, Size: 7},
+	}
+	after.Industries.List = []models.Industry{{Id: 1, Name: `Farming`}, {Id: 3, Name: `Milling`}}
+	after.IndustryStocks.List = []models.Industry_Stock{
+		{Id: 1, Industry_id: 1, Commodity_id: 1, Usage_type: `Production`, Size: 12},
+		{Id: 5, Industry_id: 1, Commodity_id: 3, Usage_type: `Production`, Size: 2},
+		{Id: 6, Industry_id: 3, Commodity_id: 1, Usage_type: `Production`, Size: 4},
+	}
+	user.CommitDataset(before, before.Simulations)
+	user.CommitDataset(after, after.Simulations)
+
+	m := analysis.NewMatrix(&user, 1, 0, analysis.Size)
+	if len(m.Columns) != 2 || m.Columns[0].Name != `Coal` || m.Columns[1].Name != `Corn` {
+		t.Fatalf("the columns are %+v, want Coal and Corn", m.Columns)
+	}
+	rows := []struct {
+		name     string
+		presence models.Presence
+		cells    []models.Pair
+	}{
+		{`Farming`, models.Matched, []models.Pair{{Viewed: 2, Compared: 0}, {Viewed: 12, Compared: 15}}},
+		{`Milling`, models.Added, []models.Pair{{Viewed: 0, Compared: 0}, {Viewed: 4, Compared: 0}}},
+		{`Mining`, models.Removed, []models.Pair{{Viewed: 0, Compared: 7}, {Viewed: 0, Compared: 0}}},
+	}
+	if len(m.Rows) != len(rows) {
+		t.Fatalf("the matrix has %d rows, want %d", len(m.Rows), len(rows))
+	}
+	for i, want := range rows {
+		row := m.Rows[i]
+		if row.Name != want.name || row.Presence != want.presence {
+			t.Errorf("row %d is %s %q, want %s %q", i, row.Name, row.Presence, want.name, want.presence)
+		}
+		for j := range want.cells {
+			if row.Cells[j] != want.cells[j] {
+				t.Errorf("%s uses %v of %s, want %v", row.Name, row.Cells[j], m.Columns[j].Name, want.cells[j])
+			}
+		}
+	}
+	if m.Rows[0].Total != (models.Pair{Viewed: 14, Compared: 15}) {
+		t.Errorf("Farming uses %v in all, want 14 compared with 15", m.Rows[0].Total)
+	}
+	if m.ColumnTotals[0] != (models.Pair{Viewed: 2, Compared: 7}) || m.ColumnTotals[1] != (models.Pair{Viewed: 16, Compared: 15}) {
+		t.Errorf("the column totals are %v", m.ColumnTotals)
+	}
+	if m.Total != (models.Pair{Viewed: 18, Compared: 22}) {
+		t.Errorf("the total is %v, want 18 compared with 22", m.Total)
+	}
+
+	records := m.Records(1, 0)
+	if len(records) != 1+len(rows)*len(m.Columns) {
+		t.Fatalf("%d records, want a header and one for each cell", len(records))
+	}
+	if got := strings.Join(records[2], `,`); got != `Farming,Corn,size,1,12,0,15,-3` {
+		t.Errorf("the record of Farming's corn is %s", got)
+	}
+
+	if _, ok := analysis.ParseMeasure(`weight`); ok {
+		t.Errorf("ParseMeasure(weight) is ok")
+	}
+	if m, ok := analysis.ParseMeasure(`value`); m != analysis.Value || !ok {
+		t.Errorf("ParseMeasure(value) = %s, %v", m, ok)
+	}
+}
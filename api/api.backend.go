@@ -0,0 +1,50 @@
+// api.backend.go
+// The operations that this client needs from a simulation server.
+// The rest of the client talks to the server only through Backend,
+// so it can be run against the remote simulation-api or against
+// an in-process fake.
+
+package api
+
+import (
+	"capfront/utils"
+	"context"
+)
+
+// A SimulationBackend supplies templates, users and simulation data,
+// and carries out the actions that drive a simulation.
+//
+// Methods that return data return the raw JSON that the server would
+// send, so that every backend goes through the same decoding path.
+// Errors are *ApiError values.
+type SimulationBackend interface {
+	// List of the templates that users can clone. Uses the admin key.
+	Templates(ctx context.Context) ([]byte, error)
+
+	// List of all users known to the server. Uses the admin key.
+	Users(ctx context.Context) ([]byte, error)
+
+	// Details of one user, including whether it is locked. Uses the admin key.
+	User(ctx context.Context, username string) ([]byte, error)
+
+	// Lock the user so that only this client can play as it.
+	Lock(ctx context.Context, apiKey string, username string) error
+
+	// Release the lock on the user.
+	Unlock(ctx context.Context, apiKey string, username string) error
+
+	// Clone a template into a new simulation which becomes the user's current one.
+	// Returns the server's description of the clone, including the new simulation id.
+	Clone(ctx context.Context, apiKey string, templateId int) ([]byte, error)
+
+	// Perform one stage of the circuit (demand, supply, ...) on the user's current simulation.
+	Action(ctx context.Context, apiKey string, action string) error
+
+	// Fetch one table of the user's current simulation. url is the
+	// relative endpoint, as recorded in Table.ApiUrl.
+	Table(ctx context.Context, apiKey string, url string) ([]byte, error)
+}
+
+// The backend used by the whole client.
+// Defaults to the remote server; main may replace it at startup.
+var Backend SimulationBackend = NewHttpBackend(utils.APISOURCE)
@@ -1,94 +1,83 @@
 // api.data.go
-// DataObject is the intermediary between the client and the server.
+// Table is the intermediary between the client and the server.
 
 package api
 
 import (
 	"capfront/utils"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
-	"time"
 )
 
-// Defines a data object to be synchronised with the server
+// Anything that can be refreshed from the server.
+type Fetcher interface {
+	Fetch(ctx context.Context) error
+}
+
+// Defines one table of objects to be synchronised with the server.
+// T is the type of the objects, so the table is decoded straight into
+// a list of them and nothing needs a type assertion to read it.
 // ApiUrl is the endpoint on the server
-// DataList is the local client storage for the data
-type DataObject struct {
-	ApiUrl   string
-	ApiKey   string
-	DataList interface{}
+// List is the local client storage for the data
+type Table[T any] struct {
+	ApiUrl string
+	ApiKey string
+	List   []T
 }
 
-// Retrieves the data for a single simulation object from the server.
-//
-//	Unmarshals the server response into the object
+// Constructor for an empty table which will be fetched from the given endpoint.
+func NewTable[T any](url string, apiKey string) Table[T] {
+	return Table[T]{ApiUrl: url, ApiKey: apiKey, List: []T{}}
+}
+
+// Retrieves the data for a single table from the server.
 //
-//	Return true if it worked
+//	Unmarshals the server response into a new list, which replaces
+//	the table's List only if the whole response could be decoded.
 //
-//	Return false if there was an error of any kind
+//	Return nil if it worked
 //
-//	TODO return an error code instead of a boolean
-func (d *DataObject) Fetch() bool {
-	response, err := ServerRequest(d.ApiKey, d.ApiUrl)
+//	Return an *ApiError describing what went wrong otherwise
+func (t *Table[T]) Fetch(ctx context.Context) error {
+	response, err := Backend.Table(ctx, t.ApiKey, t.ApiUrl)
 
 	if err != nil {
-		utils.Trace(utils.Red, fmt.Sprintf("ServerRequest produced the error %v\n", err))
-		return false
+		utils.Trace(utils.Red, fmt.Sprintf("The backend produced the error %v\n", err))
+		return err
 	}
 
-	if len(string(response)) == 0 {
-		log.Output(1, "INFORMATION: The server response was empty")
-		return false
+	// Populate the table
+	list := []T{}
+	if err := Decode(t.ApiUrl, response, &list); err != nil {
+		return err
 	}
+	t.List = list
+	return nil
+}
 
-	// Uncomment for more diagnostics
-	// fmt.Printf("Type of the data list is %T\n", d.DataList)
+// Unmarshals a server response into target.
+//
+//	url is the endpoint that produced the response, used in diagnostics
+//
+//	Return nil if it worked
+//
+//	Return an *ApiError of kind DecodeError otherwise
+func Decode(url string, response []byte, target any) error {
+	if len(response) == 0 {
+		log.Output(1, "INFORMATION: The server response was empty")
+		return &ApiError{Kind: DecodeError, Url: url, Message: "the server response was empty"}
+	}
 
-	// Populate the data object
-	jsonErr := json.Unmarshal(response, &d.DataList)
+	jsonErr := json.Unmarshal(response, target)
 	if jsonErr != nil {
 		utils.Trace(utils.Red, fmt.Sprintf("Server response could not be unmarshalled: Unmarshal produced the error %v\n", jsonErr))
 		utils.Trace(utils.Red, fmt.Sprintf("The server response was %s\n", response))
-		return false
+		return decodeError(url, jsonErr)
 	}
 
 	// Uncomment for more diagnostics
 	// utils.Trace(utils.Cyan, "Server response was unmarshalled\n")
-	return true
-}
-
-// Currently used only by Initialise.
-func FetchGlobalObject(url string, target any) bool {
-	resp, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		log.Output(1, fmt.Sprint("Error constructing server request", err))
-		return false
-	}
-
-	resp.Header.Add("x-api-key", utils.ADMINKEY)
-	client := &http.Client{Timeout: time.Second * 2} // Timeout after 2 seconds
-	res, _ := client.Do(resp)
-	if res == nil {
-		log.Output(1, "Server did not respond")
-		return false
-	}
-
-	if res.StatusCode != 200 {
-		log.Output(1, "Server rejected admin request")
-		return false
-	}
-
-	body_as_string, _ := io.ReadAll(res.Body)
-	defer res.Body.Close()
-
-	jsonErr := json.Unmarshal(body_as_string, target)
-	if jsonErr != nil {
-		log.Output(1, fmt.Sprint("Could not unmarshal the server response:\n", string(body_as_string)))
-		return false
-	}
-	utils.Trace(utils.BrightWhite, fmt.Sprintf("Request for data from endpoint %s accepted\n", url))
-	return true
+	return nil
 }
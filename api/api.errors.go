@@ -0,0 +1,187 @@
+// api.errors.go
+// Typed errors produced when talking to the server.
+// Callers can tell a timeout from a rejected key from a garbled payload,
+// and the display layer can choose an HTTP status and an explanation
+// that the user can actually act on.
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// The kind of failure that occurred while talking to the server.
+type ErrorKind int
+
+const (
+	Unreachable  ErrorKind = iota // The server could not be contacted at all
+	Timeout                       // The server did not answer in time
+	Unauthorized                  // The server did not accept the api key
+	Locked                        // The user is locked by somebody else
+	NotFound                      // The server does not know the requested object
+	ServerFault                   // The server failed while processing the request
+	DecodeError                   // The server answered but we could not make sense of it
+)
+
+// Short names, used in diagnostics and on the error page.
+var errorKindNames = map[ErrorKind]string{
+	Unreachable:  "server unreachable",
+	Timeout:      "server timeout",
+	Unauthorized: "unauthorized",
+	Locked:       "locked",
+	NotFound:     "not found",
+	ServerFault:  "server error",
+	DecodeError:  "decode error",
+}
+
+func (k ErrorKind) String() string {
+	name, ok := errorKindNames[k]
+	if !ok {
+		return "unknown error"
+	}
+	return name
+}
+
+// The HTTP status that the client should report to the browser
+// when a request fails with this kind of error.
+func (k ErrorKind) HttpStatus() int {
+	switch k {
+	case Unreachable:
+		return http.StatusBadGateway
+	case Timeout:
+		return http.StatusGatewayTimeout
+	case Unauthorized:
+		return http.StatusUnauthorized
+	case Locked:
+		return http.StatusConflict
+	case NotFound:
+		return http.StatusNotFound
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// An explanation of this kind of error which tells the user what to do about it.
+func (k ErrorKind) Explanation() string {
+	switch k {
+	case Unreachable:
+		return "The simulation server could not be contacted. It may be down or restarting. Wait a minute and try again."
+	case Timeout:
+		return "The simulation server took too long to answer. It may be busy. Try again; if this keeps happening, tell the administrator."
+	case Unauthorized:
+		return "The server did not accept your credentials. Choose a player again from the login page."
+	case Locked:
+		return "Somebody else is playing as this user. Choose another player, or wait until they quit."
+	case NotFound:
+		return "The server does not know about the object you asked for. It may have been deleted; go back to your dashboard and choose again."
+	case ServerFault:
+		return "The simulation server failed while processing your request. Tell the administrator what you were doing when it happened."
+	case DecodeError:
+		return "The server sent data that this client could not understand. The client and server may be out of step; tell the administrator."
+	default:
+		return "Something unexpected went wrong. Tell the administrator."
+	}
+}
+
+// Describes a failed exchange with the server.
+//
+//	Kind is the category of the failure
+//	Url is the endpoint that was requested
+//	Status is the HTTP status returned by the server, or 0 if there was none
+//	Message is what the server (or the client) said about it
+//	Field names the offending field if the response could not be decoded
+//	Err is the underlying error, if any
+type ApiError struct {
+	Kind    ErrorKind
+	Url     string
+	Status  int
+	Message string
+	Field   string
+	Err     error
+}
+
+func (e *ApiError) Error() string {
+	s := fmt.Sprintf("%s requesting %s", e.Kind, e.Url)
+	if e.Status != 0 {
+		s += fmt.Sprintf(" (status %d)", e.Status)
+	}
+	if e.Field != "" {
+		s += fmt.Sprintf(" in field %s", e.Field)
+	}
+	if e.Message != "" {
+		s += ": " + e.Message
+	}
+	return s
+}
+
+func (e *ApiError) Unwrap() error {
+	return e.Err
+}
+
+// These two methods let the display layer choose a status and
+// an explanation without knowing about this package.
+func (e *ApiError) HttpStatus() int {
+	return e.Kind.HttpStatus()
+}
+
+func (e *ApiError) Explanation() string {
+	return e.Kind.Explanation()
+}
+
+// Reports whether err is an ApiError of the given kind.
+func IsKind(err error, kind ErrorKind) bool {
+	var apiErr *ApiError
+	if errors.As(err, &apiErr) {
+		return apiErr.Kind == kind
+	}
+	return false
+}
+
+// Classify an error returned by http.Client.Do.
+func transportError(url string, err error) *ApiError {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return &ApiError{Kind: Timeout, Url: url, Message: "no response before the deadline", Err: err}
+	}
+	return &ApiError{Kind: Unreachable, Url: url, Message: "server did not respond", Err: err}
+}
+
+// Classify a response whose status was not 200.
+func statusError(url string, status int, body []byte) *ApiError {
+	e := &ApiError{Url: url, Status: status, Message: string(body)}
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		e.Kind = Unauthorized
+	case http.StatusConflict, http.StatusLocked:
+		e.Kind = Locked
+	case http.StatusNotFound:
+		e.Kind = NotFound
+	case http.StatusGatewayTimeout, http.StatusRequestTimeout:
+		e.Kind = Timeout
+	default:
+		e.Kind = ServerFault
+	}
+	return e
+}
+
+// Classify an error returned by json.Unmarshal, recording the offending
+// field where the json package tells us what it was.
+func decodeError(url string, err error) *ApiError {
+	e := &ApiError{Kind: DecodeError, Url: url, Message: err.Error(), Err: err}
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.As(err, &typeErr):
+		e.Field = typeErr.Field
+		if e.Field == "" {
+			e.Field = typeErr.Value
+		}
+	case errors.As(err, &syntaxErr):
+		e.Field = fmt.Sprintf("byte offset %d", syntaxErr.Offset)
+	}
+	return e
+}
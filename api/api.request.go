@@ -6,52 +6,112 @@ package api
 import (
 	"bytes"
 	"capfront/utils"
-	"errors"
+	"context"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+// A SimulationBackend which talks to the remote simulation-api over HTTP.
+//
+//	Source is the root URL of the server; endpoints are appended to it
+//	AdminKey is used for the admin backdoor endpoints
+//	Timeout limits each individual request
+type HttpBackend struct {
+	Source   string
+	AdminKey string
+	Timeout  time.Duration
+}
+
+// Constructor for an HttpBackend with the standard admin key and timeout.
+func NewHttpBackend(source string) *HttpBackend {
+	return &HttpBackend{
+		Source:   source,
+		AdminKey: utils.ADMINKEY,
+		Timeout:  time.Second * 5, // Timeout after 5 seconds
+	}
+}
+
 // Prepare and send a request for a protected service to the server
 // using the user's api key.
 //
 //		apiKey is the key
-//		url is appended to apiSource to tell the server what to do.
+//		url is appended to Source to tell the server what to do.
 //
 //	 Returns: byte array with the server response
-//	 Returns: error if anything went wrong, or nil
-func ServerRequest(apiKey string, url string) ([]byte, error) {
+//	 Returns: an *ApiError if anything went wrong, or nil
+func (b *HttpBackend) ServerRequest(ctx context.Context, apiKey string, url string) ([]byte, error) {
 	utils.Trace(utils.Cyan, fmt.Sprintf("Entering ServerRequest with apiKey %s and relative path %s\n", apiKey, url))
-	resp, err := http.NewRequest("GET", utils.APISOURCE+url, bytes.NewBuffer([]byte(`{"origin":"Simulation-client"}`)))
+	resp, err := http.NewRequestWithContext(ctx, "GET", b.Source+url, bytes.NewBuffer([]byte(`{"origin":"Simulation-client"}`)))
 	if err != nil {
 		utils.Trace(utils.Red, "Malformed client request")
-		return nil, err
+		return nil, &ApiError{Kind: Unreachable, Url: url, Message: "malformed client request", Err: err}
 	}
 
 	resp.Header.Add("Content-Type", "application/json")
 	resp.Header.Set("User-Agent", "Capitalism reader")
 	resp.Header.Add("x-api-key", apiKey)
 
-	client := &http.Client{Timeout: time.Second * 5} // Timeout after 5 seconds
-	res, _ := client.Do(resp)
-	if res == nil {
+	client := &http.Client{Timeout: b.Timeout}
+	res, err := client.Do(resp)
+	if err != nil {
 		utils.Trace(utils.Red, "Server is down or misbehaving\n")
-		return nil, errors.New("server did not respond")
+		return nil, transportError(url, err)
 	}
 
 	defer res.Body.Close()
-	b, _ := io.ReadAll(res.Body)
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		utils.Trace(utils.Red, "Server response was cut off\n")
+		return nil, transportError(url, err)
+	}
 
 	if res.StatusCode != 200 {
 		utils.Trace(utils.Red, fmt.Sprintf("Server rejected the request with status %s\n", res.Status))
-		utils.Trace(utils.Red, fmt.Sprintf("It said %s\n", string(b)))
-		return nil, errors.New(string(b))
+		utils.Trace(utils.Red, fmt.Sprintf("It said %s\n", string(body)))
+		return nil, statusError(url, res.StatusCode, body)
 	}
 	utils.Trace(utils.Cyan, "Leaving ServerRequest, everything looks good so far\n")
-	return b, nil
+	return body, nil
+}
+
+func (b *HttpBackend) Templates(ctx context.Context) ([]byte, error) {
+	return b.ServerRequest(ctx, b.AdminKey, `templates/templates`)
+}
+
+func (b *HttpBackend) Users(ctx context.Context) ([]byte, error) {
+	return b.ServerRequest(ctx, b.AdminKey, `admin/users`)
+}
+
+func (b *HttpBackend) User(ctx context.Context, username string) ([]byte, error) {
+	return b.ServerRequest(ctx, b.AdminKey, `admin/user/`+username)
+}
+
+func (b *HttpBackend) Lock(ctx context.Context, apiKey string, username string) error {
+	_, err := b.ServerRequest(ctx, apiKey, `admin/lock/`+username)
+	return err
+}
+
+func (b *HttpBackend) Unlock(ctx context.Context, apiKey string, username string) error {
+	_, err := b.ServerRequest(ctx, apiKey, `admin/unlock/`+username)
+	return err
+}
+
+func (b *HttpBackend) Clone(ctx context.Context, apiKey string, templateId int) ([]byte, error) {
+	return b.ServerRequest(ctx, apiKey, `clone/`+strconv.Itoa(templateId))
+}
+
+func (b *HttpBackend) Action(ctx context.Context, apiKey string, action string) error {
+	_, err := b.ServerRequest(ctx, apiKey, `action/`+action)
+	return err
+}
+
+func (b *HttpBackend) Table(ctx context.Context, apiKey string, url string) ([]byte, error) {
+	return b.ServerRequest(ctx, apiKey, url)
 }
 
 // purely temporary
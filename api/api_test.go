@@ -0,0 +1,73 @@
+package api_test
+
+import (
+	"capfront/api"
+	"context"
+	"testing"
+)
+
+// A server that sends the same response to every request for a table.
+type cannedServer struct {
+	api.SimulationBackend
+	url  string
+	body []byte
+	err  error
+}
+
+func (s *cannedServer) Table(ctx context.Context, apiKey string, url string) ([]byte, error) {
+	s.url = url
+	return s.body, s.err
+}
+
+type thing struct {
+	Id   int     `json:"id"`
+	Name string  `json:"name"`
+	Size float32 `json:"size"`
+}
+
+// A table is decoded straight into a list of its own type. Its list is
+// replaced only if the whole response could be decoded.
+func TestTableFetch(t *testing.T) {
+	before := []thing{{Id: 9, Name: `old`}}
+	tests := []struct {
+		name  string
+		body  string
+		err   error
+		fails bool
+		kind  api.ErrorKind // of the error returned, if the fetch fails
+		want  []thing
+	}{
+		{"decoded", `[{"id":1,"name":"coal","size":2.5},{"id":2,"name":"corn","size":4}]`, nil, false, 0,
+			[]thing{{Id: 1, Name: `coal`, Size: 2.5}, {Id: 2, Name: `corn`, Size: 4}}},
+		{"no objects", `[]`, nil, false, 0, []thing{}},
+		{"empty", ``, nil, true, api.DecodeError, before},
+		{"wrong type", `[{"id":"one"}]`, nil, true, api.DecodeError, before},
+		{"not a list", `{"id":1}`, nil, true, api.DecodeError, before},
+		{"server failed", ``, &api.ApiError{Kind: api.ServerFault, Message: `down`}, true, api.ServerFault, before},
+	}
+	defer func(b api.SimulationBackend) { api.Backend = b }(api.Backend)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &cannedServer{body: []byte(tt.body), err: tt.err}
+			api.Backend = server
+			table := api.NewTable[thing](`things`, `key`)
+			table.List = append([]thing(nil), before...)
+
+			err := table.Fetch(context.Background())
+			if server.url != `things` {
+				t.Errorf("fetched %q, want things", server.url)
+			}
+			if tt.fails && !api.IsKind(err, tt.kind) || !tt.fails && err != nil {
+				t.Errorf("Fetch returned %v", err)
+			}
+			if len(table.List) != len(tt.want) {
+				t.Fatalf("the list is %+v, want %+v", table.List, tt.want)
+			}
+			for i := range tt.want {
+				if table.List[i] != tt.want[i] {
+					t.Errorf("object %d is %+v, want %+v", i, table.List[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+// charts.go
+// Line charts rendered on the server as SVG.
+//
+// The charts need nothing in the browser: no scripts and no CDN. A
+// chart is a set of series sharing one x-axis, which the caller
+// labels (with stages, say, or periods). This package knows nothing
+// about the simulation, so it can chart anything that has a history.
+
+package charts
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"math"
+	"strings"
+)
+
+// One line on a chart. Values[i] is plotted against the chart's XLabels[i].
+// A NaN value leaves a gap in the line.
+type Series struct {
+	Name   string
+	Values []float64
+}
+
+type Chart struct {
+	Title   string
+	XTitle  string   // Describes the x-axis, for example "Stage"
+	XLabels []string // One label for each point on the x-axis
+	Series  []Series
+	Width   int // In pixels. Defaults to DefaultWidth
+	Height  int // In pixels. Defaults to DefaultHeight
+}
+
+const (
+	DefaultWidth  = 800
+	DefaultHeight = 400
+)
+
+// Margins around the plot area, leaving room for the axes and the legend.
+const (
+	marginLeft   = 70
+	marginRight  = 20
+	marginTop    = 40
+	marginBottom = 60
+	legendLine   = 18 // height of one line of the legend
+)
+
+// Colours for successive series. They repeat if there are more series than colours.
+var palette = []string{
+	"#1f77b4", "#d62728", "#2ca02c", "#ff7f0e", "#9467bd",
+	"#8c564b", "#e377c2", "#7f7f7f", "#bcbd22", "#17becf",
+}
+
+// Renders the chart as an SVG element, ready to be embedded in a page.
+func (c Chart) SVG() template.HTML {
+	return template.HTML(c.Render())
+}
+
+// Renders the chart as a standalone SVG document.
+func (c Chart) Render() string {
+	width, height := c.Width, c.Height
+	if width <= 0 {
+		width = DefaultWidth
+	}
+	if height <= 0 {
+		height = DefaultHeight
+	}
+	legendHeight := legendLine * len(c.Series)
+	totalHeight := height + legendHeight
+	plotWidth := float64(width - marginLeft - marginRight)
+	plotHeight := float64(height - marginTop - marginBottom)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif" font-size="12">`,
+		width, totalHeight, width, totalHeight)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="white"/>`, width, totalHeight)
+	fmt.Fprintf(&b, `<text x="%d" y="20" text-anchor="middle" font-size="15">%s</text>`, width/2, html.EscapeString(c.Title))
+
+	low, high, ok := c.bounds()
+	if !ok || len(c.XLabels) == 0 {
+		fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="middle" fill="grey">No data to chart</text></svg>`, width/2, height/2)
+		return b.String()
+	}
+	ticks := niceTicks(low, high, 5)
+	low, high = math.Min(low, ticks[0]), math.Max(high, ticks[len(ticks)-1])
+
+	x := func(i int) float64 {
+		if len(c.XLabels) == 1 {
+			return marginLeft + plotWidth/2
+		}
+		return marginLeft + plotWidth*float64(i)/float64(len(c.XLabels)-1)
+	}
+	y := func(v float64) float64 {
+		return marginTop + plotHeight*(1-(v-low)/(high-low))
+	}
+
+	// Horizontal grid lines and the y-axis labels
+	for _, t := range ticks {
+		fmt.Fprintf(&b, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="#ddd"/>`, marginLeft, y(t), width-marginRight, y(t))
+		fmt.Fprintf(&b, `<text x="%d" y="%.1f" text-anchor="end" dominant-baseline="middle">%s</text>`, marginLeft-6, y(t), formatTick(t))
+	}
+
+	// The x-axis, labelling no more points than there is room for
+	step := 1 + len(c.XLabels)/int(math.Max(1, plotWidth/60))
+	fmt.Fprintf(&b, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="black"/>`, marginLeft, marginTop+plotHeight, width-marginRight, marginTop+plotHeight)
+	for i, label := range c.XLabels {
+		if i%step != 0 && i != len(c.XLabels)-1 {
+			continue
+		}
+		fmt.Fprintf(&b, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="black"/>`, x(i), marginTop+plotHeight, x(i), marginTop+plotHeight+4)
+		fmt.Fprintf(&b, `<text x="%.1f" y="%.1f" text-anchor="middle">%s</text>`, x(i), marginTop+plotHeight+18, html.EscapeString(label))
+	}
+	fmt.Fprintf(&b, `<text x="%.1f" y="%d" text-anchor="middle">%s</text>`, marginLeft+plotWidth/2, height-12, html.EscapeString(c.XTitle))
+
+	// The series, each as a line with a marker at every point.
+	// A NaN breaks the line, so a missing value is not drawn as zero.
+	for s, series := range c.Series {
+		colour := palette[s%len(palette)]
+		var path strings.Builder
+		pen := "M"
+		for i, v := range series.Values {
+			if i >= len(c.XLabels) {
+				break
+			}
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				pen = "M"
+				continue
+			}
+			fmt.Fprintf(&path, "%s%.1f,%.1f ", pen, x(i), y(v))
+			pen = "L"
+			fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="2.5" fill="%s"><title>%s: %s</title></circle>`,
+				x(i), y(v), colour, html.EscapeString(series.Name), formatTick(v))
+		}
+		fmt.Fprintf(&b, `<path d="%s" fill="none" stroke="%s" stroke-width="2"/>`, strings.TrimSpace(path.String()), colour)
+
+		// Legend entry
+		ly := height + legendLine*s
+		fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="%s" stroke-width="3"/>`, marginLeft, ly, marginLeft+24, ly, colour)
+		fmt.Fprintf(&b, `<text x="%d" y="%d" dominant-baseline="middle">%s</text>`, marginLeft+30, ly, html.EscapeString(series.Name))
+	}
+
+	b.WriteString(`</svg>`)
+	return b.String()
+}
+
+// The lowest and highest values in all the series.
+// ok is false if there are no values to plot.
+func (c Chart) bounds() (low float64, high float64, ok bool) {
+	low, high = math.Inf(1), math.Inf(-1)
+	for _, s := range c.Series {
+		for _, v := range s.Values {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				continue
+			}
+			low, high = math.Min(low, v), math.Max(high, v)
+			ok = true
+		}
+	}
+	if ok && low == high {
+		// A flat line. Give it some room, so it is drawn across the middle.
+		pad := math.Max(1, math.Abs(low)*0.1)
+		low, high = low-pad, high+pad
+	}
+	return low, high, ok
+}
+
+// Chooses about n evenly spaced round numbers covering low to high,
+// stepping by 1, 2 or 5 times a power of ten.
+func niceTicks(low float64, high float64, n int) []float64 {
+	raw := (high - low) / float64(n)
+	magnitude := math.Pow(10, math.Floor(math.Log10(raw)))
+	step := magnitude
+	for _, m := range []float64{1, 2, 5, 10} {
+		step = m * magnitude
+		if step >= raw {
+			break
+		}
+	}
+	first := math.Floor(low/step) * step
+	var ticks []float64
+	for t := first; t <= high+step/2; t += step {
+		ticks = append(ticks, t)
+	}
+	return ticks
+}
+
+func formatTick(v float64) string {
+	switch {
+	case v == 0:
+		return "0"
+	case math.Abs(v) >= 1e6:
+		return fmt.Sprintf("%.3gM", v/1e6)
+	case math.Abs(v) >= 1000:
+		return fmt.Sprintf("%.0f", v)
+	case math.Abs(v) >= 1:
+		return fmt.Sprintf("%.2f", v)
+	default:
+		return fmt.Sprintf("%.3f", v)
+	}
+}
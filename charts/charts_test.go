@@ -0,0 +1,94 @@
+package charts
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestNiceTicks(t *testing.T) {
+	tests := []struct {
+		low, high float64
+		want      []float64
+	}{
+		{0, 10, []float64{0, 2, 4, 6, 8, 10}},
+		{3, 97, []float64{0, 20, 40, 60, 80, 100}},
+		{-0.4, 0.4, []float64{-0.4, -0.2, 0, 0.2, 0.4}},
+		{1000, 6000, []float64{1000, 2000, 3000, 4000, 5000, 6000}},
+	}
+	for _, tt := range tests {
+		got := niceTicks(tt.low, tt.high, 5)
+		if len(got) != len(tt.want) {
+			t.Errorf("niceTicks(%g, %g) = %v, want %v", tt.low, tt.high, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if math.Abs(got[i]-tt.want[i]) > 1e-9 {
+				t.Errorf("niceTicks(%g, %g) = %v, want %v", tt.low, tt.high, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestBounds(t *testing.T) {
+	nan := math.NaN()
+	tests := []struct {
+		name      string
+		series    []Series
+		low, high float64
+		ok        bool
+	}{
+		{"two series", []Series{{Values: []float64{3, 8}}, {Values: []float64{-2, nan, 5}}}, -2, 8, true},
+		{"flat", []Series{{Values: []float64{50, 50}}}, 45, 55, true},
+		{"flat at zero", []Series{{Values: []float64{0}}}, -1, 1, true},
+		{"nothing to plot", []Series{{Values: []float64{nan, math.Inf(1)}}}, 0, 0, false},
+		{"no series", nil, 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			low, high, ok := Chart{Series: tt.series}.bounds()
+			if ok != tt.ok || ok && (low != tt.low || high != tt.high) {
+				t.Errorf("bounds() = %g, %g, %v, want %g, %g, %v", low, high, ok, tt.low, tt.high, tt.ok)
+			}
+		})
+	}
+}
+
+// A missing value breaks the line rather than being drawn as zero, and
+// every name is escaped.
+func TestRender(t *testing.T) {
+	c := Chart{
+		Title:   `Profit <rate>`,
+		XTitle:  `Stage`,
+		XLabels: []string{`0`, `1`, `2`, `3`},
+		Series: []Series{
+			{Name: `Department I & II`, Values: []float64{1, 2, math.NaN(), 4}},
+			{Name: `Workers`, Values: []float64{3, 3, 3, 3, 3}},
+		},
+	}
+	svg := c.Render()
+	if !strings.HasPrefix(svg, `<svg `) || !strings.HasSuffix(svg, `</svg>`) {
+		t.Fatalf("the chart is not an SVG element: %.80s", svg)
+	}
+	for _, want := range []string{`Profit &lt;rate&gt;`, `Department I &amp; II`, `width="800"`, `height="436"`} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("the chart does not contain %s", want)
+		}
+	}
+	if strings.Contains(svg, `<rate>`) {
+		t.Errorf("the title is not escaped")
+	}
+	if n := strings.Count(svg, `<circle `); n != 3+4 {
+		t.Errorf("the chart has %d points, want 7: the missing value, and the value beyond the last label, are not drawn", n)
+	}
+	paths := strings.Split(svg, `<path d="`)[1:]
+	if len(paths) != 2 || strings.Count(paths[0], `M`) != 2 || strings.Count(paths[1], `M`) != 1 {
+		t.Errorf("the lines are %d paths, want the first broken in two and the second whole", len(paths))
+	}
+
+	empty := Chart{Title: `Nothing`, XLabels: []string{`0`}, Series: []Series{{Name: `none`, Values: []float64{math.NaN()}}}}
+	if svg := empty.Render(); !strings.Contains(svg, `No data to chart`) || strings.Contains(svg, `<path`) {
+		t.Errorf("a chart with no values does not say so")
+	}
+}
@@ -5,31 +5,19 @@
 package display
 
 import (
-	"capfront/api"
 	"capfront/fetch"
 	"capfront/models"
 	"capfront/utils"
-	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"runtime"
 	"strconv"
-	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
-// The state which follows each action.
-var nextStates = map[string]string{
-	`demand`:  `SUPPLY`,
-	`supply`:  `TRADE`,
-	`trade`:   `PRODUCE`,
-	`produce`: `CONSUME`,
-	`consume`: `INVEST`,
-	`invest`:  `DEMAND`,
-}
-
 // pages for which redirection is OK.
 func useLastVisited(last string) bool {
 	switch last {
@@ -37,19 +25,87 @@ func useLastVisited(last string) bool {
 		`/commodities`,
 		`/industries`,
 		`/classes`,
-		`/stocks`,
+		`/industry_stocks`,
+		`/class_stocks`,
+		`/trace`,
+		`/compare`,
+		`/aggregates`,
+		`/periods`,
+		`/reproduction`,
+		`/matrix`,
+		`/leontief`,
+		`/transformation`,
+		`/autorun`,
+		`/experiments`,
+		`/chart`,
 		`/`:
 		return true
 	}
 	return false
 }
 
+// Completes a state-changing request by sending the browser back to
+// whatever the user was looking at, or to the Index page if that was
+// not a display page. The 303 tells the browser to follow up with a GET,
+// so refreshing the page it lands on does not repeat the request.
+//
+// If change is not nil, it is given the view that the page showed (see
+// ViewState) and returns the view the browser should go back to. If the
+// URL of the page carried a view, the new one replaces it; otherwise
+// the page showed the user's defaults, and change is expected to have
+// moved those. Stages that are no longer in the history, because the
+// user has started a new simulation, are dropped in favour of the user's own.
+func redirectToLastVisited(ctx *gin.Context, user *models.User, change func(ViewState) ViewState) {
+	page := &url.URL{Path: "/"}
+	if last, err := url.Parse(user.LastVisitedPage); err == nil && useLastVisited(last.Path) {
+		page = last
+	}
+	query := page.Query()
+	if hasView(query) || change != nil {
+		view, err := readViewState(query, user)
+		if err != nil {
+			query.Del("viewed")
+			query.Del("compared")
+			view, err = readViewState(query, user)
+		}
+		if err == nil {
+			if change != nil {
+				view = change(view)
+			}
+			if hasView(query) {
+				for key, values := range view.values() {
+					query[key] = values
+				}
+			}
+		}
+	}
+	page.RawQuery = query.Encode()
+	target := page.RequestURI()
+	utils.Trace(utils.Purple, fmt.Sprintf("Redirecting to %s\n", target))
+	ctx.Redirect(http.StatusSeeOther, target)
+}
+
+// Records that a state-changing request was ignored because its token
+// had been used up or had expired (see models.User.ConsumeActionToken),
+// and tells the user so on the next page they look at. what describes
+// the request, as in "carry out the action demand".
+func ignoreDuplicate(user *models.User, what string) {
+	utils.Trace(utils.Yellow, fmt.Sprintf("Ignoring a duplicate request from user %s to %s\n", user.UserName, what))
+	user.Notice = fmt.Sprintf("Your request to %s was ignored, because it had already been sent, or was sent from a page that is out of date. This page shows where things stand now.", what)
+}
+
 // Handles requests for the server to take an action comprising a stage
 // of the circuit (demand,supply, trade, produce, invest), corresponding
 // to a button press. This is specified by the URL parameter 'act'.
 //
-// Having requested the action from ths server, sets 'state' to the next
-// stage of the circuit and redisplays whatever the user was looking at.
+// The request is a POST which must carry the action token of the page it
+// was sent from in the form field 'token'. A request whose token has been
+// used up is a duplicate, and is ignored with a notice to the user.
+//
+// The action is checked against the state of the simulation before it
+// reaches the server, so unknown or out-of-order actions are rejected
+// here (see fetch.Act). Once the server has carried it out, redirects
+// to whatever the user was looking at.
 func ActionHandler(ctx *gin.Context) {
 	// Comment for less detailed diagnostics
 	_, file, no, ok := runtime.Caller(1)
@@ -76,61 +132,40 @@ func ActionHandler(ctx *gin.Context) {
 	username := user.UserName
 	utils.Trace(utils.Yellow, fmt.Sprintf("User %s wants to perform action %s. Last visited page was %s\n", username, act, user.LastVisitedPage))
 
-	// Check that the server understood it.
-	_, err = api.ServerRequest(user.ApiKey, `action/`+act)
-	if err != nil {
-		utils.DisplayError(ctx, "The server could not complete the action")
+	// Ignore duplicate submissions, but show the user where things stand.
+	if !user.ConsumeActionToken(ctx.PostForm("token")) {
+		ignoreDuplicate(user, fmt.Sprintf("carry out the action %s", act))
+		redirectToLastVisited(ctx, user, nil)
+		return
 	}
 
-	// The action was taken.
-	// Advance both the TimeStamp AND the ViewedTimeStamp and create a new
-	// Dataset.This (ought to) place the next fetched dataset in the new
-	// record, preserving the previous record.
-	// Create a new dataset
-	new_dataset := models.NewDataset(user.ApiKey)
-
-	// Append it to Datasets.
-	// NOTE we are assuming it is appended as element user.TimeStamp+1
-	// but as yet I haven't found documentation confirming this.
-	user.Datasets = append(user.Datasets, &new_dataset)
-	// Set the Comparator TimeStamp to compare with the effect of the previous action
-	user.ComparatorTimeStamp = user.TimeStamp
-	// Advance the TimeStamp to refer to the effect of this action.
-	user.TimeStamp += 1
-	// Reset viewed time stamp to point to the results of this action.
-	user.ViewedTimeStamp = user.TimeStamp
-
-	// Now refresh the data from the server
-	if !fetch.FetchUserObjects(ctx, username) {
-		utils.DisplayError(ctx, "The server completed the action but did not send back any data.")
+	// Check the action against the state machine, send it to the server
+	// and fetch its results into a new Dataset. The new Dataset is committed
+	// to the user's history, and the TimeStamps advanced, only if every
+	// table arrives. Otherwise the history is left exactly as it was.
+	// The browser going away must not stop the results of an action that
+	// the server has carried out from being recorded, so the action has
+	// its own deadline (see fetch.Detach).
+	actCtx, cancel := fetch.Detach(ctx.Request.Context())
+	defer cancel()
+	state, err := fetch.Act(actCtx, user, act)
+	if err != nil {
+		utils.DisplayError(ctx, fmt.Sprintf("The action %s could not be completed", act), err)
+		return
 	}
-
-	// Set the state so that the simulation can proceed to the next action.
-	set_current_state(username, nextStates[act])
+	utils.Trace(utils.Yellow, fmt.Sprintf("User %s has completed action %s. The simulation is now in state %s\n", username, act, state))
 
 	// If the user was looking at a page that displays (but does not act),
 	// redirect to it so the user can see the result of the action.
 	// If not, redirect to the Index page.
-	visitedPageURL := strings.Split(user.LastVisitedPage, "/")
-	log.Output(1, fmt.Sprintf("The last page this user visited was %v and this was split into%v", user.LastVisitedPage, visitedPageURL))
-	if useLastVisited(user.LastVisitedPage) {
-		// utils.Trace(utils.Purple, fmt.Sprintf("User will be redirected to the last visited page which was %s\n", lastVisitedPage))
-		ctx.Request.URL.Path = user.LastVisitedPage
-	} else {
-		// utils.Trace(utils.Purple, "User will be redirected to the Index Page, because the last visited URL was not a display page")
-		ctx.Request.URL.Path = "/"
-	}
-	Router.HandleContext(ctx)
-}
-
-type CloneResult struct {
-	Message       string `json:"message"`
-	StatusCode    int    `json:"statusCode"`
-	Simulation_id int    `json:"simulation_id"`
+	redirectToLastVisited(ctx, user, ViewState.Following)
 }
 
 // Creates a new simulation for the user, from the template specified by the 'id' parameter.
 // This can be scaled up when and if login is introduced.
+//
+// Like ActionHandler, this is a POST carrying an action token, so that
+// a resubmitted form does not clone the template again.
 func CreateSimulation(ctx *gin.Context) {
 	// Comment for shorter diagnostics
 	_, file, no, ok := runtime.Caller(1)
@@ -144,53 +179,37 @@ func CreateSimulation(ctx *gin.Context) {
 	}
 	user := userobject.(*models.User)
 	username := user.UserName
-	t := ctx.Param("id")
-	id, _ := strconv.Atoi(t)
-	log.Output(1, fmt.Sprintf("Creating a simulation from template %d for user %s", id, username))
-
-	// Ask the server to create the clone and tell us the simulation id
-	body, err := api.ServerRequest(user.ApiKey, `clone/`+t)
+	id, err := strconv.Atoi(ctx.Param("id"))
 	if err != nil {
-		utils.DisplayError(ctx, fmt.Sprintf("Failed to complete clone because of %v", err))
+		utils.DisplayError(ctx, "The template id was not a number", err)
 		return
 	}
+	log.Output(1, fmt.Sprintf("Creating a simulation from template %d for user %s", id, username))
 
-	utils.Trace(utils.Green, ("Server responded to clone request with the following message:\n"))
-	utils.Trace(utils.Green, ` `+string(body))
-
-	// read the simulation id
-	var result CloneResult
-	jsonErr := json.Unmarshal(body, &result)
-	if jsonErr != nil {
-		utils.DisplayError(ctx, fmt.Sprintf("Couldn't decode the clone result because of this error:%v", jsonErr))
+	if !user.ConsumeActionToken(ctx.PostForm("token")) {
+		ignoreDuplicate(user, fmt.Sprintf("create a simulation from template %d", id))
+		ctx.Redirect(http.StatusSeeOther, "/")
 		return
 	}
 
-	// Set the current simulation
-	utils.Trace(utils.Green, fmt.Sprintf("Setting current simulation to be %d\n", result.Simulation_id))
-	user.CurrentSimulationID = result.Simulation_id
-
-	// Diagnostic - comment or uncomment as needed
-	// s, _ := json.MarshalIndent(models.Users[username], "  ", "  ")
-	// fmt.Printf("User record after creating the simulation is %s\n", string(s))
-
-	// Fetch the whole (new) dataset from the server
-	// (until now we only told the server to create it - now we want it)
-	if !fetch.FetchUserObjects(ctx, username) {
-		utils.DisplayError(ctx, "WARNING: though the server created a simulation, we could not retrieve all its data")
+	// Ask the server to create the clone, and start a new history with it.
+	// As the user moves through the circuit, each stage adds a dataset to
+	// this history, so the user can view and compare earlier stages.
+	cloneCtx, cancel := fetch.Detach(ctx.Request.Context())
+	defer cancel()
+	if _, err = fetch.Clone(cloneCtx, user, id); err != nil {
+		utils.DisplayError(ctx, "The simulation could not be created", err)
+		return
 	}
-	// Initialise the timeStamp so that we are viewing the first dataset.
-	// As the user moves through the circuit, this timestamp will move forwards.
-	// Each time we move forward, a new dataset will be created.
-	// This allows the user to view and compare with previous stages of the simulation.
-	user.ViewedTimeStamp = 0
 
-	ctx.Request.URL.Path = "/"
-	Router.HandleContext(ctx)
+	ctx.Redirect(http.StatusSeeOther, "/")
 }
 
 // Display the previous state of the simulation
 // Do nothing if we are already at the earliest stage
+//
+// The view the browser goes back to becomes the user's default, so
+// that a page opened without a view shows the same stages.
 func Back(ctx *gin.Context) {
 	utils.Trace(utils.White, "Back was requested\n")
 	userobject, ok := ctx.Get("userobject")
@@ -198,28 +217,14 @@ func Back(ctx *gin.Context) {
 		return
 	}
 	user := userobject.(*models.User)
-
-	if user.ViewedTimeStamp > 0 {
-		user.ViewedTimeStamp--
-	}
-	if user.ComparatorTimeStamp > 0 {
-		user.ComparatorTimeStamp--
-	}
-
-	utils.Trace(utils.White, fmt.Sprintf("Viewing %d with comparator %d\n", user.ViewedTimeStamp, user.ComparatorTimeStamp))
-	lastVisitedPage := user.LastVisitedPage
-
-	if useLastVisited(lastVisitedPage) {
-		ctx.Request.URL.Path = lastVisitedPage
-	} else {
-		ctx.Request.URL.Path = "/"
-	}
-	Router.HandleContext(ctx)
+	redirectToLastVisited(ctx, user, func(v ViewState) ViewState {
+		return v.Back().saveAsDefault(user)
+	})
 }
 
 // Display the next state of the simulation
 // Do nothing if we are already viewing the most recent state
-// Ensure the comparator stamp is one step behind the view stamp
+// As with Back, the new view becomes the user's default.
 func Forward(ctx *gin.Context) {
 	utils.Trace(utils.White, "Forward was requested\n")
 	userobject, ok := ctx.Get("userobject")
@@ -227,20 +232,31 @@ func Forward(ctx *gin.Context) {
 		return
 	}
 	user := userobject.(*models.User)
-	if user.ViewedTimeStamp < user.TimeStamp {
-		user.ViewedTimeStamp++
+	redirectToLastVisited(ctx, user, func(v ViewState) ViewState {
+		return v.Forward().saveAsDefault(user)
+	})
+}
+
+// Sets the magnitudes the user sees on every page that does not say
+// otherwise: quantities, values, prices, or all of them. The mode is
+// given by the URL parameter 'mode', one of those recognised by
+// models.ParseDisplayMode.
+//
+// Like Back and Forward, this is a POST, and the browser is sent back to
+// the page the user last looked at, showing the same stages in the new mode.
+func SetDisplayMode(ctx *gin.Context) {
+	userobject, ok := ctx.Get("userobject")
+	if !ok {
+		return
 	}
-	if user.ComparatorTimeStamp != 0 {
-		user.ComparatorTimeStamp++
+	user := userobject.(*models.User)
+	mode, ok := models.ParseDisplayMode(ctx.Param("mode"))
+	if !ok {
+		utils.DisplayError(ctx, "There is no such display mode", fmt.Errorf("%q should be one of all, quantities, values or prices", ctx.Param("mode")))
+		return
 	}
+	user.DisplayMode = mode
+	utils.Trace(utils.White, fmt.Sprintf("User %s now sees %s\n", user.UserName, mode.Label()))
 
-	utils.Trace(utils.White, fmt.Sprintf("Viewing %d with comparator %d\n", user.ViewedTimeStamp, user.ComparatorTimeStamp))
-	lastVisitedPage := user.LastVisitedPage
-
-	if useLastVisited(lastVisitedPage) {
-		ctx.Request.URL.Path = lastVisitedPage
-	} else {
-		ctx.Request.URL.Path = "/"
-	}
-	Router.HandleContext(ctx)
+	redirectToLastVisited(ctx, user, func(v ViewState) ViewState { return v.WithMode(mode) })
 }
@@ -6,6 +6,7 @@ package display
 import (
 	"capfront/api"
 	"capfront/models"
+	"capfront/session"
 	"capfront/utils"
 	"fmt"
 	"net/http"
@@ -17,7 +18,7 @@ import (
 func AdminDashboard(ctx *gin.Context) {
 	ctx.HTML(http.StatusOK, "admin-dashboard.html", gin.H{
 		"Title": "Admin Dashboard",
-		"users": models.AdminUserList,
+		"users": session.AdminUserList,
 	})
 }
 
@@ -44,19 +45,26 @@ func SelectUser(ctx *gin.Context) {
 	if username == "" {
 		utils.Trace(utils.Red, " ERROR: The router did not pick up a valid player name")
 	}
-	user := models.Users[username]
+	user, ok := session.Lookup(username)
+	if !ok {
+		utils.DisplayError(ctx, fmt.Sprintf("There is no user called %s", username), nil)
+		ctx.Abort()
+		return
+	}
 
 	// lock this user at the server.
 	// It's just possible someone else gets in first, so abort if this doesn't work.
-	_, err := api.ServerRequest(user.ApiKey, `admin/lock/`+username)
+	err := api.Backend.Lock(ctx, user.ApiKey, username)
 	if err != nil {
-		utils.DisplayError(ctx, fmt.Sprintf("Could not play as %s. Maybe somebody else got in first. Try again and tell me if the error persists", username))
+		utils.DisplayError(ctx, fmt.Sprintf("Could not play as %s. Maybe somebody else got in first. Try again and tell me if the error persists", username), err)
 		ctx.Abort()
 		return
 	}
 
 	// lock this user at the client
+	unlock := session.Lock(username)
 	user.IsLocked = true
+	unlock()
 
 	// Set cookie with no MaxAge and no Expiry
 	// NOTE it is claimed this will be deleted when browser closes, but it isn't.
@@ -71,12 +79,13 @@ func SelectUser(ctx *gin.Context) {
 func Lock(ctx *gin.Context) {
 	ctx.HTML(http.StatusOK, "choose-player.html", gin.H{
 		"Title":      "Choose player",
-		"adminusers": models.AdminUserList,
-		"users":      models.Users,
+		"adminusers": session.AdminUserList,
+		"users":      session.Snapshot(),
 	})
 }
 
 // Quit playing as the current user.
+// This is a POST carrying an action token, like the actions.
 //
 //	Locally, set 'IsLoggedIn'
 //	Tell the server
@@ -89,17 +98,23 @@ func Quit(ctx *gin.Context) {
 		return
 	}
 	user := userobject.(*models.User)
+	if !user.ConsumeActionToken(ctx.PostForm("token")) {
+		ignoreDuplicate(user, "quit")
+		redirectToLastVisited(ctx, user, nil)
+		return
+	}
 	user.IsLocked = false
-	_, err := api.ServerRequest(user.ApiKey, `admin/unlock/`+user.UserName) //TODO server should delete this user's simulations
+	err := api.Backend.Unlock(ctx, user.ApiKey, user.UserName) //TODO server should delete this user's simulations
 	if err != nil {
-		utils.DisplayError(ctx, fmt.Sprintf("User %s could not quit because the server objected.", user.UserName))
+		utils.DisplayError(ctx, fmt.Sprintf("User %s could not quit because the server objected.", user.UserName), err)
 		ctx.Abort()
 		return
 	}
 
 	// Delete any cookie stil hanging around
+	// This request holds the user's lock, so it redirects rather than
+	// handing itself on to the index page, which would take the lock again.
 	http.SetCookie(ctx.Writer, &http.Cookie{Name: "user", Value: user.UserName, Path: "/", MaxAge: 0})
 	utils.Trace(utils.Gray, fmt.Sprintf("%s has quit\n", user.UserName))
-	ctx.Request.URL.Path = `/`
-	Router.HandleContext(ctx)
+	ctx.Redirect(http.StatusSeeOther, `/`)
 }
@@ -0,0 +1,78 @@
+// display.aggregates.go
+// The economy as a whole, in Marx's categories.
+//
+// The page compares the aggregates at two stages, chosen by the view
+// state, and tabulates them across the history, either at every stage
+// or at the end of every period (query parameter axis=stage|period).
+// A second page lays out each period as a reproduction schema.
+
+package display
+
+import (
+	"capfront/analysis"
+	"capfront/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A row of the history table: the aggregates at one point on the axis.
+type aggregatesRow struct {
+	Label string
+	analysis.Aggregates
+}
+
+// Displays the economy-wide aggregates.
+func ShowAggregates(ctx *gin.Context) {
+	userobject, ok := ctx.Get("userobject")
+	if !ok {
+		return
+	}
+	user := userobject.(*models.User)
+	view, ok := getViewState(ctx, user)
+	if !ok {
+		return
+	}
+
+	timeStamps, labels := user.AxisPoints(view.Axis)
+	rows := make([]aggregatesRow, len(timeStamps))
+	for i, a := range analysis.AggregatesOver(user, timeStamps) {
+		rows[i] = aggregatesRow{Label: labels[i], Aggregates: a}
+	}
+
+	ctx.HTML(http.StatusOK, "aggregates.html", gin.H{
+		"Title":    "Aggregates",
+		"history":  user.History(),
+		"panel":    analysis.Compare(analysis.AggregatesAt(user, view.Viewed), analysis.AggregatesAt(user, view.Compared)),
+		"rows":     rows,
+		"view":     view,
+		"username": user.UserName,
+		"state":    user.Get_current_state(),
+		"token":    user.IssueActionToken(),
+		"notice":   user.TakeNotice(),
+	})
+}
+
+// Displays the reproduction schema of each period, and whether it
+// satisfies the conditions for simple or expanded reproduction.
+func ShowReproduction(ctx *gin.Context) {
+	userobject, ok := ctx.Get("userobject")
+	if !ok {
+		return
+	}
+	user := userobject.(*models.User)
+	view, ok := getViewState(ctx, user)
+	if !ok {
+		return
+	}
+
+	ctx.HTML(http.StatusOK, "reproduction.html", gin.H{
+		"Title":    "Reproduction",
+		"schemas":  analysis.Schemas(user),
+		"view":     view,
+		"username": user.UserName,
+		"state":    user.Get_current_state(),
+		"token":    user.IssueActionToken(),
+		"notice":   user.TakeNotice(),
+	})
+}
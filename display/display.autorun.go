@@ -0,0 +1,185 @@
+// display.autorun.go
+// Runs the circuit automatically for a chosen number of stages or
+// periods, instead of one button press at a time.
+//
+// The run goes on in the background after the request that started it
+// has returned, taking the user's lock for each stage in turn (see
+// fetch.Run), so the user can look at the history while it grows. The
+// /autorun page shows how far it has got, reloading itself until the
+// run ends. Each user has at most one run at a time.
+
+package display
+
+import (
+	"capfront/fetch"
+	"capfront/models"
+	"capfront/session"
+	"capfront/utils"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The most stages or periods that one run may be asked for.
+const maxAutoRunSteps = 1000
+
+// A run in progress, or the latest run to have ended.
+type autoRun struct {
+	progress fetch.Progress
+	cancel   context.CancelFunc
+}
+
+// The runs, by user name.
+var autoRuns = struct {
+	sync.Mutex
+	byUser map[string]*autoRun
+}{byUser: make(map[string]*autoRun)}
+
+// The progress of the user's latest run. ok is false if the user has not started one.
+func autoRunProgress(username string) (p fetch.Progress, ok bool) {
+	autoRuns.Lock()
+	defer autoRuns.Unlock()
+	run, ok := autoRuns.byUser[username]
+	if !ok {
+		return fetch.Progress{}, false
+	}
+	return run.progress, true
+}
+
+// Reads a plan from the form fields 'steps', 'unit' and 'stop' (one
+// condition on each line, which may be left empty).
+func readPlan(ctx *gin.Context) (fetch.Plan, error) {
+	plan := fetch.Plan{Unit: models.Axis(ctx.DefaultPostForm("unit", string(models.PeriodAxis)))}
+	if plan.Unit != models.StageAxis && plan.Unit != models.PeriodAxis {
+		return plan, fmt.Errorf("unit=%q should be stage or period", plan.Unit)
+	}
+	steps, err := strconv.Atoi(ctx.PostForm("steps"))
+	if err != nil || steps < 1 || steps > maxAutoRunSteps {
+		return plan, fmt.Errorf("steps=%q should be a whole number from 1 to %d", ctx.PostForm("steps"), maxAutoRunSteps)
+	}
+	plan.Steps = steps
+	for _, line := range strings.Split(ctx.PostForm("stop"), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		c, err := models.ParseCondition(line)
+		if err != nil {
+			return plan, err
+		}
+		plan.StopWhen = append(plan.StopWhen, c)
+	}
+	return plan, nil
+}
+
+// Displays the form for starting a run, and the progress of the current or latest one.
+func ShowAutoRun(ctx *gin.Context) {
+	userobject, ok := ctx.Get("userobject")
+	if !ok {
+		return
+	}
+	user := userobject.(*models.User)
+	view, ok := getViewState(ctx, user)
+	if !ok {
+		return
+	}
+	progress, started := autoRunProgress(user.UserName)
+
+	ctx.HTML(http.StatusOK, "autorun.html", gin.H{
+		"Title":       "Automatic run",
+		"progress":    progress,
+		"started":     started,
+		"running":     started && !progress.Finished,
+		"kinds":       models.ObjectKinds,
+		"comparisons": models.Comparisons,
+		"view":        view,
+		"username":    user.UserName,
+		"state":       user.Get_current_state(),
+		"token":       user.IssueActionToken(),
+		"notice":      user.TakeNotice(),
+	})
+}
+
+// Starts a run, as a POST carrying an action token, and sends
+// the browser to the progress page.
+func StartAutoRun(ctx *gin.Context) {
+	userobject, ok := ctx.Get("userobject")
+	if !ok {
+		return
+	}
+	user := userobject.(*models.User)
+	username := user.UserName
+	if !user.ConsumeActionToken(ctx.PostForm("token")) {
+		ignoreDuplicate(user, "start an automatic run")
+		ctx.Redirect(http.StatusSeeOther, "/autorun")
+		return
+	}
+	plan, err := readPlan(ctx)
+	if err != nil {
+		utils.DisplayError(ctx, "The automatic run could not be started", err)
+		return
+	}
+	if user.CurrentSimulationID == 0 {
+		utils.DisplayError(ctx, "The automatic run could not be started", errors.New("there is no simulation to run; create one from the dashboard first"))
+		return
+	}
+
+	if results, ok := experimentResults(username); ok && !results.Finished {
+		utils.DisplayError(ctx, "The automatic run could not be started", errors.New("an experiment is in progress; stop it first"))
+		return
+	}
+
+	autoRuns.Lock()
+	if run, ok := autoRuns.byUser[username]; ok && !run.progress.Finished {
+		autoRuns.Unlock()
+		utils.DisplayError(ctx, "The automatic run could not be started", errors.New("a run is already in progress; stop it first"))
+		return
+	}
+	runCtx, cancel := context.WithCancel(context.Background())
+	run := &autoRun{progress: fetch.Progress{Plan: plan, Stage: user.CurrentStage(), TimeStamp: user.TimeStamp}, cancel: cancel}
+	autoRuns.byUser[username] = run
+	autoRuns.Unlock()
+
+	// This request holds the user's lock until it returns, so the
+	// first stage waits until then.
+	plan.Lock = func() func() {
+		if unlock := session.Lock(username); unlock != nil {
+			return unlock
+		}
+		return func() {}
+	}
+	go func() {
+		defer cancel()
+		fetch.Run(runCtx, user, plan, func(p fetch.Progress) {
+			autoRuns.Lock()
+			run.progress = p
+			autoRuns.Unlock()
+		})
+	}()
+	ctx.Redirect(http.StatusSeeOther, "/autorun")
+}
+
+// Stops the user's run after the stage it is carrying out.
+func StopAutoRun(ctx *gin.Context) {
+	userobject, ok := ctx.Get("userobject")
+	if !ok {
+		return
+	}
+	user := userobject.(*models.User)
+	if !user.ConsumeActionToken(ctx.PostForm("token")) {
+		ignoreDuplicate(user, "stop the automatic run")
+		ctx.Redirect(http.StatusSeeOther, "/autorun")
+		return
+	}
+	autoRuns.Lock()
+	if run, ok := autoRuns.byUser[user.UserName]; ok {
+		run.cancel()
+	}
+	autoRuns.Unlock()
+	ctx.Redirect(http.StatusSeeOther, "/autorun")
+}
@@ -0,0 +1,178 @@
+// display.charts.go
+// Charts of simulation variables across the user's whole history.
+//
+// The chart is chosen entirely by query parameters, so that any chart
+// can be linked to or embedded:
+//
+//	kind   commodity, industry, class or simulation
+//	field  a numeric field of that kind of object (repeatable, to overlay fields)
+//	id     the object to plot (repeatable, to overlay objects; default all of them)
+//	axis   stage or period
+//
+// For example /chart?kind=commodity&id=1&field=Unit_Price&field=Unit_Value
+
+package display
+
+import (
+	"capfront/charts"
+	"capfront/models"
+	"capfront/utils"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The fields charted when the user has not chosen any.
+var defaultChartFields = map[models.ObjectKind][]string{
+	models.CommodityKind:  {`Unit_Price`, `Unit_Value`},
+	models.IndustryKind:   {`Profit_Rate`},
+	models.ClassKind:      {`Revenue`},
+	models.SimulationKind: {`Melt`},
+}
+
+// What the user asked to chart.
+type chartRequest struct {
+	Kind   models.ObjectKind
+	Fields []string
+	Ids    []int
+	Axis   models.Axis
+}
+
+// Reads a chart request from the query parameters, supplying defaults
+// for anything that is absent.
+func readChartRequest(ctx *gin.Context, user *models.User) (chartRequest, error) {
+	r := chartRequest{Kind: models.CommodityKind, Axis: models.StageAxis}
+	if kind, present := ctx.GetQuery("kind"); present {
+		k, ok := models.ParseObjectKind(kind)
+		if !ok {
+			return r, fmt.Errorf("kind=%q is not something that can be charted", kind)
+		}
+		r.Kind = k
+	}
+	switch axis := ctx.DefaultQuery("axis", string(models.StageAxis)); axis {
+	case string(models.StageAxis), string(models.PeriodAxis):
+		r.Axis = models.Axis(axis)
+	default:
+		return r, fmt.Errorf("axis=%q should be stage or period", axis)
+	}
+
+	r.Fields = ctx.QueryArray("field")
+	for _, f := range r.Fields {
+		if !r.Kind.HasField(f) {
+			return r, fmt.Errorf("a %s has no numeric field called %q", r.Kind, f)
+		}
+	}
+	if len(r.Fields) == 0 {
+		r.Fields = defaultChartFields[r.Kind]
+	}
+
+	for _, value := range ctx.QueryArray("id") {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			return r, fmt.Errorf("id=%q is not a number", value)
+		}
+		r.Ids = append(r.Ids, id)
+	}
+	if len(r.Ids) == 0 {
+		for _, o := range user.Objects(r.Kind, user.TimeStamp) {
+			r.Ids = append(r.Ids, o.Id)
+		}
+	}
+	return r, nil
+}
+
+// True if the request charts the given field. Used by the template to tick boxes.
+func (r chartRequest) HasField(field string) bool {
+	for _, f := range r.Fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// True if the request charts the object with the given id.
+func (r chartRequest) HasId(id int) bool {
+	for _, i := range r.Ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Builds the chart: one series for each object and field requested.
+func buildChart(user *models.User, r chartRequest) charts.Chart {
+	timeStamps, labels := user.AxisPoints(r.Axis)
+	names := make(map[int]string)
+	for _, o := range user.Objects(r.Kind, user.TimeStamp) {
+		names[o.Id] = o.Name
+	}
+
+	c := charts.Chart{
+		Title:   fmt.Sprintf("%s history", r.Kind),
+		XTitle:  "Stage",
+		XLabels: labels,
+	}
+	if r.Axis == models.PeriodAxis {
+		c.XTitle = "End of period"
+	}
+	for _, id := range r.Ids {
+		name, ok := names[id]
+		if !ok {
+			name = fmt.Sprintf("%s %d", r.Kind, id)
+		}
+		for _, field := range r.Fields {
+			c.Series = append(c.Series, charts.Series{
+				Name:   fmt.Sprintf("%s: %s", name, field),
+				Values: user.Series(r.Kind, id, field, timeStamps),
+			})
+		}
+	}
+	return c
+}
+
+// Displays a chart, with a form for choosing what it shows.
+func ShowChart(ctx *gin.Context) {
+	userobject, ok := ctx.Get("userobject")
+	if !ok {
+		return
+	}
+	user := userobject.(*models.User)
+	request, err := readChartRequest(ctx, user)
+	if err != nil {
+		utils.DisplayError(ctx, "This chart cannot be drawn", err)
+		return
+	}
+
+	ctx.HTML(http.StatusOK, "chart.html", gin.H{
+		"Title":    "Chart",
+		"chart":    buildChart(user, request).SVG(),
+		"svgLink":  "/chart/svg?" + ctx.Request.URL.RawQuery,
+		"request":  request,
+		"kinds":    models.ObjectKinds,
+		"fields":   request.Kind.Fields(),
+		"objects":  user.Objects(request.Kind, user.TimeStamp),
+		"username": user.UserName,
+		"state":    user.Get_current_state(),
+		"token":    user.IssueActionToken(),
+		"notice":   user.TakeNotice(),
+	})
+}
+
+// Sends a chart as an SVG image, for embedding elsewhere or saving.
+func ChartSVG(ctx *gin.Context) {
+	userobject, ok := ctx.Get("userobject")
+	if !ok {
+		return
+	}
+	user := userobject.(*models.User)
+	request, err := readChartRequest(ctx, user)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	ctx.Data(http.StatusOK, "image/svg+xml", []byte(buildChart(user, request).Render()))
+}
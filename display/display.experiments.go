@@ -0,0 +1,235 @@
+// display.experiments.go
+// Runs the same experiment on several templates, and on repeated runs
+// of each, and compares the outcomes (see package experiment).
+//
+// Like an automatic run (display.autorun.go), the experiment goes on in
+// the background, and the /experiments page shows how far it has got,
+// reloading itself until it ends. The results stay on the page, and can
+// be downloaded as CSV, until the user starts another experiment.
+
+package display
+
+import (
+	"capfront/experiment"
+	"capfront/models"
+	"capfront/session"
+	"capfront/utils"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Limits on the size of one experiment.
+const (
+	maxRepeats           = 20
+	maxExperimentPeriods = 200
+)
+
+// An experiment in progress, or the latest one to have ended.
+type experimentRun struct {
+	results experiment.Results
+	cancel  context.CancelFunc
+}
+
+// The experiments, by user name.
+var experiments = struct {
+	sync.Mutex
+	byUser map[string]*experimentRun
+}{byUser: make(map[string]*experimentRun)}
+
+// The results of the user's latest experiment. ok is false if the user has not started one.
+func experimentResults(username string) (r experiment.Results, ok bool) {
+	experiments.Lock()
+	defer experiments.Unlock()
+	run, ok := experiments.byUser[username]
+	if !ok {
+		return experiment.Results{}, false
+	}
+	return run.results, true
+}
+
+// Reads an experiment's design from the form fields 'template' and
+// 'indicator' (both repeatable), 'repeats' and 'periods'.
+func readDesign(ctx *gin.Context) (experiment.Design, error) {
+	var d experiment.Design
+	for _, value := range ctx.PostFormArray("template") {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			return d, fmt.Errorf("template=%q is not a number", value)
+		}
+		found := false
+		for _, t := range session.TemplateList {
+			if t.Id == id {
+				d.Templates = append(d.Templates, experiment.Template{Id: t.Id, Name: t.Name})
+				found = true
+			}
+		}
+		if !found {
+			return d, fmt.Errorf("there is no template %d", id)
+		}
+	}
+	if len(d.Templates) == 0 {
+		return d, errors.New("choose at least one template")
+	}
+	var err error
+	if d.Repeats, err = strconv.Atoi(ctx.PostForm("repeats")); err != nil || d.Repeats < 1 || d.Repeats > maxRepeats {
+		return d, fmt.Errorf("repeats=%q should be a whole number from 1 to %d", ctx.PostForm("repeats"), maxRepeats)
+	}
+	if d.Periods, err = strconv.Atoi(ctx.PostForm("periods")); err != nil || d.Periods < 1 || d.Periods > maxExperimentPeriods {
+		return d, fmt.Errorf("periods=%q should be a whole number from 1 to %d", ctx.PostForm("periods"), maxExperimentPeriods)
+	}
+	for _, value := range ctx.PostFormArray("indicator") {
+		i, ok := experiment.ParseIndicator(value)
+		if !ok {
+			return d, fmt.Errorf("indicator=%q is not something an experiment can measure", value)
+		}
+		d.Indicators = append(d.Indicators, i)
+	}
+	if len(d.Indicators) == 0 {
+		d.Indicators = experiment.Indicators
+	}
+	return d, nil
+}
+
+// Displays the form for designing an experiment, and the progress and
+// results of the current or latest one. The query parameter 'indicator'
+// chooses which indicator is charted.
+func ShowExperiments(ctx *gin.Context) {
+	userobject, ok := ctx.Get("userobject")
+	if !ok {
+		return
+	}
+	user := userobject.(*models.User)
+	view, ok := getViewState(ctx, user)
+	if !ok {
+		return
+	}
+	results, started := experimentResults(user.UserName)
+	charted := experiment.Growth
+	if len(results.Indicators) > 0 {
+		charted = results.Indicators[0]
+	}
+	if value, present := ctx.GetQuery("indicator"); present {
+		if charted, ok = experiment.ParseIndicator(value); !ok {
+			utils.DisplayError(ctx, "This view of the experiment does not exist", fmt.Errorf("indicator=%q is not something an experiment can measure", value))
+			return
+		}
+	}
+
+	ctx.HTML(http.StatusOK, "experiments.html", gin.H{
+		"Title":      "Experiments",
+		"results":    results,
+		"started":    started,
+		"running":    started && !results.Finished,
+		"charted":    charted,
+		"chart":      results.Chart(charted).SVG(),
+		"templates":  session.TemplateList,
+		"indicators": experiment.Indicators,
+		"view":       view,
+		"username":   user.UserName,
+		"state":      user.Get_current_state(),
+		"token":      user.IssueActionToken(),
+		"notice":     user.TakeNotice(),
+	})
+}
+
+// Starts an experiment, as a POST carrying an action token, and
+// sends the browser to the progress page.
+func StartExperiment(ctx *gin.Context) {
+	userobject, ok := ctx.Get("userobject")
+	if !ok {
+		return
+	}
+	user := userobject.(*models.User)
+	username := user.UserName
+	if !user.ConsumeActionToken(ctx.PostForm("token")) {
+		ignoreDuplicate(user, "start an experiment")
+		ctx.Redirect(http.StatusSeeOther, "/experiments")
+		return
+	}
+	design, err := readDesign(ctx)
+	if err != nil {
+		utils.DisplayError(ctx, "The experiment could not be started", err)
+		return
+	}
+	if progress, ok := autoRunProgress(username); ok && !progress.Finished {
+		utils.DisplayError(ctx, "The experiment could not be started", errors.New("an automatic run is in progress; stop it first"))
+		return
+	}
+
+	experiments.Lock()
+	if run, ok := experiments.byUser[username]; ok && !run.results.Finished {
+		experiments.Unlock()
+		utils.DisplayError(ctx, "The experiment could not be started", errors.New("an experiment is already in progress; stop it first"))
+		return
+	}
+	runCtx, cancel := context.WithCancel(context.Background())
+	run := &experimentRun{results: experiment.Results{Design: design}, cancel: cancel}
+	experiments.byUser[username] = run
+	experiments.Unlock()
+
+	// As with an automatic run, the first clone waits until this request
+	// has returned and released the user's lock.
+	design.Lock = func() func() {
+		if unlock := session.Lock(username); unlock != nil {
+			return unlock
+		}
+		return func() {}
+	}
+	go func() {
+		defer cancel()
+		experiment.Run(runCtx, user, design, func(r experiment.Results) {
+			experiments.Lock()
+			run.results = r
+			experiments.Unlock()
+		})
+	}()
+	ctx.Redirect(http.StatusSeeOther, "/experiments")
+}
+
+// Stops the user's experiment after the stage it is carrying out.
+func StopExperiment(ctx *gin.Context) {
+	userobject, ok := ctx.Get("userobject")
+	if !ok {
+		return
+	}
+	user := userobject.(*models.User)
+	if !user.ConsumeActionToken(ctx.PostForm("token")) {
+		ignoreDuplicate(user, "stop the experiment")
+		ctx.Redirect(http.StatusSeeOther, "/experiments")
+		return
+	}
+	experiments.Lock()
+	if run, ok := experiments.byUser[user.UserName]; ok {
+		run.cancel()
+	}
+	experiments.Unlock()
+	ctx.Redirect(http.StatusSeeOther, "/experiments")
+}
+
+// Sends the results of the user's latest experiment as a CSV file.
+func ExperimentCSV(ctx *gin.Context) {
+	userobject, ok := ctx.Get("userobject")
+	if !ok {
+		return
+	}
+	user := userobject.(*models.User)
+	results, started := experimentResults(user.UserName)
+	if !started {
+		ctx.String(http.StatusNotFound, "no experiment has been run yet")
+		return
+	}
+
+	ctx.Header("Content-Disposition", `attachment; filename="experiment.csv"`)
+	ctx.Header("Content-Type", "text/csv")
+	w := csv.NewWriter(ctx.Writer)
+	if err := w.WriteAll(results.Records()); err != nil {
+		utils.Trace(utils.Red, fmt.Sprintf("Could not send the experiment's results: %v\n", err))
+	}
+}
@@ -0,0 +1,51 @@
+// display.leontief.go
+// Checks the server's unit values against an independent calculation.
+//
+// The view state chooses the stage whose calculation is shown in full.
+// Every stage in the history, or the end of every period, is checked too.
+
+package display
+
+import (
+	"capfront/analysis"
+	"capfront/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A row of the history table: the calculation at one point on the axis.
+type leontiefRow struct {
+	Label string
+	analysis.LeontiefValues
+}
+
+// Displays the Leontief calculation of unit values and where the server differs from it.
+func ShowLeontief(ctx *gin.Context) {
+	userobject, ok := ctx.Get("userobject")
+	if !ok {
+		return
+	}
+	user := userobject.(*models.User)
+	view, ok := getViewState(ctx, user)
+	if !ok {
+		return
+	}
+	timeStamps, labels := user.AxisPoints(view.Axis)
+	rows := make([]leontiefRow, len(timeStamps))
+	for i, l := range analysis.UnitValuesOver(user, timeStamps) {
+		rows[i] = leontiefRow{Label: labels[i], LeontiefValues: l}
+	}
+
+	ctx.HTML(http.StatusOK, "leontief.html", gin.H{
+		"Title":    "Unit values",
+		"history":  user.History(),
+		"viewed":   analysis.UnitValuesAt(user, view.Viewed),
+		"rows":     rows,
+		"view":     view,
+		"username": user.UserName,
+		"state":    user.Get_current_state(),
+		"token":    user.IssueActionToken(),
+		"notice":   user.TakeNotice(),
+	})
+}
@@ -0,0 +1,86 @@
+// display.matrix.go
+// The input-output matrix of the industries, for viewing or download.
+//
+// The view state chooses the stages, and the query parameter 'measure'
+// chooses what the cells show: requirement, demand, size, value or price.
+
+package display
+
+import (
+	"capfront/analysis"
+	"capfront/models"
+	"capfront/utils"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Reads the measure from the query parameters. Size if there is none.
+func readMeasure(ctx *gin.Context) (analysis.Measure, error) {
+	measure := ctx.DefaultQuery("measure", string(analysis.Size))
+	m, ok := analysis.ParseMeasure(measure)
+	if !ok {
+		return m, fmt.Errorf("measure=%q should be one of requirement, demand, size, value or price", measure)
+	}
+	return m, nil
+}
+
+// Displays the input-output matrix.
+func ShowMatrix(ctx *gin.Context) {
+	userobject, ok := ctx.Get("userobject")
+	if !ok {
+		return
+	}
+	user := userobject.(*models.User)
+	view, ok := getViewState(ctx, user)
+	if !ok {
+		return
+	}
+	measure, err := readMeasure(ctx)
+	if err != nil {
+		utils.DisplayError(ctx, "This view of the simulation does not exist", err)
+		return
+	}
+
+	ctx.HTML(http.StatusOK, "matrix.html", gin.H{
+		"Title":    "Input-Output",
+		"history":  user.History(),
+		"matrix":   analysis.NewMatrix(user, view.Viewed, view.Compared, measure),
+		"measures": analysis.Measures,
+		"view":     view,
+		"username": user.UserName,
+		"state":    user.Get_current_state(),
+		"token":    user.IssueActionToken(),
+		"notice":   user.TakeNotice(),
+	})
+}
+
+// Sends the input-output matrix as a CSV file, one line for each cell.
+func MatrixCSV(ctx *gin.Context) {
+	userobject, ok := ctx.Get("userobject")
+	if !ok {
+		return
+	}
+	user := userobject.(*models.User)
+	view, err := readViewState(ctx.Request.URL.Query(), user)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	measure, err := readMeasure(ctx)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	matrix := analysis.NewMatrix(user, view.Viewed, view.Compared, measure)
+	filename := fmt.Sprintf("input-output-%s-stage-%d-vs-%d.csv", measure, view.Viewed, view.Compared)
+	ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	ctx.Header("Content-Type", "text/csv")
+	w := csv.NewWriter(ctx.Writer)
+	if err := w.WriteAll(matrix.Records(view.Viewed, view.Compared)); err != nil {
+		utils.Trace(utils.Red, fmt.Sprintf("Could not send the input-output matrix: %v\n", err))
+	}
+}
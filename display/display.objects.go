@@ -7,6 +7,7 @@ import (
 	"capfront/api"
 	"capfront/fetch"
 	"capfront/models"
+	"capfront/session"
 	"capfront/utils"
 	"encoding/json"
 	"fmt"
@@ -32,6 +33,9 @@ var Router *gin.Engine = gin.New()
 //
 //			If successful, pass on the user object and username using ctx.Set()
 //
+//			Hold the user's lock while the handlers run, so that requests
+//			made as the same user are carried out one at a time.
+//
 
 func DivertToLogin(ctx *gin.Context, message string) {
 	utils.Trace(utils.Purple, message)
@@ -40,11 +44,9 @@ func DivertToLogin(ctx *gin.Context, message string) {
 	ctx.Abort()
 }
 
-func DisplayErrorScreen(ctx *gin.Context, message string) {
+func DisplayErrorScreen(ctx *gin.Context, message string, err error) {
 	utils.Trace(utils.Red, message)
-	ctx.HTML(http.StatusBadRequest, "errors.html", gin.H{
-		"message": message,
-	})
+	utils.DisplayError(ctx, message, err)
 	ctx.Abort()
 }
 
@@ -53,9 +55,9 @@ func SynchWithServer() gin.HandlerFunc {
 
 		// Check whether the server is responding at all.
 		// Use backdoor endpoint by sending admin key
-		body, err := api.ServerRequest(models.Users["admin"].ApiKey, `admin/user/admin`)
+		body, err := api.Backend.User(ctx, `admin`)
 		if (err != nil) || (body == nil) {
-			DisplayErrorScreen(ctx, fmt.Sprintf("Sorry, we cannot reach the server because: %v\n", err))
+			DisplayErrorScreen(ctx, "Sorry, we cannot reach the server", err)
 			return
 		}
 
@@ -70,7 +72,11 @@ func SynchWithServer() gin.HandlerFunc {
 		utils.Trace(utils.BrightMagenta, fmt.Sprintf("Cookie returned: %v\n", userCookie.Value))
 		username := userCookie.Value
 
-		user := models.Users[username]
+		user, ok := session.Lookup(username)
+		if !ok {
+			DivertToLogin(ctx, fmt.Sprintf("There is no user called %s\n", username))
+			return
+		}
 		if user.ApiKey == "" {
 			DivertToLogin(ctx, "This user has no api key\n")
 			return
@@ -78,9 +84,9 @@ func SynchWithServer() gin.HandlerFunc {
 
 		// Ask the server what it knows about this user
 		// Use the admin backdoor to get the information.
-		body, err = api.ServerRequest(models.Users["admin"].ApiKey, `admin/user/`+username)
+		body, err = api.Backend.User(ctx, username)
 		if (err != nil) || (body == nil) {
-			DisplayErrorScreen(ctx, fmt.Sprintf("Sorry, the server is not functioning :%v\n", err))
+			DisplayErrorScreen(ctx, "Sorry, the server is not functioning", err)
 			return
 		}
 
@@ -101,7 +107,12 @@ func SynchWithServer() gin.HandlerFunc {
 			return
 		}
 
-		// The Server and Client agree that this user can go ahead
+		// The Server and Client agree that this user can go ahead.
+		// From here on this request changes the user's record, so it
+		// takes the user's lock, and keeps it until every handler has run.
+		// Any other request made as this user waits until then.
+		unlock := session.Lock(username)
+		defer unlock()
 		user.IsLocked = true
 		utils.Trace(utils.BrightMagenta, fmt.Sprintf(
 			"The server says the current simulation is %d; client says it is %d\n",
@@ -115,53 +126,46 @@ func SynchWithServer() gin.HandlerFunc {
 				synched_user.CurrentSimulationID,
 				user.CurrentSimulationID))
 
-			// Yes, we do need to update
-			if !fetch.FetchUserObjects(ctx, username) {
-				DivertToLogin(ctx, fmt.Sprintf("ERROR: Could not retrieve data for user %s\n", username))
+			// Yes, we do need to update. The server's simulation replaces
+			// whatever history the client had.
+			if _, err = fetch.StartHistory(ctx.Request.Context(), user); err != nil {
+				DisplayErrorScreen(ctx, fmt.Sprintf("Could not retrieve data for user %s", username), err)
 				return
 			}
+			user.CurrentSimulationID = synched_user.CurrentSimulationID
 		}
 
-		user.LastVisitedPage = ctx.Request.URL.Path
+		// Only pages that are looked at count as visited. A POST changes
+		// state and then redirects back to the page the user was looking at.
+		// The query is kept too, so that the user returns to the same view.
+		if ctx.Request.Method == http.MethodGet && useLastVisited(ctx.Request.URL.Path) {
+			user.LastVisitedPage = ctx.Request.URL.RequestURI()
+		}
 		ctx.Set("userobject", user)
 		utils.Trace(utils.BrightMagenta, fmt.Sprintf("User %s is good to go\n", username))
+		ctx.Next()
 	}
 }
 
 // Helper function to list out users and templates
 func ListData() {
-	fmt.Printf("\nTemplateList has %d elements which are:\n", len(models.TemplateList))
-	for i := 0; i < len(models.TemplateList); i++ {
-		fmt.Println(models.TemplateList[i])
+	fmt.Printf("\nTemplateList has %d elements which are:\n", len(session.TemplateList))
+	for i := 0; i < len(session.TemplateList); i++ {
+		fmt.Println(session.TemplateList[i])
 	}
 
-	fmt.Printf("\nAdminUserList has %d elements which are:\n", len(models.AdminUserList))
-	for i := 0; i < len(models.AdminUserList); i++ {
-		fmt.Println(models.AdminUserList[i])
+	fmt.Printf("\nAdminUserList has %d elements which are:\n", len(session.AdminUserList))
+	for i := 0; i < len(session.AdminUserList); i++ {
+		fmt.Println(session.AdminUserList[i])
 	}
 
-	fmt.Println("\nUsers", len(models.Users))
-	m, _ := json.MarshalIndent(models.Users, " ", " ")
+	users := session.Snapshot()
+	fmt.Println("\nUsers", len(users))
+	m, _ := json.MarshalIndent(users, " ", " ")
 	fmt.Println(string(m))
 
 }
 
-// helper function to obtain the state of the current simulation
-// to be replaced by inline call
-func Get_current_state(username string) string {
-	return models.Users[username].Get_current_state()
-}
-
-// helper function to set the state of the current simulation.
-// To be replaced by inline call
-func set_current_state(username string, new_state string) {
-	u, ok := models.Users[username]
-	if !ok {
-		return
-	}
-	u.Set_current_state(new_state)
-}
-
 // display all commodities in the current simulation
 func ShowCommodities(ctx *gin.Context) {
 	userobject, ok := ctx.Get("userobject")
@@ -169,15 +173,22 @@ func ShowCommodities(ctx *gin.Context) {
 		return
 	}
 	user := userobject.(*models.User)
+	view, ok := getViewState(ctx, user)
+	if !ok {
+		return
+	}
 	state := user.Get_current_state()
-	commodityViews := user.CommodityViews()
+	commodityViews := user.CommodityViewsBetween(view.Viewed, view.Compared)
 
 	ctx.HTML(http.StatusOK, "commodities.html", gin.H{
 		"Title":          "Commodities",
-		"commodities":    user.Commodities(),
+		"commodities":    user.Dataset(view.Viewed).Commodities.List,
 		"commodityViews": commodityViews,
+		"view":           view,
 		"username":       user.UserName,
 		"state":          state,
+		"token":          user.IssueActionToken(),
+		"notice":         user.TakeNotice(),
 	})
 }
 
@@ -188,15 +199,22 @@ func ShowIndustries(ctx *gin.Context) {
 		return
 	}
 	user := userobject.(*models.User)
+	view, ok := getViewState(ctx, user)
+	if !ok {
+		return
+	}
 	state := user.Get_current_state()
-	industryViews := user.IndustryViews()
+	industryViews := user.IndustryViewsBetween(view.Viewed, view.Compared)
 
 	ctx.HTML(http.StatusOK, "industries.html", gin.H{
 		"Title":         "Industries",
-		"industries":    user.Industries(),
+		"industries":    user.Dataset(view.Viewed).Industries.List,
 		"industryViews": industryViews,
+		"view":          view,
 		"username":      user.UserName,
 		"state":         state,
+		"token":         user.IssueActionToken(),
+		"notice":        user.TakeNotice(),
 	})
 }
 
@@ -207,18 +225,25 @@ func ShowClasses(ctx *gin.Context) {
 		return
 	}
 	user := userobject.(*models.User)
+	view, ok := getViewState(ctx, user)
+	if !ok {
+		return
+	}
 	state := user.Get_current_state()
-	classViews := user.ClassViews()
+	classViews := user.ClassViewsBetween(view.Viewed, view.Compared)
 
 	classViewAsString, _ := json.MarshalIndent(classViews, " ", " ")
 	utils.Trace(utils.Cyan, fmt.Sprintf("Class Views:\n%s\n ", string(classViewAsString)))
 
 	ctx.HTML(http.StatusOK, "classes.html", gin.H{
 		"Title":      "Classes",
-		"classes":    user.Classes(),
+		"classes":    user.Dataset(view.Viewed).Classes.List,
 		"classViews": classViews,
+		"view":       view,
 		"username":   user.UserName,
 		"state":      state,
+		"token":      user.IssueActionToken(),
+		"notice":     user.TakeNotice(),
 	})
 }
 
@@ -229,18 +254,25 @@ func ShowCommodity(ctx *gin.Context) {
 		return
 	}
 	user := userobject.(*models.User)
+	view, ok := getViewState(ctx, user)
+	if !ok {
+		return
+	}
 
 	state := user.Get_current_state()
 	id, _ := strconv.Atoi(ctx.Param("id"))
 
-	clist := *user.Commodities()
+	clist := user.Dataset(view.Viewed).Commodities.List
 	for i := 0; i < len(clist); i++ {
 		if id == clist[i].Id {
 			ctx.HTML(http.StatusOK, "commodity.html", gin.H{
 				"Title":     "Commodity",
 				"commodity": clist[i],
+				"view":      view,
 				"username":  user.UserName,
 				"state":     state,
+				"token":     user.IssueActionToken(),
+				"notice":    user.TakeNotice(),
 			})
 		}
 	}
@@ -253,16 +285,33 @@ func ShowIndustry(ctx *gin.Context) {
 		return
 	}
 	user := userobject.(*models.User)
+	view, ok := getViewState(ctx, user)
+	if !ok {
+		return
+	}
 	state := user.Get_current_state()
 	id, _ := strconv.Atoi(ctx.Param("id"))
-	ilist := *user.Industries()
+	ilist := user.Dataset(view.Viewed).Industries.List
 	for i := 0; i < len(ilist); i++ {
 		if id == ilist[i].Id {
+			var holdings []models.HoldingsTable
+			for _, v := range *user.IndustryViewsBetween(view.Viewed, view.Compared) {
+				if v.Id == id {
+					holdings = []models.HoldingsTable{
+						models.NewHoldingsTable("Means of production", v.ConstantCapitals, view.Mode),
+						models.NewHoldingsTable("Labour power", v.VariableCapitals, view.Mode),
+					}
+				}
+			}
 			ctx.HTML(http.StatusOK, "industry.html", gin.H{
 				"Title":    "Industry",
 				"industry": ilist[i],
+				"holdings": holdings,
+				"view":     view,
 				"username": user.UserName,
 				"state":    state,
+				"token":    user.IssueActionToken(),
+				"notice":   user.TakeNotice(),
 			})
 		}
 	}
@@ -275,18 +324,34 @@ func ShowClass(ctx *gin.Context) {
 		return
 	}
 	user := userobject.(*models.User)
+	view, ok := getViewState(ctx, user)
+	if !ok {
+		return
+	}
 
 	state := user.Get_current_state()
 	id, _ := strconv.Atoi(ctx.Param("id")) //TODO check user didn't do something stupid
-	list := *user.Classes()
+	list := user.Dataset(view.Viewed).Classes.List
 
 	for i := 0; i < len(list); i++ {
 		if id == list[i].Id {
+			var holdings []models.HoldingsTable
+			for _, v := range *user.ClassViewsBetween(view.Viewed, view.Compared) {
+				if v.Id == id {
+					holdings = []models.HoldingsTable{
+						models.NewHoldingsTable("Consumer goods", v.ConsumerGoods, view.Mode),
+					}
+				}
+			}
 			ctx.HTML(http.StatusOK, "class.html", gin.H{
 				"Title":    "Class",
 				"class":    list[i],
+				"holdings": holdings,
+				"view":     view,
 				"username": user.UserName,
 				"state":    state,
+				"token":    user.IssueActionToken(),
+				"notice":   user.TakeNotice(),
 			})
 		}
 	}
@@ -314,19 +379,24 @@ func ShowIndexPage(ctx *gin.Context) {
 	utils.Trace(utils.Yellow, fmt.Sprintf("Got a user from the middleware. It was %s\n", u.UserName))
 
 	// if user has no simulations, redirect to the user dashboard
+	// This request holds the user's lock, so it redirects rather than
+	// handing itself on to another handler which would take the lock again.
 	if u.CurrentSimulationID == 0 {
-		ctx.Request.URL.Path = `/user/dashboard`
-		Router.HandleContext(ctx)
-		ctx.Abort()
+		ctx.Redirect(http.StatusSeeOther, `/user/dashboard`)
+		return
 	}
 
+	view, ok := getViewState(ctx, u)
+	if !ok {
+		return
+	}
 	state := u.Get_current_state()
-	clist := u.Commodities()
-	ilist := u.Industries()
-	cllist := u.Classes()
-	commodityViews := u.CommodityViews()
-	industryViews := u.IndustryViews()
-	classViews := u.ClassViews()
+	clist := u.Dataset(view.Viewed).Commodities.List
+	ilist := u.Dataset(view.Viewed).Industries.List
+	cllist := u.Dataset(view.Viewed).Classes.List
+	commodityViews := u.CommodityViewsBetween(view.Viewed, view.Compared)
+	industryViews := u.IndustryViewsBetween(view.Viewed, view.Compared)
+	classViews := u.ClassViewsBetween(view.Viewed, view.Compared)
 
 	// industryViewAsString, _ := json.MarshalIndent(industryViews, " ", " ")
 	// utils.Trace(utils.Yellow, "  Industry view before displaying index page is\n"+string(industryViewAsString)+"/n")
@@ -339,8 +409,11 @@ func ShowIndexPage(ctx *gin.Context) {
 		"industryViews":  industryViews,
 		"classes":        cllist,
 		"classViews":     classViews,
+		"view":           view,
 		"username":       u.UserName,
 		"state":          state,
+		"token":          u.IssueActionToken(),
+		"notice":         u.TakeNotice(),
 	})
 }
 
@@ -351,8 +424,12 @@ func ShowTrace(ctx *gin.Context) {
 		return
 	}
 	user := userobject.(*models.User)
+	view, ok := getViewState(ctx, user)
+	if !ok {
+		return
+	}
 	state := user.Get_current_state()
-	tlist := *user.Traces(user.ViewedTimeStamp)
+	tlist := *user.Traces(view.Viewed)
 
 	ctx.HTML(
 		http.StatusOK,
@@ -360,8 +437,11 @@ func ShowTrace(ctx *gin.Context) {
 		gin.H{
 			"Title":    "Simulation Trace",
 			"trace":    tlist,
+			"view":     view,
 			"username": user.UserName,
 			"state":    state,
+			"token":    user.IssueActionToken(),
+			"notice":   user.TakeNotice(),
 		},
 	)
 }
@@ -384,18 +464,28 @@ func UserDashboard(ctx *gin.Context) {
 	ctx.HTML(http.StatusOK, "user-dashboard.html", gin.H{
 		"Title":       "Dashboard",
 		"simulations": slist,
-		"templates":   models.TemplateList,
+		"templates":   session.TemplateList,
 		"count":       len(slist),
 		"username":    user.UserName,
 		"state":       state,
+		"token":       user.IssueActionToken(),
+		"notice":      user.TakeNotice(),
 	})
 }
 
 // Diagnostic endpoint to display the data in the system.
+// It reports a snapshot, so it can be used while users are playing.
 func DataHandler(ctx *gin.Context) {
-	ctx.JSON(http.StatusOK, models.Users)
+	users := make(map[string]models.User)
+	for _, u := range session.Snapshot() {
+		users[u.UserName] = u
+	}
+	ctx.JSON(http.StatusOK, users)
 }
 
+// Makes one of the user's simulations the current one.
+// Like the other requests that change the user's simulations, this is
+// a POST carrying an action token, and redirects (303) to the dashboard.
 // TODO not working yet
 func SwitchSimulation(ctx *gin.Context) {
 	userobject, ok := ctx.Get("userobject")
@@ -404,18 +494,52 @@ func SwitchSimulation(ctx *gin.Context) {
 	}
 	user := userobject.(*models.User)
 	id, _ := strconv.Atoi(ctx.Param("id"))
+	if !user.ConsumeActionToken(ctx.PostForm("token")) {
+		ignoreDuplicate(user, fmt.Sprintf("switch to simulation %d", id))
+		ctx.Redirect(http.StatusSeeOther, "/user/dashboard")
+		return
+	}
 	log.Output(1, fmt.Sprintf("User %s wants to switch to simulation %d", user.UserName, id))
-	ctx.HTML(http.StatusOK, "notready.html", gin.H{
-		"Title": "Not Ready",
-	})
+	user.Notice = "Switching to another simulation is not ready yet."
+	ctx.Redirect(http.StatusSeeOther, "/user/dashboard")
 }
 
+// Deletes one of the user's simulations. A POST, like SwitchSimulation.
 // TODO not working yet
 func DeleteSimulation(ctx *gin.Context) {
+	userobject, ok := ctx.Get("userobject")
+	if !ok {
+		return
+	}
+	user := userobject.(*models.User)
+	id, _ := strconv.Atoi(ctx.Param("id"))
+	if !user.ConsumeActionToken(ctx.PostForm("token")) {
+		ignoreDuplicate(user, fmt.Sprintf("delete simulation %d", id))
+		ctx.Redirect(http.StatusSeeOther, "/user/dashboard")
+		return
+	}
+	log.Output(1, fmt.Sprintf("User %s wants to delete simulation %d", user.UserName, id))
+	user.Notice = "Deleting a simulation is not ready yet."
+	ctx.Redirect(http.StatusSeeOther, "/user/dashboard")
 }
 
+// Takes one of the user's simulations back to its start. A POST, like SwitchSimulation.
 // TODO not working yet
 func RestartSimulation(ctx *gin.Context) {
+	userobject, ok := ctx.Get("userobject")
+	if !ok {
+		return
+	}
+	user := userobject.(*models.User)
+	id, _ := strconv.Atoi(ctx.Param("id"))
+	if !user.ConsumeActionToken(ctx.PostForm("token")) {
+		ignoreDuplicate(user, fmt.Sprintf("restart simulation %d", id))
+		ctx.Redirect(http.StatusSeeOther, "/user/dashboard")
+		return
+	}
+	log.Output(1, fmt.Sprintf("User %s wants to restart simulation %d", user.UserName, id))
+	user.Notice = "Restarting a simulation is not ready yet."
+	ctx.Redirect(http.StatusSeeOther, "/user/dashboard")
 }
 
 // display all industry stocks in the current simulation
@@ -426,17 +550,25 @@ func ShowIndustryStocks(ctx *gin.Context) {
 	}
 	user := userobject.(*models.User)
 
+	view, ok := getViewState(ctx, user)
+	if !ok {
+		return
+	}
 	id, _ := strconv.Atoi(ctx.Param("id"))
 	log.Output(1, fmt.Sprintf("User %s wants to show industry stocks %d", user.UserName, id))
 
 	state := user.Get_current_state()
-	islist := *user.IndustryStocks(user.ViewedTimeStamp)
+	islist := *user.IndustryStocks(view.Viewed)
 
 	ctx.HTML(http.StatusOK, "industry_stocks.html", gin.H{
 		"Title":    "Industry Stocks",
 		"stocks":   islist,
+		"index":    user.Index(view.Viewed),
+		"view":     view,
 		"username": user.UserName,
 		"state":    state,
+		"token":    user.IssueActionToken(),
+		"notice":   user.TakeNotice(),
 	})
 }
 
@@ -448,15 +580,56 @@ func ShowClassStocks(ctx *gin.Context) {
 	}
 	user := userobject.(*models.User)
 
+	view, ok := getViewState(ctx, user)
+	if !ok {
+		return
+	}
 	id, _ := strconv.Atoi(ctx.Param("id"))
 	log.Output(1, fmt.Sprintf("User %s wants to show class stocks %d", user.UserName, id))
 	state := user.Get_current_state()
-	cslist := *user.ClassStocks(user.ViewedTimeStamp)
+	cslist := *user.ClassStocks(view.Viewed)
 
 	ctx.HTML(http.StatusOK, "class_stocks.html", gin.H{
 		"Title":    "Class Stocks",
 		"stocks":   cslist,
+		"index":    user.Index(view.Viewed),
+		"view":     view,
 		"username": user.UserName,
 		"state":    state,
+		"token":    user.IssueActionToken(),
+		"notice":   user.TakeNotice(),
+	})
+}
+
+// Display any two stages of the user's history side by side.
+// The stages are chosen by the query parameters 'viewed' and 'compared',
+// which default to the stages the user is currently viewing and comparing.
+// Each value is shown with its change since the compared stage.
+func ShowComparison(ctx *gin.Context) {
+	userobject, ok := ctx.Get("userobject")
+	if !ok {
+		return
+	}
+	user := userobject.(*models.User)
+	view, ok := getViewState(ctx, user)
+	if !ok {
+		return
+	}
+	state := user.Get_current_state()
+	viewed, compared := view.Viewed, view.Compared
+
+	ctx.HTML(http.StatusOK, "compare.html", gin.H{
+		"Title":          "Compare",
+		"history":        user.History(),
+		"viewed":         viewed,
+		"compared":       compared,
+		"commodityViews": user.CommodityViewsBetween(viewed, compared),
+		"industryViews":  user.IndustryViewsBetween(viewed, compared),
+		"classViews":     user.ClassViewsBetween(viewed, compared),
+		"view":           view,
+		"username":       user.UserName,
+		"state":          state,
+		"token":          user.IssueActionToken(),
+		"notice":         user.TakeNotice(),
 	})
 }
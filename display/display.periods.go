@@ -0,0 +1,37 @@
+// display.periods.go
+// The history summarised period by period: opening and closing
+// snapshots, the flows of each period, and growth rates per period and
+// per year.
+
+package display
+
+import (
+	"capfront/analysis"
+	"capfront/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Displays an account of each period in the user's history.
+func ShowPeriods(ctx *gin.Context) {
+	userobject, ok := ctx.Get("userobject")
+	if !ok {
+		return
+	}
+	user := userobject.(*models.User)
+	view, ok := getViewState(ctx, user)
+	if !ok {
+		return
+	}
+
+	ctx.HTML(http.StatusOK, "periods.html", gin.H{
+		"Title":    "Periods",
+		"accounts": analysis.PeriodAccounts(user),
+		"view":     view,
+		"username": user.UserName,
+		"state":    user.Get_current_state(),
+		"token":    user.IssueActionToken(),
+		"notice":   user.TakeNotice(),
+	})
+}
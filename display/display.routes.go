@@ -0,0 +1,59 @@
+// display.routes.go
+// The endpoints of the web client.
+
+package display
+
+// Registers every endpoint on the Router. Called once, at startup, after
+// any middleware that all the endpoints share has been added.
+func SetRoutes() {
+	// Admin group.
+	// These all access the api by the admin backdoor so are exempt from authorization.
+	Router.GET("/admin/reset", AdminReset)
+	Router.GET("/admin/choose-players", Lock)
+	Router.GET("/admin/play-as/:username", SelectUser)
+	Router.GET("/admin/dashboard", AdminDashboard)
+	Router.GET("/data/", DataHandler)
+
+	// The endpoints below require authorization
+	// TODO couldn't get grouping to work. Pretty sure it did not work as per spec
+	// Endpoints that change state are POSTs which redirect (303) to a GET,
+	// so that refreshing or prefetching a page never repeats them.
+
+	Router.POST("/action/:action", SynchWithServer(), ActionHandler)
+	Router.GET("/autorun", SynchWithServer(), ShowAutoRun)
+	Router.POST("/autorun", SynchWithServer(), StartAutoRun)
+	Router.POST("/autorun/stop", SynchWithServer(), StopAutoRun)
+	Router.GET("/experiments", SynchWithServer(), ShowExperiments)
+	Router.POST("/experiments", SynchWithServer(), StartExperiment)
+	Router.POST("/experiments/stop", SynchWithServer(), StopExperiment)
+	Router.GET("/experiments/csv", SynchWithServer(), ExperimentCSV)
+	Router.GET("/commodities", SynchWithServer(), ShowCommodities)
+	Router.GET("/industries", SynchWithServer(), ShowIndustries)
+	Router.GET("/classes", SynchWithServer(), ShowClasses)
+	Router.GET("/industry_stocks", SynchWithServer(), ShowIndustryStocks)
+	Router.GET("/class_stocks", SynchWithServer(), ShowClassStocks)
+	Router.GET("/trace", SynchWithServer(), ShowTrace)
+	Router.GET("/compare", SynchWithServer(), ShowComparison)
+	Router.GET("/aggregates", SynchWithServer(), ShowAggregates)
+	Router.GET("/periods", SynchWithServer(), ShowPeriods)
+	Router.GET("/reproduction", SynchWithServer(), ShowReproduction)
+	Router.GET("/matrix", SynchWithServer(), ShowMatrix)
+	Router.GET("/matrix/csv", SynchWithServer(), MatrixCSV)
+	Router.GET("/leontief", SynchWithServer(), ShowLeontief)
+	Router.GET("/transformation", SynchWithServer(), ShowTransformation)
+	Router.GET("/chart", SynchWithServer(), ShowChart)
+	Router.GET("/chart/svg", SynchWithServer(), ChartSVG)
+	Router.GET("/industry/:id", SynchWithServer(), ShowIndustry)
+	Router.GET("/commodity/:id", SynchWithServer(), ShowCommodity)
+	Router.GET("/class/:id", SynchWithServer(), ShowClass)
+	Router.POST("/user/create/:id", SynchWithServer(), CreateSimulation)
+	Router.POST("/user/switch/:id", SynchWithServer(), SwitchSimulation)
+	Router.POST("/user/delete/:id", SynchWithServer(), DeleteSimulation)
+	Router.POST("/user/restart/:id", SynchWithServer(), RestartSimulation)
+	Router.GET("/", SynchWithServer(), ShowIndexPage)
+	Router.GET("/user/dashboard", SynchWithServer(), UserDashboard)
+	Router.POST("/display/:mode", SynchWithServer(), SetDisplayMode)
+	Router.POST("/back", SynchWithServer(), Back)
+	Router.POST("/forward", SynchWithServer(), Forward)
+	Router.POST("/quit", SynchWithServer(), Quit)
+}
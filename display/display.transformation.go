@@ -0,0 +1,54 @@
+// display.transformation.go
+// How the server's prices deviate from values and from prices of production.
+//
+// The view state chooses the stage shown in full. The history table
+// follows the aggregates page: every stage, or the end of every period
+// (query parameter axis=stage|period).
+
+package display
+
+import (
+	"capfront/analysis"
+	"capfront/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A row of the history table: the transformation at one point on the axis.
+type transformationRow struct {
+	Label string
+	analysis.Transformation
+}
+
+// Displays prices beside values and prices of production, the spread of
+// profit rates, and whether the invariance conditions hold.
+func ShowTransformation(ctx *gin.Context) {
+	userobject, ok := ctx.Get("userobject")
+	if !ok {
+		return
+	}
+	user := userobject.(*models.User)
+	view, ok := getViewState(ctx, user)
+	if !ok {
+		return
+	}
+
+	timeStamps, labels := user.AxisPoints(view.Axis)
+	rows := make([]transformationRow, len(timeStamps))
+	for i, t := range analysis.TransformationsOver(user, timeStamps) {
+		rows[i] = transformationRow{Label: labels[i], Transformation: t}
+	}
+
+	ctx.HTML(http.StatusOK, "transformation.html", gin.H{
+		"Title":    "Transformation",
+		"history":  user.History(),
+		"viewed":   analysis.TransformationAt(user, view.Viewed),
+		"rows":     rows,
+		"view":     view,
+		"username": user.UserName,
+		"state":    user.Get_current_state(),
+		"token":    user.IssueActionToken(),
+		"notice":   user.TakeNotice(),
+	})
+}
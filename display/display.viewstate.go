@@ -0,0 +1,247 @@
+// display.viewstate.go
+// The state of a view: which stage is shown, which it is compared with,
+// which magnitudes are displayed, and whether the history is followed
+// stage by stage or period by period.
+//
+// This lives in the URL, so that a link to "industries at stage 7
+// compared with stage 4" shows the same thing to whoever follows it,
+// and two tabs can look at different stages without disturbing each
+// other. The fields stored in the User only supply the defaults.
+
+package display
+
+import (
+	"capfront/models"
+	"capfront/utils"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ViewState struct {
+	Viewed   int                // Indexes the user's Datasets. The stage being shown
+	Compared int                // Indexes the user's Datasets. The stage it is compared with
+	Mode     models.DisplayMode // Which magnitudes to show
+	Axis     models.Axis        // Whether to step through the history by stage or by period
+	Last     int                // The latest stage in the user's history
+	periods  []models.Period    // The periods of the user's history, for stepping by period
+}
+
+// The query parameters that carry a view state.
+var viewParams = []string{"viewed", "compared", "mode", "axis"}
+
+// True if the query gives any part of a view state.
+func hasView(query url.Values) bool {
+	for _, key := range viewParams {
+		if query.Has(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reads the view state from the query parameters 'viewed', 'compared',
+// 'mode' and 'axis', taking anything that is absent from the user's
+// record. The axis is stage unless the URL says otherwise.
+//
+//	Returns: an error if a parameter is malformed or selects no stage in the history.
+func readViewState(query url.Values, user *models.User) (ViewState, error) {
+	v := ViewState{
+		Viewed:   user.ViewedTimeStamp,
+		Compared: user.ComparatorTimeStamp,
+		Mode:     user.DisplayMode,
+		Axis:     models.StageAxis,
+		Last:     len(user.Datasets) - 1,
+		periods:  user.Periods(),
+	}
+	var err error
+	if v.Viewed, err = stageParam(query, user, "viewed", v.Viewed); err != nil {
+		return v, err
+	}
+	if v.Compared, err = stageParam(query, user, "compared", v.Compared); err != nil {
+		return v, err
+	}
+	if query.Has("mode") {
+		mode := query.Get("mode")
+		m, ok := models.ParseDisplayMode(mode)
+		if !ok {
+			return v, fmt.Errorf("mode=%q is not a display mode", mode)
+		}
+		v.Mode = m
+	}
+	switch axis := query.Get("axis"); axis {
+	case "":
+	case string(models.StageAxis), string(models.PeriodAxis):
+		v.Axis = models.Axis(axis)
+	default:
+		return v, fmt.Errorf("axis=%q should be stage or period", axis)
+	}
+	return v, nil
+}
+
+// Reads a time stamp from the query parameter key.
+// Returns fallback if the parameter is absent, and an error if it
+// does not select a stage in the user's history.
+func stageParam(query url.Values, user *models.User, key string, fallback int) (int, error) {
+	value := query.Get(key)
+	if value == "" {
+		return fallback, nil
+	}
+	timeStamp, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s=%q is not a number", key, value)
+	}
+	if !user.HasStage(timeStamp) {
+		return 0, fmt.Errorf("%s=%d is outside the history, which has stages 0 to %d", key, timeStamp, len(user.Datasets)-1)
+	}
+	return timeStamp, nil
+}
+
+// The view state as query parameters.
+func (v ViewState) values() url.Values {
+	q := url.Values{}
+	q.Set("viewed", strconv.Itoa(v.Viewed))
+	q.Set("compared", strconv.Itoa(v.Compared))
+	// The mode is always given, even when it is the user's own, because
+	// whoever follows the link may have chosen a different one.
+	q.Set("mode", v.Mode.Param())
+	q.Set("axis", string(v.Axis))
+	return q
+}
+
+// The view state as a query string, beginning with '?'.
+// Appended to a path, it gives a permanent link to this view.
+func (v ViewState) Query() string {
+	return "?" + v.values().Encode()
+}
+
+// A link to the given path showing this view.
+func (v ViewState) Link(path string) string {
+	return path + v.Query()
+}
+
+// The same view, one step earlier. On the stage axis this is the stage
+// before, compared with the stage before that; on the period axis it is
+// the end of the period before, compared with its beginning.
+func (v ViewState) Back() ViewState {
+	if v.Axis == models.PeriodAxis {
+		return v.periodStep(v.periodIndex() - 1)
+	}
+	return v.step(v.Viewed - 1)
+}
+
+// The same view, one step later, in the same way as Back. A view of
+// the start of the history, before any period has begun, moves on to
+// the first period.
+func (v ViewState) Forward() ViewState {
+	if v.Axis == models.PeriodAxis {
+		if len(v.periods) > 0 && v.Viewed <= v.periods[0].Opening {
+			return v.periodStep(0)
+		}
+		return v.periodStep(v.periodIndex() + 1)
+	}
+	return v.step(v.Viewed + 1)
+}
+
+// The same view, moved on to the latest stage if it was showing the
+// stage before. This is what the user sees after an action: a view of
+// the latest stage follows the history, while a view of an earlier one
+// stays where it is.
+func (v ViewState) Following() ViewState {
+	if v.Viewed != v.Last-1 {
+		return v
+	}
+	if v.Axis == models.PeriodAxis {
+		return v.periodStep(len(v.periods) - 1)
+	}
+	return v.step(v.Last)
+}
+
+func (v ViewState) step(timeStamp int) ViewState {
+	if timeStamp > v.Last {
+		timeStamp = v.Last
+	}
+	if timeStamp < 0 {
+		timeStamp = 0
+	}
+	v.Viewed = timeStamp
+	v.Compared = timeStamp
+	if timeStamp > 0 {
+		v.Compared = timeStamp - 1
+	}
+	return v
+}
+
+// Which of the periods the viewed stage is in. The stage that closes a
+// period counts as part of it, not of the period it opens, so that
+// stepping by period shows each period from beginning to end.
+func (v ViewState) periodIndex() int {
+	for i, p := range v.periods {
+		if p.Opening < v.Viewed && v.Viewed <= p.Closing {
+			return i
+		}
+	}
+	return 0
+}
+
+func (v ViewState) periodStep(i int) ViewState {
+	if len(v.periods) == 0 {
+		return v
+	}
+	i = max(0, min(i, len(v.periods)-1))
+	v.Viewed = v.periods[i].Closing
+	v.Compared = v.periods[i].Opening
+	return v
+}
+
+// The period that the view shows when stepping by period.
+func (v ViewState) Period() models.Period {
+	if len(v.periods) == 0 {
+		return models.Period{Number: 1}
+	}
+	return v.periods[v.periodIndex()]
+}
+
+// Makes the stages of this view the ones the user sees on a page that
+// does not say which, and returns the view unchanged.
+func (v ViewState) saveAsDefault(user *models.User) ViewState {
+	user.ViewedTimeStamp, user.ComparatorTimeStamp = v.Viewed, v.Compared
+	utils.Trace(utils.White, fmt.Sprintf("Viewing %d with comparator %d\n", v.Viewed, v.Compared))
+	return v
+}
+
+// The same stages, followed along another axis.
+func (v ViewState) WithAxis(axis models.Axis) ViewState {
+	v.Axis = axis
+	return v
+}
+
+// The same stages, displayed in another mode.
+func (v ViewState) WithMode(mode models.DisplayMode) ViewState {
+	v.Mode = mode
+	return v
+}
+
+// True if this view shows the given mode. Used by templates to choose tables.
+func (v ViewState) Shows(mode string) bool {
+	return v.Mode == models.AllMagnitudes || string(v.Mode) == mode
+}
+
+// Reads the view state for a display handler.
+// If the URL asks for a view that does not exist, shows an error and
+// returns false, and the handler should return at once.
+func getViewState(ctx *gin.Context, user *models.User) (ViewState, bool) {
+	view, err := readViewState(ctx.Request.URL.Query(), user)
+	if err != nil {
+		utils.DisplayError(ctx, "This view of the simulation does not exist", err)
+		return view, false
+	}
+	return view, true
+}
+
+// True if this view shows every magnitude, rather than one of them.
+func (v ViewState) ShowsAll() bool {
+	return v.Mode == models.AllMagnitudes
+}
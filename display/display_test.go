@@ -0,0 +1,476 @@
+package display
+
+import (
+	"capfront/api"
+	"capfront/fake"
+	"capfront/fetch"
+	"capfront/models"
+	"capfront/session"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The router, with every endpoint, talking to the fake server.
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	api.Backend = fake.NewServer()
+	fetch.Initialise()
+	Router.LoadHTMLGlob("../templates/**/*")
+	SetRoutes()
+	os.Exit(m.Run())
+}
+
+// Sends a request through the router as the named user. form, if not nil, is posted.
+func send(username string, method string, target string, form url.Values) *httptest.ResponseRecorder {
+	var req *http.Request
+	if form == nil {
+		req = httptest.NewRequest(method, target, nil)
+	} else {
+		req = httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	req.AddCookie(&http.Cookie{Name: "user", Value: username})
+	w := httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	return w
+}
+
+var tokenPattern = regexp.MustCompile(`name="token" value="([^"]*)"`)
+
+// The action token on a page, as a browser would find it in a form.
+func pageToken(t *testing.T, username string, target string) string {
+	w := send(username, http.MethodGet, target, nil)
+	match := tokenPattern.FindStringSubmatch(w.Body.String())
+	if match == nil {
+		t.Errorf("GET %s as %s: no token on the page (status %d)", target, username, w.Code)
+		return ""
+	}
+	return match[1]
+}
+
+// Logs the user in and gives them a new simulation. The user quits when the test ends.
+func startPlaying(t *testing.T, username string) {
+	t.Helper()
+	t.Cleanup(func() { send(username, http.MethodPost, "/quit", url.Values{"token": {pageToken(t, username, "/")}}) })
+	if w := send(username, http.MethodGet, "/admin/play-as/"+username, nil); w.Code >= 400 {
+		t.Fatalf("play-as %s: status %d", username, w.Code)
+	}
+	token := pageToken(t, username, "/user/dashboard")
+	if w := send(username, http.MethodPost, "/user/create/2", url.Values{"token": {token}}); w.Code != http.StatusSeeOther {
+		t.Fatalf("create as %s: status %d", username, w.Code)
+	}
+}
+
+// Two users, each with several browser tabs looking at pages, carrying
+// out actions and starting an automatic run, all at once. Run with -race.
+func TestConcurrentRequests(t *testing.T) {
+	players := []string{`alice`, `bob`}
+	for _, username := range players {
+		startPlaying(t, username)
+	}
+
+	// The automatic runs start before the tabs do, so that the tabs cannot
+	// stop them starting by moving the simulation on, or by issuing so
+	// many tokens that the one on the form that starts them expires.
+	// They go on in the background while the tabs are busy.
+	for _, username := range players {
+		autoRuns.Lock()
+		delete(autoRuns.byUser, username) // left over from an earlier -count
+		autoRuns.Unlock()
+		token := pageToken(t, username, "/autorun")
+		form := url.Values{"token": {token}, "steps": {"3"}, "unit": {"stage"}}
+		if w := send(username, http.MethodPost, "/autorun", form); w.Code != http.StatusSeeOther {
+			t.Fatalf("start an automatic run as %s: status %d", username, w.Code)
+		}
+		if _, ok := autoRunProgress(username); !ok {
+			t.Fatalf("the automatic run for %s did not start", username)
+		}
+	}
+
+	pages := []string{"/", "/commodities", "/industries", "/classes", "/industry_stocks", "/trace",
+		"/compare", "/aggregates", "/periods", "/reproduction", "/leontief", "/transformation", "/autorun", "/experiments"}
+	const tabs = 4
+	var wg sync.WaitGroup
+	for _, username := range players {
+		for tab := 0; tab < tabs; tab++ {
+			wg.Add(1)
+			go func(username string, tab int) {
+				defer wg.Done()
+				for i, page := range pages {
+					if w := send(username, http.MethodGet, page, nil); w.Code >= 500 {
+						t.Errorf("GET %s as %s: status %d", page, username, w.Code)
+					}
+
+					// Some of these are out of order, because the other tabs and
+					// the automatic run are acting too. They must be refused cleanly.
+					if i%3 == tab%3 {
+						action := models.Circuit[(i+tab)%len(models.Circuit)].Action()
+						token := pageToken(t, username, page)
+						if w := send(username, http.MethodPost, "/action/"+action, url.Values{"token": {token}}); w.Code >= 500 {
+							t.Errorf("POST /action/%s as %s: status %d", action, username, w.Code)
+						}
+					}
+				}
+			}(username, tab)
+		}
+	}
+	wg.Wait()
+
+	for _, username := range players {
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			progress, ok := autoRunProgress(username)
+			if ok && progress.Finished {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("the automatic run for %s did not finish", username)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		// The client's history must be whole, and agree with the server.
+		unlock := session.Lock(username)
+		user, _ := session.Lookup(username)
+		if len(user.Datasets) != user.TimeStamp+1 {
+			t.Errorf("%s has %d datasets at time stamp %d", username, len(user.Datasets), user.TimeStamp)
+		}
+		sim := models.NewSimulationsTable(user.ApiKey)
+		if err := sim.Fetch(context.Background()); err != nil {
+			t.Fatalf("fetch the simulations of %s: %v", username, err)
+		}
+		for _, s := range sim.List {
+			if s.Id == user.CurrentSimulationID && models.ParseStage(s.State) != user.CurrentStage() {
+				t.Errorf("the server says %s is in state %s but the client says %s", username, s.State, user.CurrentStage())
+			}
+		}
+		unlock()
+	}
+}
+
+// A POST sends the browser back to the page it came from, keeping the
+// view in its URL, and changing it as the POST asks.
+func TestRedirectKeepsTheView(t *testing.T) {
+	const username = `carol`
+	startPlaying(t, username)
+	for _, action := range []string{`demand`, `supply`} {
+		token := pageToken(t, username, "/")
+		if w := send(username, http.MethodPost, "/action/"+action, url.Values{"token": {token}}); w.Code != http.StatusSeeOther {
+			t.Fatalf("POST /action/%s: status %d", action, w.Code)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		page   string // the page looked at before the POST
+		method string
+		target string
+		want   string // where the POST should send the browser
+	}{
+		{"mode", "/industries?axis=stage&compared=0&mode=values&viewed=1", http.MethodPost, "/display/prices",
+			"/industries?axis=stage&compared=0&mode=prices&viewed=1"},
+		{"back", "/industries?axis=stage&compared=1&mode=values&viewed=2", http.MethodPost, "/back",
+			"/industries?axis=stage&compared=0&mode=values&viewed=1"},
+		{"forward", "/matrix?axis=stage&compared=0&measure=value&mode=all&viewed=1", http.MethodPost, "/forward",
+			"/matrix?axis=stage&compared=1&measure=value&mode=all&viewed=2"},
+		{"no view", "/classes", http.MethodPost, "/display/all", "/classes"},
+		{"action follows the latest stage", "/trace?axis=stage&compared=1&mode=quantities&viewed=2", http.MethodPost, "/action/trade",
+			"/trace?axis=stage&compared=2&mode=quantities&viewed=3"},
+		{"action leaves an earlier stage", "/trace?axis=stage&compared=0&mode=quantities&viewed=1", http.MethodPost, "/action/produce",
+			"/trace?axis=stage&compared=0&mode=quantities&viewed=1"},
+		{"mode is not a GET", "/classes", http.MethodGet, "/display/values", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := pageToken(t, username, tt.page)
+			w := send(username, tt.method, tt.target, url.Values{"token": {token}})
+			if tt.want == "" {
+				if w.Code != http.StatusNotFound {
+					t.Errorf("%s %s: status %d, want %d", tt.method, tt.target, w.Code, http.StatusNotFound)
+				}
+				return
+			}
+			if w.Code != http.StatusSeeOther || w.Header().Get("Location") != tt.want {
+				t.Errorf("%s %s after %s: status %d to %q, want %d to %q", tt.method, tt.target, tt.page, w.Code, w.Header().Get("Location"), http.StatusSeeOther, tt.want)
+			}
+		})
+	}
+}
+
+// A server that carries out an action, after which the browser goes away.
+type disconnectingServer struct {
+	api.SimulationBackend
+	disconnect context.CancelFunc
+}
+
+func (s disconnectingServer) Action(ctx context.Context, apiKey string, action string) error {
+	err := s.SimulationBackend.Action(ctx, apiKey, action)
+	s.disconnect()
+	return err
+}
+
+// Once the server has carried out an action, its results are recorded
+// even if the browser that asked for it has gone away.
+func TestActionOutlivesTheBrowser(t *testing.T) {
+	const username = `alice`
+	startPlaying(t, username)
+	token := pageToken(t, username, "/")
+
+	browser, disconnect := context.WithCancel(context.Background())
+	backend := api.Backend
+	api.Backend = disconnectingServer{backend, disconnect}
+	defer func() { api.Backend = backend }()
+
+	req := httptest.NewRequest(http.MethodPost, "/action/demand", strings.NewReader(url.Values{"token": {token}}.Encode())).WithContext(browser)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "user", Value: username})
+	w := httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	if w.Code != http.StatusSeeOther {
+		t.Errorf("POST /action/demand: status %d", w.Code)
+	}
+
+	unlock := session.Lock(username)
+	defer unlock()
+	user, _ := session.Lookup(username)
+	if user.TimeStamp != 1 || len(user.Datasets) != 2 || user.CurrentStage() != models.Supply {
+		t.Errorf("after the browser went away %s is at stage %d of %d, waiting to %s", username, user.TimeStamp, len(user.Datasets), user.CurrentStage().Action())
+	}
+}
+
+// Back and Forward make the view they go to the user's default, so a page
+// opened without a view shows the stages that were last shown, even when
+// the view stepped by period.
+func TestBackAndForwardMoveTheDefaults(t *testing.T) {
+	const username = `bob`
+	startPlaying(t, username)
+	for _, stage := range append(models.Circuit, models.Demand, models.Supply) {
+		token := pageToken(t, username, "/")
+		if w := send(username, http.MethodPost, "/action/"+stage.Action(), url.Values{"token": {token}}); w.Code != http.StatusSeeOther {
+			t.Fatalf("POST /action/%s: status %d", stage.Action(), w.Code)
+		}
+	}
+
+	// Period 1 runs from stage 0 to stage 6; period 2 from 6 to 8 so far.
+	tests := []struct {
+		name     string
+		page     string
+		target   string
+		want     string
+		viewed   int
+		compared int
+	}{
+		{"back a period", "/industries?axis=period&compared=6&mode=all&viewed=8", "/back",
+			"/industries?axis=period&compared=0&mode=all&viewed=6", 6, 0},
+		{"forward a period", "/industries?axis=period&compared=0&mode=all&viewed=6", "/forward",
+			"/industries?axis=period&compared=6&mode=all&viewed=8", 8, 6},
+		{"back a stage from the defaults", "/classes", "/back", "/classes", 7, 6},
+		{"forward a stage from the defaults", "/classes", "/forward", "/classes", 8, 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			send(username, http.MethodGet, tt.page, nil)
+			w := send(username, http.MethodPost, tt.target, url.Values{})
+			if w.Code != http.StatusSeeOther || w.Header().Get("Location") != tt.want {
+				t.Errorf("POST %s after %s: status %d to %q, want %d to %q", tt.target, tt.page, w.Code, w.Header().Get("Location"), http.StatusSeeOther, tt.want)
+			}
+			unlock := session.Lock(username)
+			defer unlock()
+			user, _ := session.Lookup(username)
+			if user.ViewedTimeStamp != tt.viewed || user.ComparatorTimeStamp != tt.compared {
+				t.Errorf("the defaults are stage %d compared with %d, want %d compared with %d", user.ViewedTimeStamp, user.ComparatorTimeStamp, tt.viewed, tt.compared)
+			}
+		})
+	}
+}
+
+// A form that chooses the stages of a view keeps the rest of the view,
+// rather than falling back on the user's defaults.
+func TestFormsKeepTheView(t *testing.T) {
+	const username = `carol`
+	startPlaying(t, username)
+	if w := send(username, http.MethodPost, "/display/values", url.Values{}); w.Code != http.StatusSeeOther {
+		t.Fatalf("POST /display/values: status %d", w.Code)
+	}
+	for _, page := range []string{"/compare", "/aggregates", "/matrix?measure=value", "/leontief", "/transformation"} {
+		t.Run(page, func(t *testing.T) {
+			target := page + "?"
+			if strings.Contains(page, "?") {
+				target = page + "&"
+			}
+			body := send(username, http.MethodGet, target+"axis=period&mode=all", nil).Body.String()
+			for _, field := range []string{`name="mode" value="all"`, `name="axis" value="period"`} {
+				if !strings.Contains(body, field) {
+					t.Errorf("the form on %s has no field %s", page, field)
+				}
+			}
+		})
+	}
+}
+
+// A form sent from a second tab goes ahead, while one sent twice is
+// ignored, and the user is told that it was.
+func TestTokensOfTwoTabs(t *testing.T) {
+	const username = `carol`
+	startPlaying(t, username)
+	first, second := pageToken(t, username, "/industries"), pageToken(t, username, "/classes")
+
+	tests := []struct {
+		name   string
+		action string
+		token  string
+		stage  models.Stage // the stage the simulation should then be waiting for
+		notice bool         // whether the next page should say the request was ignored
+	}{
+		{"first tab", "demand", first, models.Supply, false},
+		{"second tab", "supply", second, models.Trade, false},
+		{"first tab again", "trade", first, models.Trade, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := send(username, http.MethodPost, "/action/"+tt.action, url.Values{"token": {tt.token}}); w.Code != http.StatusSeeOther {
+				t.Fatalf("POST /action/%s: status %d", tt.action, w.Code)
+			}
+			body := send(username, http.MethodGet, "/", nil).Body.String()
+			if notice := strings.Contains(body, "was ignored"); notice != tt.notice {
+				t.Errorf("the page after the request says it was ignored: %v, want %v", notice, tt.notice)
+			}
+			unlock := session.Lock(username)
+			defer unlock()
+			user, _ := session.Lookup(username)
+			if user.CurrentStage() != tt.stage {
+				t.Errorf("the simulation is waiting to %s, want %s", user.CurrentStage().Action(), tt.stage.Action())
+			}
+		})
+	}
+
+	// The notice is shown once only.
+	if strings.Contains(send(username, http.MethodGet, "/", nil).Body.String(), "was ignored") {
+		t.Errorf("the notice was shown twice")
+	}
+}
+
+// The requests that change the user's simulations, or end the session,
+// are POSTs that carry a token, and answer with a 303.
+func TestSessionRequestsArePosts(t *testing.T) {
+	const username = `carol`
+	startPlaying(t, username)
+	tests := []struct {
+		name   string
+		target string
+		want   string // where the POST should send the browser
+	}{
+		{"switch", "/user/switch/1", "/user/dashboard"},
+		{"delete", "/user/delete/1", "/user/dashboard"},
+		{"restart", "/user/restart/1", "/user/dashboard"},
+		{"quit", "/quit", "/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := send(username, http.MethodGet, tt.target, nil); w.Code != http.StatusNotFound {
+				t.Errorf("GET %s: status %d, want %d", tt.target, w.Code, http.StatusNotFound)
+			}
+			if w := send(username, http.MethodPost, tt.target, url.Values{"token": {"stale"}}); w.Code != http.StatusSeeOther {
+				t.Errorf("POST %s with a stale token: status %d, want %d", tt.target, w.Code, http.StatusSeeOther)
+			}
+			if body := send(username, http.MethodGet, "/user/dashboard", nil).Body.String(); !strings.Contains(body, "was ignored") {
+				t.Errorf("POST %s with a stale token was not reported as ignored", tt.target)
+			}
+			token := pageToken(t, username, "/user/dashboard")
+			w := send(username, http.MethodPost, tt.target, url.Values{"token": {token}})
+			if w.Code != http.StatusSeeOther || w.Header().Get("Location") != tt.want {
+				t.Errorf("POST %s: status %d to %q, want %d to %q", tt.target, w.Code, w.Header().Get("Location"), http.StatusSeeOther, tt.want)
+			}
+		})
+	}
+
+	// The user has quit, so is no longer playing.
+	unlock := session.Lock(username)
+	defer unlock()
+	if user, _ := session.Lookup(username); user.IsLocked {
+		t.Errorf("%s is still playing after quitting", username)
+	}
+}
+
+func TestViewStateSteps(t *testing.T) {
+	// Two whole periods, and a third under way.
+	periods := []models.Period{
+		{Number: 1, Opening: 0, Closing: 6, Complete: true},
+		{Number: 2, Opening: 6, Closing: 12, Complete: true},
+		{Number: 3, Opening: 12, Closing: 14},
+	}
+	type stages struct{ viewed, compared int }
+	tests := []struct {
+		name                     string
+		axis                     models.Axis
+		from                     stages
+		back, forward, following stages
+	}{
+		{"the latest period", models.PeriodAxis, stages{14, 12}, stages{12, 6}, stages{14, 12}, stages{14, 12}},
+		{"the first period", models.PeriodAxis, stages{6, 0}, stages{6, 0}, stages{12, 6}, stages{6, 0}},
+		{"the start", models.PeriodAxis, stages{0, 0}, stages{6, 0}, stages{6, 0}, stages{0, 0}},
+		{"part way through a period", models.PeriodAxis, stages{9, 8}, stages{6, 0}, stages{14, 12}, stages{9, 8}},
+		{"the stage before the latest, by period", models.PeriodAxis, stages{13, 12}, stages{12, 6}, stages{14, 12}, stages{14, 12}},
+		{"the stage before the latest, by stage", models.StageAxis, stages{13, 12}, stages{12, 11}, stages{14, 13}, stages{14, 13}},
+		{"the start, by stage", models.StageAxis, stages{0, 0}, stages{0, 0}, stages{1, 0}, stages{0, 0}},
+		{"the latest stage", models.StageAxis, stages{14, 13}, stages{13, 12}, stages{14, 13}, stages{14, 13}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := ViewState{Viewed: tt.from.viewed, Compared: tt.from.compared, Axis: tt.axis, Last: 14, periods: periods}
+			for _, step := range []struct {
+				name string
+				got  ViewState
+				want stages
+			}{{"Back", v.Back(), tt.back}, {"Forward", v.Forward(), tt.forward}, {"Following", v.Following(), tt.following}} {
+				if got := (stages{step.got.Viewed, step.got.Compared}); got != step.want {
+					t.Errorf("%s from %v is %v, want %v", step.name, tt.from, got, step.want)
+				}
+				if step.got.Axis != tt.axis {
+					t.Errorf("%s changed the axis to %s", step.name, step.got.Axis)
+				}
+			}
+		})
+	}
+}
+
+// The comparison page shows the two stages chosen, however far apart,
+// with each value's change between them, and refuses stages the user
+// does not have.
+func TestComparePage(t *testing.T) {
+	const username = `carol`
+	startPlaying(t, username)
+	for _, stage := range models.Circuit[:4] {
+		token := pageToken(t, username, "/")
+		if w := send(username, http.MethodPost, "/action/"+stage.Action(), url.Values{"token": {token}}); w.Code != http.StatusSeeOther {
+			t.Fatalf("POST /action/%s: status %d", stage.Action(), w.Code)
+		}
+	}
+
+	w := send(username, http.MethodGet, "/compare?viewed=4&compared=1", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /compare: status %d", w.Code)
+	}
+	body := w.Body.String()
+	for _, want := range []string{`value="4" selected`, `value="1" selected`, `Department I`, `Department II`, `Workers`, `no change`, `%)`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("the comparison of stage 4 with stage 1 does not show %s", want)
+		}
+	}
+
+	for _, target := range []string{"/compare?viewed=9&compared=1", "/compare?viewed=4&compared=-1", "/compare?viewed=four"} {
+		if w := send(username, http.MethodGet, target, nil); w.Code == http.StatusOK {
+			t.Errorf("GET %s: status %d, want an error", target, w.Code)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+// experiment.go
+// Runs several simulations under one user and compares their outcomes.
+//
+// An experiment clones each of a set of templates, as many times as
+// asked, runs each clone for a number of periods (see fetch.Run), and
+// collects chosen indicators at the end of every period into one table.
+// The runs take place one after another, so each new clone replaces the
+// user's current simulation; the indicators are read from the history
+// as soon as a run ends, before the next one starts.
+//
+// A run that fails, because the server cannot clone its template or
+// cannot carry out a stage, is recorded with its error and whatever
+// periods it completed. The experiment goes on to the next run.
+
+package experiment
+
+import (
+	"capfront/analysis"
+	"capfront/charts"
+	"capfront/fetch"
+	"capfront/models"
+	"capfront/utils"
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// A quantity measured at the end of every period of a run.
+type Indicator string
+
+const (
+	Growth       Indicator = `growth`        // of the output scale of all industries, per period
+	AnnualGrowth Indicator = `annual_growth` // the same, annualised
+	ProfitRate   Indicator = `profit_rate`   // s/(c+v) in price terms, per period
+	Melt         Indicator = `melt`          // the monetary expression of labour time, at the end of the period
+)
+
+// The indicators a user can choose, in the order they are offered.
+var Indicators = []Indicator{Growth, AnnualGrowth, ProfitRate, Melt}
+
+// Converts an indicator named in a URL or on the command line into an Indicator.
+// ok is false if there is no such indicator.
+func ParseIndicator(indicator string) (i Indicator, ok bool) {
+	for _, i := range Indicators {
+		if string(i) == indicator {
+			return i, true
+		}
+	}
+	return Growth, false
+}
+
+// Describes the indicator to the user.
+func (i Indicator) Label() string {
+	switch i {
+	case AnnualGrowth:
+		return `Growth of output per year`
+	case ProfitRate:
+		return `Rate of profit`
+	case Melt:
+		return `MELT`
+	}
+	return `Growth of output per period`
+}
+
+// The indicator's value for one period.
+func (i Indicator) of(a analysis.PeriodAccount, melt float64) float64 {
+	switch i {
+	case AnnualGrowth:
+		return float64(a.OutputGrowth.Annual)
+	case ProfitRate:
+		return float64(a.RateOfProfit.PerPeriod)
+	case Melt:
+		return melt
+	}
+	return float64(a.OutputGrowth.PerPeriod)
+}
+
+// A template to be run, with the name it is shown by.
+type Template struct {
+	Id   int
+	Name string
+}
+
+// What an experiment should do.
+type Design struct {
+	Templates  []Template
+	Repeats    int // how many times to run each template
+	Periods    int // how many periods to run each time
+	Indicators []Indicator
+
+	// If set, this is held while each run changes the user's record (see fetch.Plan).
+	Lock func() (unlock func())
+}
+
+// The number of runs in the experiment.
+func (d Design) Runs() int {
+	return len(d.Templates) * d.Repeats
+}
+
+// The outcome of one run.
+type RunResult struct {
+	Template
+	Repeat       int // counting from 1
+	SimulationId int
+	Periods      int                     // the number of periods completed
+	Values       map[Indicator][]float64 // one for each completed period, earliest first
+	Reason       string                  // why the run ended
+	Err          error                   // why it failed, if it did
+}
+
+// Names the run, for example "Four Sectors #2".
+func (r RunResult) Label() string {
+	return fmt.Sprintf("%s #%d", r.Name, r.Repeat)
+}
+
+// The indicator at the end of the last completed period. NaN if there was none.
+func (r RunResult) Final(i Indicator) analysis.Number {
+	values := r.Values[i]
+	if len(values) == 0 {
+		return analysis.Number(math.NaN())
+	}
+	return analysis.Number(values[len(values)-1])
+}
+
+// The mean of the indicator over the completed periods. NaN if there were none.
+func (r RunResult) Mean(i Indicator) analysis.Number {
+	values := r.Values[i]
+	if len(values) == 0 {
+		return analysis.Number(math.NaN())
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return analysis.Number(sum / float64(len(values)))
+}
+
+// The experiment and the outcome of every run so far.
+type Results struct {
+	Design
+	Runs     []RunResult
+	Current  string // describes the run under way, if any
+	Finished bool
+	Stopped  bool // true if the experiment was stopped before every run was done
+}
+
+// The number of runs that failed.
+func (r Results) Failures() int {
+	n := 0
+	for _, run := range r.Runs {
+		if run.Err != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// How much of the experiment is done, as a percentage.
+func (r Results) Percent() int {
+	if r.Design.Runs() == 0 {
+		return 100
+	}
+	return 100 * len(r.Runs) / r.Design.Runs()
+}
+
+// Carries out the experiment for the user, calling report after each run
+// and at each stage of the run under way.
+func Run(ctx context.Context, user *models.User, d Design, report func(Results)) Results {
+	lock := d.Lock
+	if lock == nil {
+		lock = func() func() { return func() {} }
+	}
+	results := Results{Design: d}
+runs:
+	for repeat := 1; repeat <= d.Repeats; repeat++ {
+		for _, t := range d.Templates {
+			if ctx.Err() != nil {
+				results.Stopped = true
+				break runs
+			}
+			results.Current = fmt.Sprintf("%s #%d", t.Name, repeat)
+			if report != nil {
+				report(results)
+			}
+			results.Runs = append(results.Runs, runOne(ctx, user, d, t, repeat, lock, func(p fetch.Progress) {
+				if report != nil {
+					current := results
+					current.Current = fmt.Sprintf("%s #%d: %d of %d periods", t.Name, repeat, p.PeriodsDone, d.Periods)
+					report(current)
+				}
+			}))
+		}
+	}
+	results.Current, results.Finished = ``, true
+	utils.Trace(utils.Yellow, fmt.Sprintf("Experiment for %s ended after %d runs, %d of which failed\n", user.UserName, len(results.Runs), results.Failures()))
+	if report != nil {
+		report(results)
+	}
+	return results
+}
+
+// Clones one template, runs it, and reads the indicators from its history.
+func runOne(ctx context.Context, user *models.User, d Design, t Template, repeat int, lock func() func(), progress func(fetch.Progress)) (r RunResult) {
+	r = RunResult{Template: t, Repeat: repeat, Values: make(map[Indicator][]float64)}
+
+	// A run that fails in an unexpected way must not end the experiment.
+	defer func() {
+		if p := recover(); p != nil {
+			r.Err = fmt.Errorf("the run failed unexpectedly: %v", p)
+			r.Reason = `failed`
+		}
+	}()
+
+	id, err := func() (int, error) {
+		unlock := lock()
+		defer unlock()
+		cloneCtx, cancel := fetch.Detach(ctx)
+		defer cancel()
+		return fetch.Clone(cloneCtx, user, t.Id)
+	}()
+	r.SimulationId = id
+	if err != nil {
+		r.Err, r.Reason = err, `could not be started`
+		return r
+	}
+
+	plan := fetch.Plan{Steps: d.Periods, Unit: models.PeriodAxis, Lock: lock}
+	p := fetch.Run(ctx, user, plan, progress)
+	r.Reason, r.Err = p.Reason, p.Err
+
+	unlock := lock()
+	defer unlock()
+	for _, a := range analysis.PeriodAccounts(user) {
+		if !a.Complete {
+			continue
+		}
+		melt := math.NaN()
+		for _, s := range user.Dataset(a.Closing).Simulations.List {
+			if s.Id == user.CurrentSimulationID {
+				melt = float64(s.Melt)
+			}
+		}
+		for _, i := range d.Indicators {
+			r.Values[i] = append(r.Values[i], i.of(a, melt))
+		}
+		r.Periods++
+	}
+	return r
+}
+
+// The results as records for a CSV file, one for each indicator in each
+// period of each run, with a header. A failed run has a record giving
+// its error, with empty period, indicator and value.
+func (r Results) Records() [][]string {
+	records := [][]string{{`template`, `template_name`, `repeat`, `simulation`, `period`, `indicator`, `value`, `error`}}
+	for _, run := range r.Runs {
+		head := []string{strconv.Itoa(run.Id), run.Name, strconv.Itoa(run.Repeat), strconv.Itoa(run.SimulationId)}
+		for _, i := range r.Indicators {
+			for period, v := range run.Values[i] {
+				records = append(records, append(append([]string{}, head...),
+					strconv.Itoa(period+1), string(i), strconv.FormatFloat(v, 'g', -1, 64), ``))
+			}
+		}
+		if run.Err != nil {
+			records = append(records, append(append([]string{}, head...), ``, ``, ``, run.Err.Error()))
+		}
+	}
+	return records
+}
+
+// Charts one indicator: a line for each run, against the periods.
+func (r Results) Chart(i Indicator) charts.Chart {
+	c := charts.Chart{Title: i.Label(), XTitle: "End of period"}
+	for p := 1; p <= r.Periods; p++ {
+		c.XLabels = append(c.XLabels, fmt.Sprintf("P%d", p))
+	}
+	for _, run := range r.Runs {
+		values := make([]float64, r.Periods)
+		for p := range values {
+			values[p] = math.NaN()
+			if p < len(run.Values[i]) {
+				values[p] = run.Values[i][p]
+			}
+		}
+		c.Series = append(c.Series, charts.Series{Name: run.Label(), Values: values})
+	}
+	return c
+}
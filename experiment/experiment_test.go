@@ -0,0 +1,102 @@
+package experiment
+
+import (
+	"capfront/api"
+	"capfront/fake"
+	"capfront/models"
+	"context"
+	"sync"
+	"testing"
+)
+
+// A user of a fresh fake server.
+func newUser() *models.User {
+	api.Backend = fake.NewServer()
+	user := models.NewUser(`alice`, 0, `alicekey`)
+	return &user
+}
+
+func TestRunSurvivesFailures(t *testing.T) {
+	d := Design{
+		Templates:  []Template{{Id: 1, Name: `Simple`}, {Id: 99, Name: `Missing`}, {Id: 2, Name: `Expanded`}},
+		Repeats:    2,
+		Periods:    2,
+		Indicators: Indicators,
+	}
+	r := Run(context.Background(), newUser(), d, nil)
+	if !r.Finished || r.Stopped || len(r.Runs) != d.Runs() {
+		t.Fatalf("the experiment finished=%v stopped=%v after %d of %d runs", r.Finished, r.Stopped, len(r.Runs), d.Runs())
+	}
+	if r.Failures() != 2 {
+		t.Errorf("%d runs failed, want 2", r.Failures())
+	}
+	for _, run := range r.Runs {
+		want := d.Periods
+		if run.Id == 99 {
+			want = 0
+		}
+		if run.Periods != want || len(run.Values[Growth]) != want {
+			t.Errorf("%s completed %d periods with %d values, want %d", run.Label(), run.Periods, len(run.Values[Growth]), want)
+		}
+	}
+}
+
+func TestRunStops(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d := Design{Templates: []Template{{Id: 1, Name: `Simple`}, {Id: 2, Name: `Expanded`}}, Repeats: 3, Periods: 2, Indicators: Indicators}
+
+	// Stop during the first run, as the Stop button does.
+	reports := 0
+	r := Run(ctx, newUser(), d, func(r Results) {
+		cancel()
+		if len(r.Runs) > 0 && !r.Finished {
+			reports++
+		}
+	})
+	if !r.Finished || !r.Stopped {
+		t.Errorf("the experiment finished=%v stopped=%v, want both", r.Finished, r.Stopped)
+	}
+	if len(r.Runs) != 1 || reports != 0 {
+		t.Errorf("the experiment went on for %d runs and %d reports after it was stopped, want 1 run and none", len(r.Runs), reports)
+	}
+}
+
+// A server that fails in a way the client does not expect.
+type panickingServer struct {
+	api.SimulationBackend
+}
+
+func (s panickingServer) Clone(ctx context.Context, apiKey string, templateId int) ([]byte, error) {
+	if templateId == 99 {
+		panic("the server sent something the client cannot handle")
+	}
+	return s.SimulationBackend.Clone(ctx, apiKey, templateId)
+}
+
+// A run that panics while the user's lock is held must release it, or
+// every later request made as the user would wait for ever.
+func TestRunReleasesTheLockWhenARunPanics(t *testing.T) {
+	user := newUser()
+	api.Backend = panickingServer{api.Backend}
+	var mu sync.Mutex
+	d := Design{
+		Templates:  []Template{{Id: 99, Name: `Panics`}, {Id: 1, Name: `Simple`}},
+		Repeats:    1,
+		Periods:    1,
+		Indicators: Indicators,
+		Lock: func() func() {
+			if !mu.TryLock() {
+				t.Fatalf("the user's lock is still held")
+			}
+			return mu.Unlock
+		},
+	}
+	r := Run(context.Background(), user, d, nil)
+	if len(r.Runs) != 2 || r.Runs[0].Err == nil || r.Runs[1].Err != nil {
+		t.Fatalf("the runs ended with %v, want the first to fail and the second to succeed", r.Runs)
+	}
+	if !mu.TryLock() {
+		t.Errorf("the user's lock is still held after the experiment")
+	}
+}
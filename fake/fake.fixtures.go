@@ -0,0 +1,198 @@
+// fake.fixtures.go
+// Fixture data served by the fake backend.
+// Two templates in the style of Marx's reproduction schemes: one in
+// simple reproduction, where prices equal values and nothing is invested,
+// and one in expanded reproduction, with prices that deviate from values.
+// A third has two means of production and two consumer goods, so that
+// owners hold several stocks for the same purpose.
+
+package fake
+
+import "capfront/models"
+
+// Users known to the fake server. The admin user must be present,
+// because the client uses it to check that the server is alive.
+var fixtureUsers = []userRecord{
+	{UserName: `admin`, ApiKey: `adminkey`},
+	{UserName: `alice`, ApiKey: `alicekey`},
+	{UserName: `bob`, ApiKey: `bobkey`},
+	{UserName: `carol`, ApiKey: `carolkey`},
+}
+
+// Commodity ids are the same in every template.
+const (
+	meansOfProduction = 1
+	consumption       = 2
+	labourPower       = 3
+	money             = 4
+)
+
+// Value created by one unit of labour power when it is consumed in production.
+// With a unit value of 1 for labour power this gives a rate of surplus value of 100%.
+const valueCreatedPerUnitLabour = 2
+
+func fixtureTemplates() []*world {
+	return []*world{
+		reproductionTemplate(1, `Simple Reproduction`, 0, 1),
+		reproductionTemplate(2, `Expanded Reproduction`, 0.5, 1.2),
+		fourSectorTemplate(3, `Four Sectors`),
+	}
+}
+
+// Constructs a two-department template.
+//
+//	id is the template (simulation) id
+//	name is shown on the dashboard
+//	investmentRatio is the share of profit that is reinvested
+//	consumptionPrice is the unit price of consumer goods, whose unit value is 1
+func reproductionTemplate(id int, name string, investmentRatio float32, consumptionPrice float32) *world {
+	w := world{
+		sim: models.Simulation{
+			Id:                     id,
+			Name:                   name,
+			State:                  `DEMAND`,
+			Periods_Per_Year:       1,
+			Population_Growth_Rate: 0,
+			Investment_Ratio:       investmentRatio,
+			Labour_Supply_Demand:   `FLEXIBLE`,
+			Price_Response_Type:    `VALUES`,
+			Melt_Response_Type:     `VALUE-DRIVEN`,
+			Currency_Symbol:        `$`,
+			Quantity_Symbol:        `#`,
+			Melt:                   1,
+		},
+	}
+
+	w.commodities = []models.Commodity{
+		{Id: meansOfProduction, Name: `Means of Production`, Origin: `INDUSTRIAL`, Usage: `PRODUCTIVE`, Unit_Value: 1, Unit_Price: 1, Turnover_Time: 1, Display_Order: 1},
+		{Id: consumption, Name: `Consumption`, Origin: `INDUSTRIAL`, Usage: `CONSUMPTION`, Unit_Value: 1, Unit_Price: consumptionPrice, Turnover_Time: 1, Display_Order: 2},
+		{Id: labourPower, Name: `Labour Power`, Origin: `SOCIAL`, Usage: `PRODUCTIVE`, Unit_Value: 1, Unit_Price: 1, Turnover_Time: 1, Display_Order: 3},
+		{Id: money, Name: `Money`, Origin: `MONEY`, Usage: `MONEY`, Unit_Value: 1, Unit_Price: 1, Turnover_Time: 1, Display_Order: 4},
+	}
+
+	// Department I makes 6000 units of means of production using 4000c + 1000v.
+	// Department II makes 3000 units of consumer goods using 2000c + 500v.
+	w.industries = []models.Industry{
+		{Id: 1, Name: `Department I`, Output: `Means of Production`, Output_Scale: 6000},
+		{Id: 2, Name: `Department II`, Output: `Consumption`, Output_Scale: 3000},
+	}
+	w.classes = []models.Class{
+		{Id: 1, Name: `Capitalists`, Population: 100, Participation_Ratio: 0, Consumption_Ratio: 15},
+		{Id: 2, Name: `Workers`, Population: 1500, Participation_Ratio: 1, Consumption_Ratio: 1},
+	}
+
+	w.industryStocks = []models.Industry_Stock{
+		{Id: 1, Industry_id: 1, Commodity_id: money, Name: `Department I Money`, Usage_type: `Money`, Size: 5000},
+		{Id: 2, Industry_id: 1, Commodity_id: meansOfProduction, Name: `Department I Sales`, Usage_type: `Sales`, Size: 6000},
+		{Id: 3, Industry_id: 1, Commodity_id: meansOfProduction, Name: `Department I Means of Production`, Usage_type: `Production`, Requirement: 4000.0 / 6000},
+		{Id: 4, Industry_id: 1, Commodity_id: labourPower, Name: `Department I Labour Power`, Usage_type: `Production`, Requirement: 1000.0 / 6000},
+		{Id: 5, Industry_id: 2, Commodity_id: money, Name: `Department II Money`, Usage_type: `Money`, Size: 2500 * consumptionPrice},
+		{Id: 6, Industry_id: 2, Commodity_id: consumption, Name: `Department II Sales`, Usage_type: `Sales`, Size: 3000},
+		{Id: 7, Industry_id: 2, Commodity_id: meansOfProduction, Name: `Department II Means of Production`, Usage_type: `Production`, Requirement: 2000.0 / 3000},
+		{Id: 8, Industry_id: 2, Commodity_id: labourPower, Name: `Department II Labour Power`, Usage_type: `Production`, Requirement: 500.0 / 3000},
+	}
+	w.classStocks = []models.Class_Stock{
+		{Id: 1, Class_id: 1, Commodity_id: money, Name: `Capitalist Money`, Usage_type: `Money`, Size: 1500 * consumptionPrice},
+		{Id: 2, Class_id: 1, Commodity_id: consumption, Name: `Capitalist Consumption`, Usage_type: `Consumption`},
+		{Id: 3, Class_id: 2, Commodity_id: money, Name: `Worker Money`, Usage_type: `Money`},
+		{Id: 4, Class_id: 2, Commodity_id: labourPower, Name: `Worker Labour Power`, Usage_type: `Sales`, Size: 1500},
+		{Id: 5, Class_id: 2, Commodity_id: consumption, Name: `Worker Consumption`, Usage_type: `Consumption`},
+	}
+
+	w.revalue()
+	for i := range w.industries {
+		ind := &w.industries[i]
+		ind.Initial_Capital = ind.Current_Capital
+	}
+	return &w
+}
+
+// Commodity ids in the four-sector template.
+const (
+	rawMaterials = 1
+	machinery    = 2
+	necessities  = 3
+	luxuries     = 4
+	labour       = 5
+	cash         = 6
+)
+
+// Constructs a template with four industries, two making means of
+// production and two making consumer goods. Every industry uses both
+// means of production, and the capitalists consume both consumer goods.
+// Luxuries sell above their value; everything else sells at its value.
+func fourSectorTemplate(id int, name string) *world {
+	w := world{
+		sim: models.Simulation{
+			Id:                     id,
+			Name:                   name,
+			State:                  `DEMAND`,
+			Periods_Per_Year:       4,
+			Population_Growth_Rate: 0,
+			Investment_Ratio:       0.25,
+			Labour_Supply_Demand:   `FLEXIBLE`,
+			Price_Response_Type:    `VALUES`,
+			Melt_Response_Type:     `VALUE-DRIVEN`,
+			Currency_Symbol:        `$`,
+			Quantity_Symbol:        `#`,
+			Melt:                   1,
+		},
+	}
+
+	w.commodities = []models.Commodity{
+		{Id: rawMaterials, Name: `Raw Materials`, Origin: `INDUSTRIAL`, Usage: `PRODUCTIVE`, Unit_Value: 1, Unit_Price: 1, Turnover_Time: 1, Display_Order: 1},
+		{Id: machinery, Name: `Machinery`, Origin: `INDUSTRIAL`, Usage: `PRODUCTIVE`, Unit_Value: 1, Unit_Price: 1, Turnover_Time: 1, Display_Order: 2},
+		{Id: necessities, Name: `Necessities`, Origin: `INDUSTRIAL`, Usage: `CONSUMPTION`, Unit_Value: 1, Unit_Price: 1, Turnover_Time: 1, Display_Order: 3},
+		{Id: luxuries, Name: `Luxuries`, Origin: `INDUSTRIAL`, Usage: `CONSUMPTION`, Unit_Value: 1, Unit_Price: 1.5, Turnover_Time: 1, Display_Order: 4},
+		{Id: labour, Name: `Labour Power`, Origin: `SOCIAL`, Usage: `PRODUCTIVE`, Unit_Value: 1, Unit_Price: 1, Turnover_Time: 1, Display_Order: 5},
+		{Id: cash, Name: `Money`, Origin: `MONEY`, Usage: `MONEY`, Unit_Value: 1, Unit_Price: 1, Turnover_Time: 1, Display_Order: 6},
+	}
+
+	// Each industry's inputs are worth exactly its output at a rate of surplus value of 100%.
+	w.industries = []models.Industry{
+		{Id: 1, Name: `Mining`, Output: `Raw Materials`, Output_Scale: 3000},
+		{Id: 2, Name: `Engineering`, Output: `Machinery`, Output_Scale: 2000},
+		{Id: 3, Name: `Farming`, Output: `Necessities`, Output_Scale: 2000},
+		{Id: 4, Name: `Jewellery`, Output: `Luxuries`, Output_Scale: 1000},
+	}
+	w.classes = []models.Class{
+		{Id: 1, Name: `Capitalists`, Population: 100, Participation_Ratio: 0, Consumption_Ratio: 4},
+		{Id: 2, Name: `Workers`, Population: 1600, Participation_Ratio: 1, Consumption_Ratio: 1},
+	}
+
+	// Stocks of industry i: money, sales, raw materials, machinery, labour power
+	inputs := map[int][3]float32{
+		1: {1000, 500, 750},
+		2: {800, 400, 400},
+		3: {700, 600, 350},
+		4: {500, 300, 100},
+	}
+	money := map[int]float32{1: 2500, 2: 1800, 3: 1800, 4: 1000}
+	for _, ind := range w.industries {
+		output := map[int]int{1: rawMaterials, 2: machinery, 3: necessities, 4: luxuries}[ind.Id]
+		in := inputs[ind.Id]
+		base := (ind.Id - 1) * 5
+		w.industryStocks = append(w.industryStocks,
+			models.Industry_Stock{Id: base + 1, Industry_id: ind.Id, Commodity_id: cash, Name: ind.Name + ` Money`, Usage_type: `Money`, Size: money[ind.Id]},
+			models.Industry_Stock{Id: base + 2, Industry_id: ind.Id, Commodity_id: output, Name: ind.Name + ` Sales`, Usage_type: `Sales`, Size: ind.Output_Scale},
+			models.Industry_Stock{Id: base + 3, Industry_id: ind.Id, Commodity_id: rawMaterials, Name: ind.Name + ` Raw Materials`, Usage_type: `Production`, Requirement: in[0] / ind.Output_Scale},
+			models.Industry_Stock{Id: base + 4, Industry_id: ind.Id, Commodity_id: machinery, Name: ind.Name + ` Machinery`, Usage_type: `Production`, Requirement: in[1] / ind.Output_Scale},
+			models.Industry_Stock{Id: base + 5, Industry_id: ind.Id, Commodity_id: labour, Name: ind.Name + ` Labour Power`, Usage_type: `Production`, Requirement: in[2] / ind.Output_Scale},
+		)
+	}
+	w.classStocks = []models.Class_Stock{
+		{Id: 1, Class_id: 1, Commodity_id: cash, Name: `Capitalist Money`, Usage_type: `Money`, Size: 1000},
+		{Id: 2, Class_id: 1, Commodity_id: necessities, Name: `Capitalist Necessities`, Usage_type: `Consumption`},
+		{Id: 3, Class_id: 1, Commodity_id: luxuries, Name: `Capitalist Luxuries`, Usage_type: `Consumption`},
+		{Id: 4, Class_id: 2, Commodity_id: cash, Name: `Worker Money`, Usage_type: `Money`},
+		{Id: 5, Class_id: 2, Commodity_id: labour, Name: `Worker Labour Power`, Usage_type: `Sales`, Size: 1600},
+		{Id: 6, Class_id: 2, Commodity_id: necessities, Name: `Worker Necessities`, Usage_type: `Consumption`},
+	}
+
+	w.revalue()
+	for i := range w.industries {
+		ind := &w.industries[i]
+		ind.Initial_Capital = ind.Current_Capital
+	}
+	return &w
+}
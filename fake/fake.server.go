@@ -0,0 +1,269 @@
+// fake.server.go
+// An in-process SimulationBackend which serves fixture data.
+// It lets the client be run and exercised without the remote simulation-api.
+//
+// Start the client with -backend=fake to use it.
+
+package fake
+
+import (
+	"capfront/api"
+	"capfront/models"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The fake server's record of a user. Field names follow the
+// JSON that the real server sends, so the client decodes it unchanged.
+type userRecord struct {
+	UserName            string `json:"username"`
+	ApiKey              string `json:"api_key"`
+	CurrentSimulationID int    `json:"current_simulation_id"`
+	IsLocked            bool   `json:"is_locked"`
+}
+
+// The fake server.
+//
+//	Latency, if set, delays every request, to imitate a slow server.
+//	A request whose context expires during the delay fails with a Timeout.
+type Server struct {
+	Latency time.Duration
+
+	mu        sync.Mutex
+	users     map[string]*userRecord // keyed by username
+	templates []*world
+	sims      map[int]*world // keyed by simulation id
+	nextSimId int
+}
+
+// Constructor for a fake server holding the fixture users and templates.
+func NewServer() *Server {
+	s := Server{
+		users:     make(map[string]*userRecord),
+		templates: fixtureTemplates(),
+		sims:      make(map[int]*world),
+		nextSimId: 1,
+	}
+	for _, u := range fixtureUsers {
+		record := u
+		s.users[u.UserName] = &record
+	}
+
+	// Start numbering clones after the templates, so ids never collide.
+	for _, t := range s.templates {
+		if t.sim.Id >= s.nextSimId {
+			s.nextSimId = t.sim.Id + 1
+		}
+	}
+	return &s
+}
+
+// Imitates network latency and honours the caller's deadline.
+func (s *Server) wait(ctx context.Context, url string) error {
+	if s.Latency > 0 {
+		select {
+		case <-time.After(s.Latency):
+		case <-ctx.Done():
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return &api.ApiError{Kind: api.Timeout, Url: url, Message: "no response before the deadline", Err: err}
+	}
+	return nil
+}
+
+// Finds the user that owns an api key. Must be called with s.mu held.
+func (s *Server) userByKey(apiKey string, url string) (*userRecord, error) {
+	for _, u := range s.users {
+		if u.ApiKey == apiKey {
+			return u, nil
+		}
+	}
+	return nil, &api.ApiError{Kind: api.Unauthorized, Url: url, Status: http.StatusUnauthorized, Message: "unknown api key"}
+}
+
+// The user's current simulation, or nil if it has none. Must be called with s.mu held.
+func (s *Server) current(u *userRecord) *world {
+	return s.sims[u.CurrentSimulationID]
+}
+
+func encode(url string, v any) ([]byte, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, &api.ApiError{Kind: api.ServerFault, Url: url, Status: http.StatusInternalServerError, Message: err.Error(), Err: err}
+	}
+	return body, nil
+}
+
+func (s *Server) Templates(ctx context.Context) ([]byte, error) {
+	url := `templates/templates`
+	if err := s.wait(ctx, url); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]models.Simulation, len(s.templates))
+	for i, t := range s.templates {
+		list[i] = t.sim
+	}
+	return encode(url, list)
+}
+
+func (s *Server) Users(ctx context.Context) ([]byte, error) {
+	url := `admin/users`
+	if err := s.wait(ctx, url); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]userRecord, 0, len(s.users))
+	for _, u := range s.users {
+		list = append(list, *u)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].UserName < list[j].UserName })
+	return encode(url, list)
+}
+
+func (s *Server) User(ctx context.Context, username string) ([]byte, error) {
+	url := `admin/user/` + username
+	if err := s.wait(ctx, url); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[username]
+	if !ok {
+		return nil, &api.ApiError{Kind: api.NotFound, Url: url, Status: http.StatusNotFound, Message: "no such user"}
+	}
+	return encode(url, u)
+}
+
+func (s *Server) Lock(ctx context.Context, apiKey string, username string) error {
+	url := `admin/lock/` + username
+	if err := s.wait(ctx, url); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, err := s.userByKey(apiKey, url)
+	if err != nil {
+		return err
+	}
+	if u.IsLocked {
+		return &api.ApiError{Kind: api.Locked, Url: url, Status: http.StatusConflict, Message: fmt.Sprintf("%s is already locked", username)}
+	}
+	u.IsLocked = true
+	return nil
+}
+
+func (s *Server) Unlock(ctx context.Context, apiKey string, username string) error {
+	url := `admin/unlock/` + username
+	if err := s.wait(ctx, url); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, err := s.userByKey(apiKey, url)
+	if err != nil {
+		return err
+	}
+	u.IsLocked = false
+	return nil
+}
+
+func (s *Server) Clone(ctx context.Context, apiKey string, templateId int) ([]byte, error) {
+	url := fmt.Sprintf(`clone/%d`, templateId)
+	if err := s.wait(ctx, url); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, err := s.userByKey(apiKey, url)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range s.templates {
+		if t.sim.Id == templateId {
+			id := s.nextSimId
+			s.nextSimId++
+			s.sims[id] = t.clone(id, u.UserName)
+			u.CurrentSimulationID = id
+			return encode(url, map[string]any{
+				"message":       fmt.Sprintf("Simulation %d created from template %d", id, templateId),
+				"statusCode":    http.StatusOK,
+				"simulation_id": id,
+			})
+		}
+	}
+	return nil, &api.ApiError{Kind: api.NotFound, Url: url, Status: http.StatusNotFound, Message: "no such template"}
+}
+
+func (s *Server) Action(ctx context.Context, apiKey string, action string) error {
+	url := `action/` + action
+	if err := s.wait(ctx, url); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, err := s.userByKey(apiKey, url)
+	if err != nil {
+		return err
+	}
+	w := s.current(u)
+	if w == nil {
+		return &api.ApiError{Kind: api.NotFound, Url: url, Status: http.StatusNotFound, Message: "user has no current simulation"}
+	}
+	if err := w.act(action); err != nil {
+		return &api.ApiError{Kind: api.ServerFault, Url: url, Status: http.StatusBadRequest, Message: err.Error(), Err: err}
+	}
+	return nil
+}
+
+func (s *Server) Table(ctx context.Context, apiKey string, url string) ([]byte, error) {
+	if err := s.wait(ctx, url); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, err := s.userByKey(apiKey, url)
+	if err != nil {
+		return nil, err
+	}
+
+	// The list of simulations belongs to the user, not to the current simulation.
+	if url == `simulations/current` {
+		list := []models.Simulation{}
+		for _, w := range s.sims {
+			if w.owner == u.UserName {
+				list = append(list, w.sim)
+			}
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Id < list[j].Id })
+		return encode(url, list)
+	}
+
+	w := s.current(u)
+	if w == nil {
+		return []byte(`[]`), nil
+	}
+	switch url {
+	case `commodity`:
+		return encode(url, w.commodities)
+	case `industry`:
+		return encode(url, w.industries)
+	case `classes`:
+		return encode(url, w.classes)
+	case `stocks/industry`:
+		return encode(url, w.industryStocks)
+	case `stocks/class`:
+		return encode(url, w.classStocks)
+	case `trace`:
+		return encode(url, w.trace)
+	}
+	return nil, &api.ApiError{Kind: api.NotFound, Url: url, Status: http.StatusNotFound, Message: "no such endpoint"}
+}
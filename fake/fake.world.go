@@ -0,0 +1,484 @@
+// fake.world.go
+// One simulation held by the fake server, and the crude economics
+// that move it through the circuit.
+//
+// The arithmetic is deliberately simple. It is not meant to reproduce
+// the real server's results, only to produce data that changes from
+// stage to stage in a plausible way so that the client can be exercised.
+
+package fake
+
+import (
+	"capfront/models"
+	"fmt"
+)
+
+// All the objects of one simulation.
+type world struct {
+	owner          string
+	sim            models.Simulation
+	commodities    []models.Commodity
+	industries     []models.Industry
+	classes        []models.Class
+	industryStocks []models.Industry_Stock
+	classStocks    []models.Class_Stock
+	trace          []models.Trace
+}
+
+// The state that each action requires, and the state it leads to.
+var circuit = map[string]struct{ from, to string }{
+	`demand`:  {`DEMAND`, `SUPPLY`},
+	`supply`:  {`SUPPLY`, `TRADE`},
+	`trade`:   {`TRADE`, `PRODUCE`},
+	`produce`: {`PRODUCE`, `CONSUME`},
+	`consume`: {`CONSUME`, `INVEST`},
+	`invest`:  {`INVEST`, `DEMAND`},
+}
+
+// Makes a copy of a template for the given user.
+// Every object id is offset by a multiple of the new simulation id,
+// so ids are unique across the server as they would be in a database.
+func (t *world) clone(simId int, owner string) *world {
+	offset := simId * 100
+	w := world{owner: owner, sim: t.sim}
+	w.sim.Id = simId
+	w.sim.UserName = owner
+	w.sim.Time_Stamp = 0
+
+	for _, c := range t.commodities {
+		c.Id += offset
+		c.Simulation_id = int32(simId)
+		c.UserName = owner
+		w.commodities = append(w.commodities, c)
+	}
+	for _, i := range t.industries {
+		i.Id += offset
+		i.Simulation_id = int32(simId)
+		i.UserName = owner
+		w.industries = append(w.industries, i)
+	}
+	for _, c := range t.classes {
+		c.Id += offset
+		c.Simulation_id = int32(simId)
+		c.UserName = owner
+		w.classes = append(w.classes, c)
+	}
+	for _, s := range t.industryStocks {
+		s.Id += offset
+		s.Industry_id += offset
+		s.Commodity_id += offset
+		s.Simulation_id = simId
+		s.UserName = owner
+		w.industryStocks = append(w.industryStocks, s)
+	}
+	for _, s := range t.classStocks {
+		s.Id += offset
+		s.Class_id += offset
+		s.Commodity_id += offset
+		s.Simulation_id = simId
+		s.UserName = owner
+		w.classStocks = append(w.classStocks, s)
+	}
+	w.note(1, fmt.Sprintf("Simulation %d cloned from template %s", simId, t.sim.Name))
+	return &w
+}
+
+// Carries out one action, provided the simulation is in the right state for it.
+func (w *world) act(action string) error {
+	step, ok := circuit[action]
+	if !ok {
+		return fmt.Errorf("unknown action %s", action)
+	}
+	if w.sim.State != step.from {
+		return fmt.Errorf("cannot %s when the simulation is in state %s", action, w.sim.State)
+	}
+
+	w.sim.Time_Stamp++
+	w.note(1, fmt.Sprintf("Stage %d: %s", w.sim.Time_Stamp, action))
+	switch action {
+	case `demand`:
+		w.demand()
+	case `supply`:
+		w.supply()
+	case `trade`:
+		w.trade()
+	case `produce`:
+		w.produce()
+	case `consume`:
+		w.consume()
+	case `invest`:
+		w.invest()
+	}
+	w.sim.State = step.to
+	w.revalue()
+	w.stamp()
+	return nil
+}
+
+// Every production stock demands enough for its industry's output scale.
+// Every consumption stock demands enough for its class.
+func (w *world) demand() {
+	for i := range w.industryStocks {
+		s := &w.industryStocks[i]
+		if s.Usage_type == `Production` {
+			s.Demand = s.Requirement * w.industry(s.Industry_id).Output_Scale
+		}
+	}
+	for i := range w.classStocks {
+		s := &w.classStocks[i]
+		if s.Usage_type == `Consumption` {
+			c := w.class(s.Class_id)
+			s.Demand = c.Population * c.Consumption_Ratio
+		}
+	}
+	for i := range w.commodities {
+		c := &w.commodities[i]
+		c.Demand = 0
+		for _, s := range w.industryStocks {
+			if s.Commodity_id == c.Id {
+				c.Demand += s.Demand
+			}
+		}
+		for _, s := range w.classStocks {
+			if s.Commodity_id == c.Id {
+				c.Demand += s.Demand
+			}
+		}
+		c.Monetarily_Effective_Demand = c.Demand * c.Unit_Price
+		w.note(2, fmt.Sprintf("Demand for %s is %.0f", c.Name, c.Demand))
+	}
+}
+
+// Supply is whatever is in the sales stocks. If the labour supply is
+// flexible, workers offer as much labour power as is demanded.
+func (w *world) supply() {
+	for i := range w.commodities {
+		c := &w.commodities[i]
+		if c.Origin == `SOCIAL` && w.sim.Labour_Supply_Demand == `FLEXIBLE` {
+			for j := range w.classStocks {
+				s := &w.classStocks[j]
+				if s.Commodity_id == c.Id && s.Usage_type == `Sales` && s.Size < c.Demand {
+					s.Size = c.Demand
+				}
+			}
+		}
+		c.Supply = 0
+		for _, s := range w.industryStocks {
+			if s.Commodity_id == c.Id && s.Usage_type == `Sales` {
+				c.Supply += s.Size
+			}
+		}
+		for _, s := range w.classStocks {
+			if s.Commodity_id == c.Id && s.Usage_type == `Sales` {
+				c.Supply += s.Size
+			}
+		}
+		c.Allocation_Ratio = 1
+		if c.Demand > c.Supply && c.Demand > 0 {
+			c.Allocation_Ratio = c.Supply / c.Demand
+		}
+		w.note(2, fmt.Sprintf("Supply of %s is %.0f", c.Name, c.Supply))
+	}
+}
+
+// Buyers purchase what they demand, rationed by the allocation ratio
+// and by the money they have. Industries buy first, so that workers
+// have their wages before they buy consumer goods.
+func (w *world) trade() {
+	for i := range w.classes {
+		w.classes[i].Revenue = 0
+	}
+	for i := range w.industryStocks {
+		s := &w.industryStocks[i]
+		if s.Usage_type == `Production` {
+			s.Size += w.purchase(s.Commodity_id, s.Demand, w.industryMoney(s.Industry_id))
+			s.Demand = 0
+		}
+	}
+	for i := range w.classStocks {
+		s := &w.classStocks[i]
+		if s.Usage_type == `Consumption` {
+			s.Size += w.purchase(s.Commodity_id, s.Demand, w.classMoney(s.Class_id))
+			s.Demand = 0
+		}
+	}
+	for i := range w.commodities {
+		w.commodities[i].Demand = 0
+	}
+}
+
+// Moves up to 'wanted' units of a commodity from its sellers to a buyer,
+// and the corresponding money from the buyer to the sellers.
+// Returns the quantity actually bought.
+func (w *world) purchase(commodityId int, wanted float32, buyerMoney *float32) float32 {
+	c := w.commodity(commodityId)
+	wanted *= c.Allocation_Ratio
+	if c.Unit_Price > 0 && wanted*c.Unit_Price > *buyerMoney {
+		wanted = *buyerMoney / c.Unit_Price
+	}
+	bought := float32(0)
+	for i := range w.industryStocks {
+		s := &w.industryStocks[i]
+		if s.Commodity_id == commodityId && s.Usage_type == `Sales` && bought < wanted {
+			q := min(s.Size, wanted-bought)
+			s.Size -= q
+			*w.industryMoney(s.Industry_id) += q * c.Unit_Price
+			bought += q
+		}
+	}
+	for i := range w.classStocks {
+		s := &w.classStocks[i]
+		if s.Commodity_id == commodityId && s.Usage_type == `Sales` && bought < wanted {
+			q := min(s.Size, wanted-bought)
+			s.Size -= q
+			*w.classMoney(s.Class_id) += q * c.Unit_Price
+			w.class(s.Class_id).Revenue += q * c.Unit_Price
+			bought += q
+		}
+	}
+	*buyerMoney -= bought * c.Unit_Price
+	return bought
+}
+
+// Each industry produces as much as its scarcest input allows.
+// Means of production transfer their value; labour power creates new value.
+func (w *world) produce() {
+	produced := make(map[int]float32) // value added to each commodity
+	output := make(map[int]float32)   // quantity added to each commodity
+	for i := range w.industries {
+		ind := &w.industries[i]
+		sales := w.salesStock(ind.Id)
+		scale := float32(1)
+		for _, s := range w.industryStocks {
+			if s.Industry_id == ind.Id && s.Usage_type == `Production` && s.Requirement > 0 {
+				scale = min(scale, s.Size/(s.Requirement*ind.Output_Scale))
+			}
+		}
+		var value, cost float32
+		for j := range w.industryStocks {
+			s := &w.industryStocks[j]
+			if s.Industry_id != ind.Id || s.Usage_type != `Production` {
+				continue
+			}
+			used := s.Requirement * ind.Output_Scale * scale
+			c := w.commodity(s.Commodity_id)
+			if c.Origin == `SOCIAL` {
+				value += used * valueCreatedPerUnitLabour
+			} else {
+				value += used * c.Unit_Value
+			}
+			cost += used * c.Unit_Price
+			s.Size -= used
+		}
+		quantity := ind.Output_Scale * scale
+		produced[sales.Commodity_id] += value
+		output[sales.Commodity_id] += quantity
+		sales.Size += quantity
+		ind.Profit = quantity*w.commodity(sales.Commodity_id).Unit_Price - cost
+		if ind.Initial_Capital > 0 {
+			ind.Profit_Rate = ind.Profit / ind.Initial_Capital
+		}
+		w.note(2, fmt.Sprintf("%s produced %.0f %s", ind.Name, quantity, ind.Output))
+	}
+
+	// The new unit value averages the value of the remaining stock with the value just produced.
+	for i := range w.commodities {
+		c := &w.commodities[i]
+		q, ok := output[c.Id]
+		total := w.stockSize(c.Id)
+		if !ok || total == 0 {
+			continue
+		}
+		c.Unit_Value = ((total-q)*c.Unit_Value + produced[c.Id]) / total
+	}
+}
+
+// The total quantity of a commodity held in all stocks.
+func (w *world) stockSize(commodityId int) float32 {
+	var total float32
+	for _, s := range w.industryStocks {
+		if s.Commodity_id == commodityId {
+			total += s.Size
+		}
+	}
+	for _, s := range w.classStocks {
+		if s.Commodity_id == commodityId {
+			total += s.Size
+		}
+	}
+	return total
+}
+
+// Classes use up their consumer goods, and workers regain their labour power.
+func (w *world) consume() {
+	for i := range w.classStocks {
+		s := &w.classStocks[i]
+		switch s.Usage_type {
+		case `Consumption`:
+			s.Size = 0
+		case `Sales`:
+			c := w.class(s.Class_id)
+			s.Size = c.Population * c.Participation_Ratio
+		}
+	}
+}
+
+// Industries pay out the share of profit that is not invested to the
+// capitalists, and grow their output in proportion to what they invest.
+func (w *world) invest() {
+	for i := range w.industries {
+		ind := &w.industries[i]
+		money := w.industryMoney(ind.Id)
+		payout := max(0, min(*money, ind.Profit*(1-w.sim.Investment_Ratio)))
+		*money -= payout
+		for j := range w.classes {
+			if w.classes[j].Participation_Ratio == 0 {
+				*w.classMoney(w.classes[j].Id) += payout
+				break
+			}
+		}
+		ind.Output_Growth_Rate = 0
+		if ind.Initial_Capital > 0 {
+			ind.Output_Growth_Rate = max(0, ind.Profit*w.sim.Investment_Ratio/ind.Initial_Capital)
+		}
+		ind.Output_Scale *= 1 + ind.Output_Growth_Rate
+	}
+	w.revalue()
+	for i := range w.industries {
+		ind := &w.industries[i]
+		ind.Initial_Capital = ind.Current_Capital
+	}
+	for i := range w.classes {
+		c := &w.classes[i]
+		c.Population *= 1 + w.sim.Population_Growth_Rate
+	}
+}
+
+// Recomputes everything that is derived from stock sizes and unit values.
+func (w *world) revalue() {
+	for i := range w.commodities {
+		c := &w.commodities[i]
+		c.Size, c.Total_Value, c.Total_Price = 0, 0, 0
+	}
+	for i := range w.industryStocks {
+		s := &w.industryStocks[i]
+		c := w.commodity(s.Commodity_id)
+		s.Value = s.Size * c.Unit_Value
+		s.Price = s.Size * c.Unit_Price
+		c.Size += s.Size
+		c.Total_Value += s.Value
+		c.Total_Price += s.Price
+	}
+	for i := range w.classStocks {
+		s := &w.classStocks[i]
+		c := w.commodity(s.Commodity_id)
+		s.Value = s.Size * c.Unit_Value
+		s.Price = s.Size * c.Unit_Price
+		c.Size += s.Size
+		c.Total_Value += s.Value
+		c.Total_Price += s.Price
+	}
+	for i := range w.industries {
+		ind := &w.industries[i]
+		ind.Current_Capital = 0
+		ind.Work_In_Progress = 0
+		for _, s := range w.industryStocks {
+			if s.Industry_id == ind.Id {
+				ind.Current_Capital += s.Price
+			}
+		}
+	}
+	for i := range w.classes {
+		c := &w.classes[i]
+		c.Assets = 0
+		for _, s := range w.classStocks {
+			if s.Class_id == c.Id {
+				c.Assets += s.Price
+			}
+		}
+	}
+}
+
+// Copies the simulation's time stamp onto all its objects.
+func (w *world) stamp() {
+	for i := range w.commodities {
+		w.commodities[i].Time_Stamp = int32(w.sim.Time_Stamp)
+	}
+	for i := range w.industries {
+		w.industries[i].Time_Stamp = w.sim.Time_Stamp
+	}
+	for i := range w.classes {
+		w.classes[i].Time_Stamp = w.sim.Time_Stamp
+	}
+}
+
+// Appends a message to the simulation's trace.
+func (w *world) note(level int, message string) {
+	w.trace = append(w.trace, models.Trace{
+		Id:            len(w.trace) + 1,
+		Simulation_id: w.sim.Id,
+		Time_stamp:    w.sim.Time_Stamp,
+		UserName:      w.owner,
+		Level:         level,
+		Message:       message,
+	})
+}
+
+// Lookup helpers. The fixtures are tiny, so linear searches are fine.
+
+func (w *world) commodity(id int) *models.Commodity {
+	for i := range w.commodities {
+		if w.commodities[i].Id == id {
+			return &w.commodities[i]
+		}
+	}
+	panic(fmt.Sprintf("fake server: no commodity with id %d", id))
+}
+
+func (w *world) industry(id int) *models.Industry {
+	for i := range w.industries {
+		if w.industries[i].Id == id {
+			return &w.industries[i]
+		}
+	}
+	panic(fmt.Sprintf("fake server: no industry with id %d", id))
+}
+
+func (w *world) class(id int) *models.Class {
+	for i := range w.classes {
+		if w.classes[i].Id == id {
+			return &w.classes[i]
+		}
+	}
+	panic(fmt.Sprintf("fake server: no class with id %d", id))
+}
+
+func (w *world) salesStock(industryId int) *models.Industry_Stock {
+	for i := range w.industryStocks {
+		s := &w.industryStocks[i]
+		if s.Industry_id == industryId && s.Usage_type == `Sales` {
+			return s
+		}
+	}
+	panic(fmt.Sprintf("fake server: industry %d has no sales stock", industryId))
+}
+
+func (w *world) industryMoney(industryId int) *float32 {
+	for i := range w.industryStocks {
+		s := &w.industryStocks[i]
+		if s.Industry_id == industryId && s.Usage_type == `Money` {
+			return &s.Size
+		}
+	}
+	panic(fmt.Sprintf("fake server: industry %d has no money stock", industryId))
+}
+
+func (w *world) classMoney(classId int) *float32 {
+	for i := range w.classStocks {
+		s := &w.classStocks[i]
+		if s.Class_id == classId && s.Usage_type == `Money` {
+			return &s.Size
+		}
+	}
+	panic(fmt.Sprintf("fake server: class %d has no money stock", classId))
+}
@@ -0,0 +1,129 @@
+// fetch.actions.go
+// Carries out the actions that take a simulation through its circuit,
+// keeping the client's record of the simulation's state in step with the server.
+
+package fetch
+
+import (
+	"capfront/api"
+	"capfront/models"
+	"capfront/utils"
+	"context"
+	"fmt"
+	"log"
+)
+
+// The time allowed for an action or a clone, together with the refresh
+// that records its result.
+var ActionTimeout = 2 * RefreshTimeout
+
+// A context in which to carry out an action or a clone requested in ctx.
+//
+// Once the server has carried out an action, the client must record its
+// result, or the client's history falls behind the server's until the
+// next resync. So the context is not cancelled when ctx is (because the
+// browser has gone away, say, or an automatic run has been stopped), but
+// it does expire after ActionTimeout.
+func Detach(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), ActionTimeout)
+}
+
+// Carries out one stage of the circuit for a user, and records the
+// result as the next stage of the user's history.
+//
+// The action is checked against the state of the user's current
+// simulation before the server is asked to do anything. If the client
+// thinks the action is out of order, it first resynchronises with the
+// server in case its own record is stale.
+//
+// After the action, the state reported by the server is compared with
+// the stage that should follow. If they disagree, the server wins and
+// the disagreement is logged.
+//
+//	Returns: the state the simulation is now in.
+//	Returns: a *models.StageError if the action was rejected before reaching the server.
+//	Returns: an error wrapping an *api.ApiError if the server failed.
+func Act(ctx context.Context, user *models.User, action string) (models.Stage, error) {
+	current := user.CurrentStage()
+	stage, err := models.CheckAction(current, action)
+	if err != nil && models.ParseAction(action) != models.Unknown {
+		utils.Trace(utils.Yellow, fmt.Sprintf("Client thinks %s cannot %s in state %s. Checking with the server\n", user.UserName, action, current))
+		if resyncErr := ResyncState(ctx, user); resyncErr != nil {
+			return current, resyncErr
+		}
+		current = user.CurrentStage()
+		stage, err = models.CheckAction(current, action)
+	}
+	if err != nil {
+		return current, err
+	}
+
+	if err = api.Backend.Action(ctx, user.ApiKey, stage.Action()); err != nil {
+		return current, fmt.Errorf("the server could not %s: %w", stage.Action(), err)
+	}
+
+	if _, err = AppendDataset(ctx, user); err != nil {
+		return current, err
+	}
+
+	// The simulation list arrived with the new dataset, so it tells us
+	// what the server now thinks the state is.
+	expected := stage.Next()
+	actual := user.CurrentStage()
+	if actual != expected {
+		log.Output(1, fmt.Sprintf("After %s the server says the state of simulation %d is %s but the client expected %s. Using the server's state",
+			stage.Action(), user.CurrentSimulationID, actual, expected))
+	}
+	return actual, nil
+}
+
+// Replaces the client's list of the user's simulations, and hence the
+// state of the current simulation, with what the server says.
+// Nothing changes if the server cannot be reached.
+func ResyncState(ctx context.Context, user *models.User) error {
+	sim := models.NewSimulationsTable(user.ApiKey)
+	if err := sim.Fetch(ctx); err != nil {
+		return fmt.Errorf("could not check the simulation state with the server: %w", err)
+	}
+	user.Sim = sim
+	utils.Trace(utils.Yellow, fmt.Sprintf("Resynchronised: the server says %s's simulation is in state %s\n", user.UserName, user.CurrentStage()))
+	return nil
+}
+
+// The server's reply to a request to clone a template.
+type CloneResult struct {
+	Message       string `json:"message"`
+	StatusCode    int    `json:"statusCode"`
+	Simulation_id int    `json:"simulation_id"`
+}
+
+// Asks the server to create a new simulation for the user from a
+// template, makes it the user's current simulation, and starts a new
+// history with it.
+//
+//	Returns: the id of the new simulation.
+//	Returns: an error if the server could not clone the template, or if
+//	it did but the new simulation could not be loaded.
+func Clone(ctx context.Context, user *models.User, templateId int) (int, error) {
+	body, err := api.Backend.Clone(ctx, user.ApiKey, templateId)
+	if err != nil {
+		return 0, fmt.Errorf("the server could not clone template %d: %w", templateId, err)
+	}
+	utils.Trace(utils.Green, ("Server responded to clone request with the following message:\n"))
+	utils.Trace(utils.Green, ` `+string(body))
+
+	var result CloneResult
+	if err = api.Decode(`clone`, body, &result); err != nil {
+		return 0, fmt.Errorf("could not decode the clone result: %w", err)
+	}
+
+	utils.Trace(utils.Green, fmt.Sprintf("Setting current simulation to be %d\n", result.Simulation_id))
+	user.CurrentSimulationID = result.Simulation_id
+
+	// Until now we only told the server to create the simulation; now
+	// we fetch it, so the new history begins with it.
+	if _, err = StartHistory(ctx, user); err != nil {
+		return result.Simulation_id, fmt.Errorf("though the server created simulation %d, we could not retrieve all its data: %w", result.Simulation_id, err)
+	}
+	return result.Simulation_id, nil
+}
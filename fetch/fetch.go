@@ -11,50 +11,216 @@ package fetch
 import (
 	"capfront/api"
 	"capfront/models"
+	"capfront/session"
 	"capfront/utils"
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The time allowed for a complete refresh of all tables, however many there are.
+var RefreshTimeout = 10 * time.Second
+
+// What happened to one table during a refresh.
+type TableState int
 
-	"github.com/gin-gonic/gin"
+const (
+	Fetched   TableState = iota // The table was retrieved and decoded
+	Failed                      // The table could not be retrieved or decoded
+	Cancelled                   // The fetch was abandoned because another table failed
 )
 
-// Iterates through ApiList to refresh all user objects for one user
-//
-//	Returns: false if any table fails.
-//	Returns: true if all tables succeed.
-func FetchUserObjects(ctx *gin.Context, username string) bool {
-	user := models.Users[username]
-	if !user.Sim.Fetch() {
-		utils.Trace(utils.Red, "Sim did not fetch\n")
+func (t TableState) String() string {
+	switch t {
+	case Fetched:
+		return "fetched"
+	case Failed:
+		return "failed"
+	case Cancelled:
+		return "cancelled"
 	}
-	// Reminder: a dataset is a repository for all objects at one stage of the simulation.
-	dataSet := *user.Datasets[user.TimeStamp]
+	return "unknown"
+}
 
-	for key, value := range dataSet {
-		if !value.Fetch() {
-			log.Output(1, fmt.Sprintf("Could not retrieve server data for the new dataset with key %s\n", key))
+// The outcome of fetching one table.
+type TableStatus struct {
+	Key      string        // The key of the table in the dataset ("simulation" for the user's simulation list)
+	State    TableState    // What happened
+	Err      error         // The error, if it did not succeed
+	Duration time.Duration // How long it took
+}
+
+// The outcome of a refresh, with one entry per table, in key order.
+type RefreshReport struct {
+	Tables []TableStatus
+}
+
+// True if every table was fetched.
+func (r RefreshReport) OK() bool {
+	for _, t := range r.Tables {
+		if t.State != Fetched {
+			return false
 		}
 	}
-	utils.Trace(utils.White, "Refresh complete\n")
 	return true
 }
 
+// One line per table, for diagnostics.
+func (r RefreshReport) String() string {
+	var b strings.Builder
+	for _, t := range r.Tables {
+		fmt.Fprintf(&b, "  %-16s %-9s %6dms", t.Key, t.State, t.Duration.Milliseconds())
+		if t.Err != nil {
+			fmt.Fprintf(&b, " %v", t.Err)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// Refreshes all user objects for the given user: the list of the user's
+// simulations, and every table in the dataset at the user's TimeStamp.
+//
+//	Returns: a report giving the status of each table.
+//	Returns: nil if all tables succeed.
+//	Returns: the first hard failure otherwise.
+//	The error wraps the *api.ApiError so callers can tell what went wrong.
+func FetchUserObjects(ctx context.Context, user *models.User) (RefreshReport, error) {
+	// Reminder: a dataset is a repository for all objects at one stage of the simulation.
+	tables := user.Dataset(user.TimeStamp).Tables()
+	tables["simulation"] = &user.Sim
+	return FetchTables(ctx, tables)
+}
+
+// Fetches a set of tables in parallel, under a single deadline of RefreshTimeout.
+// The first table to fail cancels all the others.
+//
+//	Returns: a report giving the status of each table.
+//	Returns: nil if all tables succeed.
+//	Returns: the first hard failure otherwise.
+func FetchTables(ctx context.Context, tables map[string]api.Fetcher) (RefreshReport, error) {
+	ctx, cancel := context.WithTimeout(ctx, RefreshTimeout)
+	defer cancel()
+
+	keys := make([]string, 0, len(tables))
+	for key := range tables {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	report := RefreshReport{Tables: make([]TableStatus, len(keys))}
+	var firstErr error
+	var mu sync.Mutex // guards firstErr
+	var wg sync.WaitGroup
+
+	for i, key := range keys {
+		wg.Add(1)
+		go func(i int, key string, d api.Fetcher) {
+			defer wg.Done()
+			start := time.Now()
+			err := d.Fetch(ctx)
+			status := TableStatus{Key: key, State: Fetched, Err: err, Duration: time.Since(start)}
+			if err != nil {
+				mu.Lock()
+				if firstErr != nil && errors.Is(ctx.Err(), context.Canceled) {
+					status.State = Cancelled
+				} else {
+					status.State = Failed
+					if firstErr == nil {
+						firstErr = fmt.Errorf("could not retrieve %s: %w", key, err)
+						cancel()
+					}
+				}
+				mu.Unlock()
+			}
+			report.Tables[i] = status
+		}(i, key, tables[key])
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		log.Output(1, fmt.Sprintf("Refresh failed:\n%s", report))
+		return report, firstErr
+	}
+	utils.Trace(utils.White, fmt.Sprintf("Refresh complete:\n%s", report))
+	return report, nil
+}
+
+// Fetches the current state of the user's simulation into a new dataset,
+// leaving the user's history untouched.
+//
+//	Returns: the new dataset and the user's simulation list, if every table arrived.
+//	Returns: a report giving the status of each table.
+//	Returns: the first hard failure otherwise, in which case the dataset is nil.
+func FetchDataset(ctx context.Context, user *models.User) (*models.Dataset, api.Table[models.Simulation], RefreshReport, error) {
+	dataSet := models.NewDataset(user.ApiKey)
+	sim := models.NewSimulationsTable(user.ApiKey)
+	tables := dataSet.Tables()
+	tables["simulation"] = &sim
+	report, err := FetchTables(ctx, tables)
+	if err != nil {
+		return nil, sim, report, err
+	}
+	return dataSet, sim, report, nil
+}
+
+// Records the result of an action as the next stage of the user's history.
+//
+// The new dataset is built and populated off to the side. Only if every
+// table arrives is it committed to user.Datasets and the time stamps moved.
+// If anything fails, the user's history is exactly as it was before.
+func AppendDataset(ctx context.Context, user *models.User) (RefreshReport, error) {
+	dataSet, sim, report, err := FetchDataset(ctx, user)
+	if err != nil {
+		return report, fmt.Errorf("the new stage was not recorded and your history is unchanged: %w", err)
+	}
+	user.CommitDataset(dataSet, sim)
+	return report, nil
+}
+
+// Replaces the user's history with the current state of the user's simulation.
+// Used when the user starts a new simulation. As with AppendDataset,
+// nothing changes unless every table arrives.
+func StartHistory(ctx context.Context, user *models.User) (RefreshReport, error) {
+	dataSet, sim, report, err := FetchDataset(ctx, user)
+	if err != nil {
+		return report, fmt.Errorf("the simulation could not be loaded: %w", err)
+	}
+	user.StartHistory(dataSet, sim)
+	return report, nil
+}
+
 // Runs once at startup.
-// Retrieve users and templates from the server database.
+// Retrieve users and templates from whichever backend is in use.
 func Initialise() {
+	ctx := context.Background()
+
 	// Retrieve the templates on the server
-	if !api.FetchGlobalObject(utils.APISOURCE+`templates/templates`, &models.TemplateList) {
-		log.Fatal("Could not retrieve templates information from the server. Stopping")
+	body, err := api.Backend.Templates(ctx)
+	if err == nil {
+		err = api.Decode(`templates/templates`, body, &session.TemplateList)
+	}
+	if err != nil {
+		log.Fatalf("Could not retrieve templates information from the server (%v). Stopping", err)
 	}
 
 	// Retrieve users on the server
-	if !api.FetchGlobalObject(utils.APISOURCE+`admin/users`, &models.AdminUserList) {
-		log.Fatal("Could not retrieve user information from the server. Stopping")
+	body, err = api.Backend.Users(ctx)
+	if err == nil {
+		err = api.Decode(`admin/users`, body, &session.AdminUserList)
+	}
+	if err != nil {
+		log.Fatalf("Could not retrieve user information from the server (%v). Stopping", err)
 	}
 
 	// Transfer the list to the user map
-	for _, item := range models.AdminUserList {
+	for _, item := range session.AdminUserList {
 		user := models.NewUser(item.UserName, item.CurrentSimulationID, item.ApiKey)
-		models.Users[item.UserName] = &user
+		session.Register(&user)
 	}
 }
@@ -0,0 +1,121 @@
+// fetch.run.go
+// Runs a simulation through many stages without anyone pressing the
+// buttons.
+//
+// Each stage is carried out by Act, exactly as if the user had chosen
+// it from the menu, so every intermediate dataset is recorded in the
+// user's history as usual. The run stops when it has done what it was
+// asked to, when the server fails, when one of the plan's stop
+// conditions holds, or when its context is cancelled. Cancelling does
+// not interrupt a stage: the stage under way is finished and recorded,
+// so the client's history stays in step with the server.
+
+package fetch
+
+import (
+	"capfront/models"
+	"capfront/utils"
+	"context"
+	"fmt"
+)
+
+// What an automatic run should do.
+type Plan struct {
+	Steps    int                // how many stages, or periods, to run
+	Unit     models.Axis        // models.StageAxis or models.PeriodAxis
+	StopWhen []models.Condition // stop as soon as any of these holds after a stage
+
+	// If set, this is held while each stage changes the user's record,
+	// and released between stages, so that other requests made as the
+	// user can look at the history while the run goes on.
+	Lock func() (unlock func())
+}
+
+// How far an automatic run has got.
+type Progress struct {
+	Plan
+	StepsDone   int          // stages or periods, according to the plan's Unit
+	StagesDone  int          // stages carried out
+	PeriodsDone int          // periods completed
+	Stage       models.Stage // the stage the simulation is now waiting to carry out
+	TimeStamp   int          // the latest stage in the user's history
+	Finished    bool         // true once the run has ended, for whatever reason
+	Reason      string       // why the run ended
+	Err         error        // the server's failure, if that is why it ended
+}
+
+// How much of the run is done, as a percentage.
+func (p Progress) Percent() int {
+	if p.Steps <= 0 {
+		return 100
+	}
+	return 100 * p.StepsDone / p.Steps
+}
+
+// Carries out the plan for the user, calling report after each stage.
+//
+//	Returns: the progress when the run ended. Its Err is set if the server failed.
+func Run(ctx context.Context, user *models.User, plan Plan, report func(Progress)) Progress {
+	lock := plan.Lock
+	if lock == nil {
+		lock = func() func() { return func() {} }
+	}
+	p := Progress{Plan: plan}
+	func() {
+		unlock := lock()
+		defer unlock()
+		p.Stage, p.TimeStamp = user.CurrentStage(), user.TimeStamp
+	}()
+	for p.StepsDone < plan.Steps && !p.Finished {
+		if ctx.Err() != nil {
+			p.Finished, p.Reason = true, "stopped on request"
+			break
+		}
+		func() {
+			unlock := lock()
+			defer unlock()
+			p.step(ctx, user)
+		}()
+		if report != nil && !p.Finished {
+			report(p)
+		}
+	}
+	if !p.Finished {
+		p.Finished = true
+		p.Reason = fmt.Sprintf("completed %d %ss", p.Steps, p.Unit)
+	}
+	utils.Trace(utils.Yellow, fmt.Sprintf("Automatic run for %s ended after %d stages: %s\n", user.UserName, p.StagesDone, p.Reason))
+	if report != nil {
+		report(p)
+	}
+	return p
+}
+
+// Carries out one stage, and decides whether the run should go on.
+func (p *Progress) step(ctx context.Context, user *models.User) {
+	stage := user.CurrentStage()
+	actCtx, cancel := Detach(ctx)
+	defer cancel()
+	next, err := Act(actCtx, user, stage.Action())
+	if err != nil {
+		p.Finished, p.Err = true, err
+		p.Reason = fmt.Sprintf("stopped at stage %d because the action %s failed", user.TimeStamp, stage.Action())
+		return
+	}
+	p.StagesDone++
+	if stage.EndsPeriod() {
+		p.PeriodsDone++
+	}
+	p.StepsDone = p.StagesDone
+	if p.Unit == models.PeriodAxis {
+		p.StepsDone = p.PeriodsDone
+	}
+	p.Stage, p.TimeStamp = next, user.TimeStamp
+	for _, c := range p.StopWhen {
+		if holds, because := c.HoldsAt(*user, user.TimeStamp); holds {
+			p.Finished = true
+			p.Reason = fmt.Sprintf("stopped at stage %d because %s (%s)", user.TimeStamp, c, because)
+			return
+		}
+	}
+}
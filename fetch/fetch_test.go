@@ -0,0 +1,274 @@
+package fetch_test
+
+import (
+	"capfront/api"
+	"capfront/fake"
+	"capfront/fetch"
+	"capfront/models"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A user of the fake server with a fresh clone of the given template.
+func newPlayer(t *testing.T, templateId int) *models.User {
+	t.Helper()
+	api.Backend = fake.NewServer()
+	user := models.NewUser(`alice`, 0, `alicekey`)
+	if _, err := fetch.Clone(context.Background(), &user, templateId); err != nil {
+		t.Fatalf("Clone(%d): %v", templateId, err)
+	}
+	return &user
+}
+
+func TestActWalksTheCircuit(t *testing.T) {
+	ctx := context.Background()
+	user := newPlayer(t, 1)
+	if got := user.CurrentStage(); got != models.Demand {
+		t.Fatalf("a new simulation is in state %s, want %s", got, models.Demand)
+	}
+
+	for i, stage := range models.Circuit {
+		next, err := fetch.Act(ctx, user, stage.Action())
+		if err != nil {
+			t.Fatalf("Act(%s): %v", stage.Action(), err)
+		}
+		if next != stage.Next() {
+			t.Errorf("after %s the state is %s, want %s", stage.Action(), next, stage.Next())
+		}
+		if user.TimeStamp != i+1 || len(user.Datasets) != i+2 {
+			t.Errorf("after %s TimeStamp=%d with %d datasets, want %d with %d", stage.Action(), user.TimeStamp, len(user.Datasets), i+1, i+2)
+		}
+	}
+	if got := user.CurrentStage(); got != models.Demand {
+		t.Errorf("after a full circuit the state is %s, want %s", got, models.Demand)
+	}
+}
+
+func TestActRejectsActionsOutOfOrder(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name   string
+		before []string // actions carried out first
+		action string
+	}{
+		{"skip ahead", nil, `trade`},
+		{"repeat", []string{`demand`}, `demand`},
+		{"invest too soon", []string{`demand`, `supply`}, `invest`},
+		{"no such action", nil, `dance`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := newPlayer(t, 2)
+			for _, action := range tt.before {
+				if _, err := fetch.Act(ctx, user, action); err != nil {
+					t.Fatalf("Act(%s): %v", action, err)
+				}
+			}
+			stage, timeStamp := user.CurrentStage(), user.TimeStamp
+
+			got, err := fetch.Act(ctx, user, tt.action)
+			var stageErr *models.StageError
+			if !errors.As(err, &stageErr) {
+				t.Fatalf("Act(%s) in state %s returned %v, want a *models.StageError", tt.action, stage, err)
+			}
+			if got != stage || user.CurrentStage() != stage || user.TimeStamp != timeStamp {
+				t.Errorf("a rejected %s moved the simulation from %s at %d to %s at %d", tt.action, stage, timeStamp, user.CurrentStage(), user.TimeStamp)
+			}
+
+			// The server must refuse it too, if asked directly.
+			if err := api.Backend.Action(ctx, user.ApiKey, tt.action); err == nil {
+				t.Errorf("the fake server carried out %s in state %s", tt.action, stage)
+			}
+		})
+	}
+}
+
+// A table that fails at once, succeeds at once, or waits until its fetch is abandoned.
+type stubTable struct {
+	err  error
+	wait bool
+}
+
+func (s *stubTable) Fetch(ctx context.Context) error {
+	if s.wait {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return s.err
+}
+
+func TestFetchTables(t *testing.T) {
+	failure := errors.New("the server fell over")
+	tests := []struct {
+		name    string
+		tables  map[string]*stubTable
+		timeout time.Duration
+		want    []fetch.TableStatus // the key and state of each table, in key order
+		err     error               // wrapped by the error returned, if not nil
+	}{
+		{"all fetched", map[string]*stubTable{"b": {}, "a": {}, "c": {}}, time.Minute,
+			[]fetch.TableStatus{{Key: "a", State: fetch.Fetched}, {Key: "b", State: fetch.Fetched}, {Key: "c", State: fetch.Fetched}}, nil},
+		{"the first failure cancels the rest", map[string]*stubTable{"a": {wait: true}, "b": {err: failure}, "c": {wait: true}}, time.Minute,
+			[]fetch.TableStatus{{Key: "a", State: fetch.Cancelled}, {Key: "b", State: fetch.Failed}, {Key: "c", State: fetch.Cancelled}}, failure},
+		{"all too slow", map[string]*stubTable{"a": {wait: true}, "b": {wait: true}}, 10 * time.Millisecond,
+			[]fetch.TableStatus{{Key: "a", State: fetch.Failed}, {Key: "b", State: fetch.Failed}}, context.DeadlineExceeded},
+	}
+	defer func(timeout time.Duration) { fetch.RefreshTimeout = timeout }(fetch.RefreshTimeout)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetch.RefreshTimeout = tt.timeout
+			tables := make(map[string]api.Fetcher, len(tt.tables))
+			for key, table := range tt.tables {
+				tables[key] = table
+			}
+
+			report, err := fetch.FetchTables(context.Background(), tables)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("FetchTables returned %v, want %v", err, tt.err)
+			}
+			if report.OK() != (tt.err == nil) {
+				t.Errorf("the report is OK: %v, but the error is %v", report.OK(), err)
+			}
+			if len(report.Tables) != len(tt.want) {
+				t.Fatalf("the report has %d tables, want %d:\n%s", len(report.Tables), len(tt.want), report)
+			}
+			for i, want := range tt.want {
+				got := report.Tables[i]
+				if got.Key != want.Key || got.State != want.State {
+					t.Errorf("table %d is %s %s, want %s %s", i, got.Key, got.State, want.Key, want.State)
+				}
+				if (got.State == fetch.Fetched) != (got.Err == nil) {
+					t.Errorf("table %s is %s with error %v", got.Key, got.State, got.Err)
+				}
+			}
+		})
+	}
+}
+
+// A server that cannot send one of its tables.
+type brokenTable struct {
+	api.SimulationBackend
+	url string
+}
+
+func (s brokenTable) Table(ctx context.Context, apiKey string, url string) ([]byte, error) {
+	if url == s.url {
+		return nil, &api.ApiError{Kind: api.ServerFault, Url: url, Message: "the table is broken"}
+	}
+	return s.SimulationBackend.Table(ctx, apiKey, url)
+}
+
+// If any table of the new stage fails to arrive, nothing is committed,
+// and the history is exactly as it was before the action.
+func TestFailedRefreshLeavesTheHistory(t *testing.T) {
+	ctx := context.Background()
+	for _, url := range []string{`commodity`, `stocks/industry`, `simulations/current`} {
+		t.Run(url, func(t *testing.T) {
+			user := newPlayer(t, 1)
+			if _, err := fetch.Act(ctx, user, `demand`); err != nil {
+				t.Fatalf("Act(demand): %v", err)
+			}
+			datasets, sim := append([]*models.Dataset(nil), user.Datasets...), user.Sim.List
+			timeStamp, viewed, compared := user.TimeStamp, user.ViewedTimeStamp, user.ComparatorTimeStamp
+
+			api.Backend = brokenTable{api.Backend, url}
+			if _, err := fetch.AppendDataset(ctx, user); !api.IsKind(err, api.ServerFault) {
+				t.Errorf("AppendDataset returned %v, want a ServerFault", err)
+			}
+			if _, err := fetch.StartHistory(ctx, user); !api.IsKind(err, api.ServerFault) {
+				t.Errorf("StartHistory returned %v, want a ServerFault", err)
+			}
+
+			if len(user.Datasets) != len(datasets) || len(user.Indexes) != len(datasets) {
+				t.Errorf("the history has %d datasets and %d indexes, want %d", len(user.Datasets), len(user.Indexes), len(datasets))
+			}
+			for i := range datasets {
+				if i < len(user.Datasets) && user.Datasets[i] != datasets[i] {
+					t.Errorf("the dataset at stage %d was replaced", i)
+				}
+			}
+			if user.TimeStamp != timeStamp || user.ViewedTimeStamp != viewed || user.ComparatorTimeStamp != compared {
+				t.Errorf("the time stamps moved from %d, %d, %d to %d, %d, %d", timeStamp, viewed, compared, user.TimeStamp, user.ViewedTimeStamp, user.ComparatorTimeStamp)
+			}
+			if len(user.Sim.List) != len(sim) || user.CurrentStage() != models.Supply {
+				t.Errorf("the simulation list changed: the simulation is waiting to %s", user.CurrentStage().Action())
+			}
+		})
+	}
+}
+
+// An automatic run ends when it has done what it was asked, when a stop
+// condition holds, when it is stopped, or when the server fails. Each
+// stage it carried out is in the history, and is reported unless the
+// run ended with it.
+func TestRunStops(t *testing.T) {
+	condition := func(text string) models.Condition {
+		c, err := models.ParseCondition(text)
+		if err != nil {
+			t.Fatalf("ParseCondition(%q): %v", text, err)
+		}
+		return c
+	}
+	never, always := condition(`industry Output_Scale < 0`), condition(`industry Output_Scale > 0`)
+	circuit := len(models.Circuit)
+	tests := []struct {
+		name    string
+		plan    fetch.Plan
+		cancel  int    // stop the run once this many stages are done; -1 never
+		broken  string // a table the server cannot send, if not empty
+		stages  int    // stages carried out
+		periods int
+		reports int    // reports before the run ended
+		reason  string // the start of the reason the run ended
+	}{
+		{"after its stages", fetch.Plan{Steps: 3, Unit: models.StageAxis}, -1, ``, 3, 0, 3, `completed 3 stages`},
+		{"after its periods", fetch.Plan{Steps: 2, Unit: models.PeriodAxis}, -1, ``, 2 * circuit, 2, 2 * circuit, `completed 2 periods`},
+		{"when a condition holds", fetch.Plan{Steps: 2, Unit: models.PeriodAxis, StopWhen: []models.Condition{never, always}},
+			-1, ``, 1, 0, 0, `stopped at stage 1 because industry Output_Scale > 0`},
+		{"not while no condition holds", fetch.Plan{Steps: 4, Unit: models.StageAxis, StopWhen: []models.Condition{never}},
+			-1, ``, 4, 0, 4, `completed 4 stages`},
+		{"before it starts", fetch.Plan{Steps: 4, Unit: models.StageAxis}, 0, ``, 0, 0, 0, `stopped on request`},
+		{"on request", fetch.Plan{Steps: 1, Unit: models.PeriodAxis}, 2, ``, 2, 0, 2, `stopped on request`},
+		{"when the server fails", fetch.Plan{Steps: 1, Unit: models.PeriodAxis}, -1, `commodity`, 0, 0, 0, `stopped at stage 0 because the action demand failed`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := newPlayer(t, 1)
+			if tt.broken != `` {
+				api.Backend = brokenTable{api.Backend, tt.broken}
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel == 0 {
+				cancel()
+			}
+			reports := 0
+			p := fetch.Run(ctx, user, tt.plan, func(p fetch.Progress) {
+				if p.Finished {
+					return
+				}
+				reports++
+				if p.StagesDone == tt.cancel {
+					cancel()
+				}
+			})
+
+			if !p.Finished || !strings.HasPrefix(p.Reason, tt.reason) {
+				t.Errorf("the run finished=%v because %q, want it to finish because %q", p.Finished, p.Reason, tt.reason)
+			}
+			if (p.Err != nil) != (tt.broken != ``) {
+				t.Errorf("the run ended with error %v", p.Err)
+			}
+			if p.StagesDone != tt.stages || p.PeriodsDone != tt.periods || reports != tt.reports {
+				t.Errorf("the run did %d stages and %d periods in %d reports, want %d, %d and %d",
+					p.StagesDone, p.PeriodsDone, reports, tt.stages, tt.periods, tt.reports)
+			}
+			if user.TimeStamp != tt.stages || p.TimeStamp != tt.stages || len(user.Datasets) != tt.stages+1 {
+				t.Errorf("the history is at stage %d with %d datasets, and the run says %d, want %d", user.TimeStamp, len(user.Datasets), p.TimeStamp, tt.stages)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+// headless.go
+// Runs a simulation from the command line, with no browser and no
+// cookies, for batch jobs and for reproducing a classroom run exactly.
+//
+// It does what a player would do through the web client: pick a user
+// and a template, clone the template, and carry out a number of
+// circuits. Each stage goes through fetch.Act, so the history it
+// writes is the same as the one the web client would have recorded.
+//
+// Like a player choosing a user in the browser, it locks the user on
+// the server for the whole run, so nobody else can play as that user
+// and interleave stages with it.
+
+package headless
+
+import (
+	"capfront/api"
+	"capfront/fetch"
+	"capfront/models"
+	"capfront/session"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// What to run.
+type Options struct {
+	UserName string
+	Template int
+	Circuits int                // how many periods to run
+	StopWhen []models.Condition // stop early if any of these holds
+	Out      string             // the file to write the history to
+}
+
+// Finds the user and checks the template, both of which must be known
+// to the server. fetch.Initialise must have been called.
+func (o Options) check() (*models.User, error) {
+	user, ok := session.Lookup(o.UserName)
+	if !ok {
+		var names []string
+		for _, u := range session.AdminUserList {
+			names = append(names, u.UserName)
+		}
+		return nil, fmt.Errorf("there is no user called %q; the users are %s", o.UserName, strings.Join(names, ", "))
+	}
+	for _, t := range session.TemplateList {
+		if t.Id == o.Template {
+			return user, nil
+		}
+	}
+	var ids []string
+	for _, t := range session.TemplateList {
+		ids = append(ids, fmt.Sprintf("%d (%s)", t.Id, t.Name))
+	}
+	return nil, fmt.Errorf("there is no template %d; the templates are %s", o.Template, strings.Join(ids, ", "))
+}
+
+// Locks the user on the server, as display.SelectUser does for a player.
+//
+//	Returns: a function that unlocks the user again.
+//	Returns: an error if the user could not be locked, saying so plainly if somebody else is playing as the user.
+func lock(ctx context.Context, user *models.User) (unlock func(), err error) {
+	if err = api.Backend.Lock(ctx, user.ApiKey, user.UserName); err != nil {
+		if api.IsKind(err, api.Locked) {
+			return nil, fmt.Errorf("somebody else is playing as %s; wait until they quit, or choose another user: %w", user.UserName, err)
+		}
+		return nil, fmt.Errorf("could not lock %s on the server: %w", user.UserName, err)
+	}
+	return func() {
+		if err := api.Backend.Unlock(context.WithoutCancel(ctx), user.ApiKey, user.UserName); err != nil {
+			log.Printf("Could not unlock %s on the server: %v", user.UserName, err)
+		}
+	}, nil
+}
+
+// Locks the user, clones the template for the user and runs it.
+//
+//	Returns: the progress of the run, and the user whose history records it.
+//	Returns: an error if the run could not be started, or if the server failed part way.
+//	The user is returned once the simulation has been loaded, so a partial history can still be saved.
+func Run(ctx context.Context, o Options) (fetch.Progress, *models.User, error) {
+	user, err := o.check()
+	if err != nil {
+		return fetch.Progress{}, nil, err
+	}
+	unlock, err := lock(ctx, user)
+	if err != nil {
+		return fetch.Progress{}, nil, err
+	}
+	defer unlock()
+
+	simulationId, err := fetch.Clone(ctx, user, o.Template)
+	if err != nil {
+		return fetch.Progress{}, nil, err
+	}
+	log.Printf("User %s has created simulation %d from template %d", user.UserName, simulationId, o.Template)
+
+	plan := fetch.Plan{Steps: o.Circuits, Unit: models.PeriodAxis, StopWhen: o.StopWhen}
+	progress := fetch.Run(ctx, user, plan, func(p fetch.Progress) {
+		if p.Stage == models.Demand && !p.Finished {
+			log.Printf("Completed period %d of %d (stage %d)", p.PeriodsDone, p.Steps, p.TimeStamp)
+		}
+	})
+	log.Printf("The run %s", progress.Reason)
+	return progress, user, progress.Err
+}
+
+// Writes the user's history to a file as JSON.
+func WriteHistory(path string, user *models.User, templateId int) error {
+	data, err := json.MarshalIndent(user.HistoryFile(templateId), "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode the history: %w", err)
+	}
+	if err = os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("could not write the history: %w", err)
+	}
+	return nil
+}
+
+// Runs according to the options and writes the history, even if the
+// run stopped early because the server failed.
+func RunAndWrite(ctx context.Context, o Options) error {
+	_, user, err := Run(ctx, o)
+	if user != nil {
+		if writeErr := WriteHistory(o.Out, user, o.Template); writeErr != nil {
+			return writeErr
+		}
+		log.Printf("Wrote %d stages to %s", len(user.Datasets), o.Out)
+	}
+	return err
+}
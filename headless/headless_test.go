@@ -0,0 +1,61 @@
+package headless
+
+import (
+	"capfront/api"
+	"capfront/fake"
+	"capfront/fetch"
+	"capfront/session"
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+// True if the server says the user is locked.
+func lockedOnServer(t *testing.T, username string) bool {
+	t.Helper()
+	body, err := api.Backend.User(context.Background(), username)
+	if err != nil {
+		t.Fatalf("User(%s): %v", username, err)
+	}
+	var record struct {
+		IsLocked bool `json:"is_locked"`
+	}
+	if err = json.Unmarshal(body, &record); err != nil {
+		t.Fatalf("decode user %s: %v", username, err)
+	}
+	return record.IsLocked
+}
+
+func TestRunLocksTheUser(t *testing.T) {
+	ctx := context.Background()
+	api.Backend = fake.NewServer()
+	fetch.Initialise()
+	o := Options{UserName: `bob`, Template: 1, Circuits: 1}
+
+	progress, user, err := Run(ctx, o)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if progress.PeriodsDone != 1 || user.TimeStamp != len(user.Datasets)-1 {
+		t.Errorf("Run completed %d periods with %d stages, want 1 period", progress.PeriodsDone, user.TimeStamp)
+	}
+	if lockedOnServer(t, `bob`) {
+		t.Errorf("bob is still locked after the run")
+	}
+
+	// Somebody is playing as bob in a browser.
+	user, _ = session.Lookup(`bob`)
+	if err = api.Backend.Lock(ctx, user.ApiKey, `bob`); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	timeStamp := user.TimeStamp
+	if _, _, err = Run(ctx, o); !api.IsKind(err, api.Locked) {
+		t.Errorf("Run as a locked user returned %v, want a Locked error", err)
+	}
+	if user.TimeStamp != timeStamp {
+		t.Errorf("Run as a locked user changed the history")
+	}
+	if !lockedOnServer(t, `bob`) {
+		t.Errorf("Run as a locked user released the other player's lock")
+	}
+}
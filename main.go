@@ -1,53 +1,75 @@
 package main
 
 import (
+	"capfront/api"
 	"capfront/display"
+	"capfront/fake"
 	"capfront/fetch"
+	"capfront/headless"
+	"capfront/models"
+	"capfront/utils"
+	"context"
+	"flag"
 	"fmt"
+	"log"
 
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
+	// Choose the backend. The fake serves fixture data from inside this
+	// process, so the client can be run without the remote server.
+	backend := flag.String("backend", "http", "simulation backend to use: http or fake")
+	source := flag.String("source", utils.APISOURCE, "root URL of the simulation server (http backend only)")
+	latency := flag.Duration("latency", 0, "delay added to every request (fake backend only)")
+
+	// Run from the command line instead of starting the web server.
+	run := flag.Bool("run", false, "run a simulation from the command line and write its history, instead of serving the web client")
+	var options headless.Options
+	flag.StringVar(&options.UserName, "user", "", "the user to play as (with -run)")
+	flag.IntVar(&options.Template, "template", 1, "the template to clone (with -run)")
+	flag.IntVar(&options.Circuits, "circuits", 1, "how many periods to run (with -run)")
+	flag.StringVar(&options.Out, "out", "history.json", "the file to write the history to (with -run)")
+	flag.Func("stop", "stop the run early if this condition holds, e.g. 'industry Profit_Rate < 0' (with -run; may be repeated)", func(text string) error {
+		c, err := models.ParseCondition(text)
+		if err == nil {
+			options.StopWhen = append(options.StopWhen, c)
+		}
+		return err
+	})
+	flag.Parse()
+
+	switch *backend {
+	case "http":
+		api.Backend = api.NewHttpBackend(*source)
+	case "fake":
+		server := fake.NewServer()
+		server.Latency = *latency
+		api.Backend = server
+	default:
+		log.Fatalf("Unknown backend %s. Use http or fake", *backend)
+	}
+
+	if *run {
+		if options.UserName == "" || options.Circuits < 1 {
+			log.Fatal("A command-line run needs -user, and -circuits of at least 1")
+		}
+		fetch.Initialise()
+		if err := headless.RunAndWrite(context.Background(), options); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
 	display.Router.Use(gin.Recovery())
 
 	// load the templates
 	display.Router.LoadHTMLGlob("./templates/**/*")
-	fmt.Println("The Rosy Dawn of Capitalism has begun")
-
-	// Admin group.
-	// These all access the api by the admin backdoor so are exempt from authorization.
-	display.Router.GET("/admin/reset", display.AdminReset)
-	display.Router.GET("/admin/choose-players", display.Lock)
-	display.Router.GET("/admin/play-as/:username", display.SelectUser)
-	display.Router.GET("/admin/dashboard", display.AdminDashboard)
-	display.Router.GET("/data/", display.DataHandler)
-
-	// The endpoints below require authorization
-	// TODO couldn't get grouping to work. Pretty sure it did not work as per spec
-
-	display.Router.GET("/action/:action", display.SynchWithServer(), display.ActionHandler)
-	display.Router.GET("/commodities", display.SynchWithServer(), display.ShowCommodities)
-	display.Router.GET("/industries", display.SynchWithServer(), display.ShowIndustries)
-	display.Router.GET("/classes", display.SynchWithServer(), display.ShowClasses)
-	display.Router.GET("/industry_stocks", display.SynchWithServer(), display.ShowIndustryStocks)
-	display.Router.GET("/class_stocks", display.SynchWithServer(), display.ShowClassStocks)
-	display.Router.GET("/trace", display.SynchWithServer(), display.ShowTrace)
-	display.Router.GET("/industry/:id", display.SynchWithServer(), display.ShowIndustry)
-	display.Router.GET("/commodity/:id", display.SynchWithServer(), display.ShowCommodity)
-	display.Router.GET("/class/:id", display.SynchWithServer(), display.ShowClass)
-	display.Router.GET("/user/create/:id", display.SynchWithServer(), display.CreateSimulation)
-	display.Router.GET("/user/switch/:id", display.SynchWithServer(), display.SwitchSimulation)
-	display.Router.GET("/user/delete/:id", display.SynchWithServer(), display.DeleteSimulation)
-	display.Router.GET("/user/restart/:id", display.SynchWithServer(), display.RestartSimulation)
-	display.Router.GET("/", display.SynchWithServer(), display.ShowIndexPage)
-	display.Router.GET("/user/dashboard", display.SynchWithServer(), display.UserDashboard)
-	display.Router.GET("/back", display.SynchWithServer(), display.Back)
-	display.Router.GET("/forward", display.SynchWithServer(), display.Forward)
-	display.Router.GET("/quit", display.SynchWithServer(), display.Quit)
-
-	// Grab user data from the server at startup. Currently, this is fixed.
+	fmt.Printf("The Rosy Dawn of Capitalism has begun, using the %s backend\n", *backend)
+
+	display.SetRoutes()
+
+	// Grab user data from the backend at startup. Currently, this is fixed.
 	// BUT note that if users are modified on the server, we will be out of synch.
 	// TODO set up registration.
 	// TODO Check that user changes on the server are reflected on the client.
@@ -0,0 +1,108 @@
+// models.conditions.go
+// Conditions on the state of a simulation, such as "an industry's
+// Profit_Rate is below 0", used to stop an automatic run.
+//
+// A condition names a kind of object, one of its numeric fields (as in
+// models.series.go), a comparison and a threshold. It holds at a stage
+// if the field of any object of that kind satisfies the comparison.
+
+package models
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// A comparison between a field and a threshold.
+type Comparison string
+
+const (
+	Below        Comparison = `<`
+	AtMost       Comparison = `<=`
+	Above        Comparison = `>`
+	AtLeast      Comparison = `>=`
+	Unrecognised Comparison = ``
+)
+
+var Comparisons = []Comparison{Below, AtMost, Above, AtLeast}
+
+// Converts a comparison, as written by the user, into a Comparison.
+// Returns Unrecognised if it is not one of the comparisons.
+func ParseComparison(op string) Comparison {
+	for _, c := range Comparisons {
+		if string(c) == strings.TrimSpace(op) {
+			return c
+		}
+	}
+	return Unrecognised
+}
+
+func (c Comparison) holds(x float64, threshold float64) bool {
+	switch c {
+	case Below:
+		return x < threshold
+	case AtMost:
+		return x <= threshold
+	case Above:
+		return x > threshold
+	case AtLeast:
+		return x >= threshold
+	}
+	return false
+}
+
+// A condition on one field of every object of one kind.
+type Condition struct {
+	Kind       ObjectKind
+	Field      string
+	Comparison Comparison
+	Threshold  float64
+}
+
+// Writes the condition as the user would, for example "industry Profit_Rate < 0".
+func (c Condition) String() string {
+	return fmt.Sprintf("%s %s %s %s", c.Kind, c.Field, c.Comparison, strconv.FormatFloat(c.Threshold, 'g', -1, 64))
+}
+
+// Reads a condition written as "kind field comparison threshold",
+// for example "industry Profit_Rate < 0".
+//
+//	Returns: an error if any part is missing or is not recognised.
+func ParseCondition(text string) (Condition, error) {
+	parts := strings.Fields(text)
+	if len(parts) != 4 {
+		return Condition{}, fmt.Errorf("%q should have the form 'kind field comparison threshold', such as 'industry Profit_Rate < 0'", text)
+	}
+	kind, ok := ParseObjectKind(parts[0])
+	if !ok {
+		return Condition{}, fmt.Errorf("%q is not a kind of object; use commodity, industry, class or simulation", parts[0])
+	}
+	if !kind.HasField(parts[1]) {
+		return Condition{}, fmt.Errorf("a %s has no numeric field called %q", kind, parts[1])
+	}
+	comparison := ParseComparison(parts[2])
+	if comparison == Unrecognised {
+		return Condition{}, fmt.Errorf("%q is not a comparison; use <, <=, > or >=", parts[2])
+	}
+	threshold, err := strconv.ParseFloat(parts[3], 64)
+	if err != nil {
+		return Condition{}, fmt.Errorf("%q is not a number", parts[3])
+	}
+	return Condition{Kind: kind, Field: parts[1], Comparison: comparison, Threshold: threshold}, nil
+}
+
+// Tests the condition at the given stage of the user's history.
+//
+//	Returns: whether it holds.
+//	Returns: if it does, which object satisfied it and with what value, for example "Mining: Profit_Rate = -0.02".
+func (c Condition) HoldsAt(u User, timeStamp int) (bool, string) {
+	for _, o := range u.Objects(c.Kind, timeStamp) {
+		x := fieldValue(u.object(c.Kind, o.Id, timeStamp), c.Field)
+		if !math.IsNaN(x) && c.Comparison.holds(x, c.Threshold) {
+			return true, fmt.Sprintf("%s: %s = %s", o.Name, c.Field, strconv.FormatFloat(x, 'g', 6, 64))
+		}
+	}
+	return false, ``
+}
@@ -0,0 +1,54 @@
+// models.export.go
+// A user's history in a form that can be written to disk.
+//
+// Each stage is recorded in full, with every table of its Dataset, so
+// that a run can be analysed, compared or reproduced without the
+// server. The api keys carried by the tables are private, and are left out.
+
+package models
+
+// One stage of the history, with all its data.
+type StageRecord struct {
+	TimeStamp      int              `json:"time_stamp"`
+	Period         int              `json:"period"`
+	State          string           `json:"state"`
+	Label          string           `json:"label"`
+	Simulations    []Simulation     `json:"simulations"`
+	Commodities    []Commodity      `json:"commodities"`
+	Industries     []Industry       `json:"industries"`
+	Classes        []Class          `json:"classes"`
+	IndustryStocks []Industry_Stock `json:"industry stocks"`
+	ClassStocks    []Class_Stock    `json:"class stocks"`
+	Traces         []Trace          `json:"trace"`
+}
+
+// A user's history of one simulation.
+type HistoryFile struct {
+	UserName     string        `json:"username"`
+	TemplateId   int           `json:"template_id"`
+	SimulationId int           `json:"simulation_id"`
+	Stages       []StageRecord `json:"stages"`
+}
+
+// Records the user's history of the current simulation, which was
+// created from the given template.
+func (u User) HistoryFile(templateId int) HistoryFile {
+	f := HistoryFile{UserName: u.UserName, TemplateId: templateId, SimulationId: u.CurrentSimulationID}
+	for _, h := range u.History() {
+		d := u.Dataset(h.TimeStamp)
+		f.Stages = append(f.Stages, StageRecord{
+			TimeStamp:      h.TimeStamp,
+			Period:         h.Period,
+			State:          h.State,
+			Label:          h.Label,
+			Simulations:    d.Simulations.List,
+			Commodities:    d.Commodities.List,
+			Industries:     d.Industries.List,
+			Classes:        d.Classes.List,
+			IndustryStocks: d.IndustryStocks.List,
+			ClassStocks:    d.ClassStocks.List,
+			Traces:         d.Traces.List,
+		})
+	}
+	return f
+}
@@ -0,0 +1,184 @@
+// models.holdings.go
+// What an owner holds for one purpose, grouped by commodity.
+//
+// An industry may use several means of production and several kinds of
+// labour power, and a class may consume several consumer goods. Each of
+// these is a separate stock, and an owner may even have two stocks of
+// the same commodity. A Holding sums the stocks of one commodity, and
+// Holdings lists them in the order the stocks first appear, with a
+// total for the whole group.
+//
+// Stocks are told apart by the commodities they consist of, never by
+// name: labour power is the productive commodity of SOCIAL origin, and
+// any other productive commodity is a means of production.
+
+package models
+
+// The stocks of one commodity that an owner holds for one purpose, added together.
+type Holding struct {
+	Commodity_id int // zero for the total of several commodities
+	Size         float32
+	Value        float32
+	Price        float32
+}
+
+// An owner's holdings for one purpose, one for each commodity.
+type Holdings []Holding
+
+// Adds a stock to the holding of its commodity, starting a new holding
+// if there is none.
+func (h *Holdings) add(commodityId int, size float32, value float32, price float32) {
+	for i := range *h {
+		if (*h)[i].Commodity_id == commodityId {
+			(*h)[i].Size += size
+			(*h)[i].Value += value
+			(*h)[i].Price += price
+			return
+		}
+	}
+	*h = append(*h, Holding{Commodity_id: commodityId, Size: size, Value: value, Price: price})
+}
+
+// The sum of all the holdings.
+// Values and prices are always comparable and can be added. Sizes are
+// added too, but the total size means something only when the holdings
+// are all of one commodity (as they are in the standard templates).
+func (h Holdings) Total() Holding {
+	var total Holding
+	for _, x := range h {
+		total.Size += x.Size
+		total.Value += x.Value
+		total.Price += x.Price
+	}
+	if len(h) == 1 {
+		total.Commodity_id = h[0].Commodity_id
+	}
+	return total
+}
+
+// The holding of the given commodity, which is empty if there is none.
+func (h Holdings) Of(commodityId int) Holding {
+	for _, x := range h {
+		if x.Commodity_id == commodityId {
+			return x
+		}
+	}
+	return Holding{Commodity_id: commodityId}
+}
+
+// True if the commodity is labour power, the commodity that makes
+// the capital that buys it variable.
+func isLabourPower(c *Commodity) bool {
+	return c != nil && c.Origin == `SOCIAL`
+}
+
+// Groups the production stocks of an industry, keeping those for which
+// keep is true of their commodity.
+func (industry Industry) productionHoldings(ix *Index, keep func(*Commodity) bool) Holdings {
+	var h Holdings
+	for _, s := range ix.IndustryStocks(industry.Id, `Production`) {
+		if keep(ix.Commodity(s.Commodity_id)) {
+			h.add(s.Commodity_id, s.Size, s.Value, s.Price)
+		}
+	}
+	return h
+}
+
+// The means of production of the industry, one holding for each commodity.
+func (industry Industry) ConstantCapitals(ix *Index) Holdings {
+	return industry.productionHoldings(ix, func(c *Commodity) bool { return !isLabourPower(c) })
+}
+
+// The labour power of the industry, one holding for each kind.
+func (industry Industry) VariableCapitals(ix *Index) Holdings {
+	return industry.productionHoldings(ix, isLabourPower)
+}
+
+// The consumer goods of the class, one holding for each commodity.
+func (class Class) ConsumerGoods(ix *Index) Holdings {
+	var h Holdings
+	for _, s := range ix.ClassStocks(class.Id, `Consumption`) {
+		h.add(s.Commodity_id, s.Size, s.Value, s.Price)
+	}
+	return h
+}
+
+// One holding as it was at the viewed and at the compared stage.
+type HoldingView struct {
+	Commodity_id int
+	Name         string // the name of the commodity
+	Size         Pair
+	Value        Pair
+	Price        Pair
+	Presence     Presence // whether the owner held this commodity at both stages
+}
+
+// The size, value or price of the holding in the given display mode, or its price if all are shown.
+func (h HoldingView) In(mode DisplayMode) Pair {
+	return Select(mode, h.Size, h.Value, h.Price, h.Price)
+}
+
+// Compares an owner's holdings at two stages, matching them by commodity.
+//
+//	vx: the Index of the viewed Dataset, used to name the commodities.
+//	cx: the Index of the comparator Dataset, for commodities that no longer exist.
+func NewHoldingViews(vx *Index, cx *Index, v Holdings, c Holdings) []HoldingView {
+	joined := joinById(v, c, func(h *Holding) int { return h.Commodity_id })
+	views := make([]HoldingView, len(joined))
+	for i, j := range joined {
+		vh, ch := Holding{}, Holding{}
+		if j.viewed != nil {
+			vh = *j.viewed
+		}
+		if j.compared != nil {
+			ch = *j.compared
+		}
+		id := vh.Commodity_id
+		name := ``
+		if j.viewed == nil {
+			id = ch.Commodity_id
+		}
+		if commodity := vx.Commodity(id); commodity != nil {
+			name = commodity.Name
+		} else if commodity := cx.Commodity(id); commodity != nil {
+			name = commodity.Name
+		}
+		views[i] = HoldingView{
+			Commodity_id: id,
+			Name:         name,
+			Size:         Pair{Viewed: vh.Size, Compared: ch.Size},
+			Value:        Pair{Viewed: vh.Value, Compared: ch.Value},
+			Price:        Pair{Viewed: vh.Price, Compared: ch.Price},
+			Presence:     j.presence,
+		}
+	}
+	return views
+}
+
+// The total of several holdings, compared between two stages.
+func TotalHoldingView(views []HoldingView) HoldingView {
+	total := HoldingView{Name: `Total`}
+	for _, h := range views {
+		total.Size = Pair{Viewed: total.Size.Viewed + h.Size.Viewed, Compared: total.Size.Compared + h.Size.Compared}
+		total.Value = Pair{Viewed: total.Value.Viewed + h.Value.Viewed, Compared: total.Value.Compared + h.Value.Compared}
+		total.Price = Pair{Viewed: total.Price.Viewed + h.Price.Viewed, Compared: total.Price.Compared + h.Price.Compared}
+	}
+	return total
+}
+
+// A group of holdings laid out for display, with their total.
+type HoldingsTable struct {
+	Title string
+	Rows  []HoldingView
+	Total HoldingView
+	Mode  DisplayMode // which magnitudes to show; all of them if AllMagnitudes
+}
+
+func NewHoldingsTable(title string, rows []HoldingView, mode DisplayMode) HoldingsTable {
+	return HoldingsTable{Title: title, Rows: rows, Total: TotalHoldingView(rows), Mode: mode}
+}
+
+// True if the table shows the given magnitude. Used by the template to choose columns.
+func (t HoldingsTable) Shows(mode string) bool {
+	return t.Mode == AllMagnitudes || string(t.Mode) == mode
+}
@@ -0,0 +1,104 @@
+// models.index.go
+// An Index is a Dataset prepared for fast lookups.
+//
+// The lists in a Dataset are in whatever order the server sent them,
+// so finding (say) the money stock of an industry means scanning the
+// whole stock list. The views ask such questions many times per object
+// per render. An Index answers them from maps, and is built once, when
+// the Dataset is committed to the user's history.
+
+package models
+
+// Stocks are looked up by owner and usage type (Money, Sales, Production, Consumption).
+type stockKey struct {
+	owner int
+	usage string
+}
+
+type Index struct {
+	commodities    map[int]*Commodity
+	industries     map[int]*Industry
+	classes        map[int]*Class
+	industryStocks map[stockKey][]*Industry_Stock
+	classStocks    map[stockKey][]*Class_Stock
+}
+
+// Constructor for an Index. Builds all the maps in one pass over each list.
+// The Index points into the Dataset's lists, so the Dataset must not be
+// modified afterwards.
+func NewIndex(d *Dataset) *Index {
+	ix := Index{
+		commodities:    make(map[int]*Commodity),
+		industries:     make(map[int]*Industry),
+		classes:        make(map[int]*Class),
+		industryStocks: make(map[stockKey][]*Industry_Stock),
+		classStocks:    make(map[stockKey][]*Class_Stock),
+	}
+	for i := range d.Commodities.List {
+		c := &d.Commodities.List[i]
+		ix.commodities[c.Id] = c
+	}
+	for i := range d.Industries.List {
+		ind := &d.Industries.List[i]
+		ix.industries[ind.Id] = ind
+	}
+	for i := range d.Classes.List {
+		c := &d.Classes.List[i]
+		ix.classes[c.Id] = c
+	}
+	for i := range d.IndustryStocks.List {
+		s := &d.IndustryStocks.List[i]
+		key := stockKey{owner: s.Industry_id, usage: s.Usage_type}
+		ix.industryStocks[key] = append(ix.industryStocks[key], s)
+	}
+	for i := range d.ClassStocks.List {
+		s := &d.ClassStocks.List[i]
+		key := stockKey{owner: s.Class_id, usage: s.Usage_type}
+		ix.classStocks[key] = append(ix.classStocks[key], s)
+	}
+	return &ix
+}
+
+// The commodity with the given id, or nil if there is none.
+func (ix *Index) Commodity(id int) *Commodity {
+	return ix.commodities[id]
+}
+
+// The industry with the given id, or nil if there is none.
+func (ix *Index) Industry(id int) *Industry {
+	return ix.industries[id]
+}
+
+// The class with the given id, or nil if there is none.
+func (ix *Index) Class(id int) *Class {
+	return ix.classes[id]
+}
+
+// All the stocks of the given usage type owned by an industry.
+func (ix *Index) IndustryStocks(industryId int, usage string) []*Industry_Stock {
+	return ix.industryStocks[stockKey{owner: industryId, usage: usage}]
+}
+
+// All the stocks of the given usage type owned by a class.
+func (ix *Index) ClassStocks(classId int, usage string) []*Class_Stock {
+	return ix.classStocks[stockKey{owner: classId, usage: usage}]
+}
+
+// The first stock of the given usage type owned by an industry, or
+// NotFoundIndustryStock if it has none. Suitable for usage types such
+// as Money and Sales, of which each owner has exactly one.
+func (ix *Index) IndustryStock(industryId int, usage string) Industry_Stock {
+	if list := ix.IndustryStocks(industryId, usage); len(list) > 0 {
+		return *list[0]
+	}
+	return NotFoundIndustryStock
+}
+
+// The first stock of the given usage type owned by a class, or
+// NotFoundClassStock if it has none.
+func (ix *Index) ClassStock(classId int, usage string) Class_Stock {
+	if list := ix.ClassStocks(classId, usage); len(list) > 0 {
+		return *list[0]
+	}
+	return NotFoundClassStock
+}
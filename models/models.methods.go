@@ -11,13 +11,10 @@ import (
 
 //METHODS OF INDUSTRIES
 
-// crude searches without database implementation
-// justified because we can avoid the complications of a database implementation
-// and the size of the tables is not large, because they are provided on a per-user basis
-// However as the simulations get large, this may become more problematic (let's find out pragmatically)
-// In that case some more sophisticated system, such as a local database, may be needed
-// A simple solution would be to add direct links to related objects in the models
-// perhaps populated by an asynchronous process in the background
+// Relationships between objects are resolved against an explicit Index,
+// which the caller supplies. The Index is built once, when its Dataset is
+// committed to the user's history, and nothing here depends on who the
+// user is, so these methods work on any snapshot of a simulation.
 
 // A default Industry_stock returned if any condition is not met (that is, if the predicated stock does not exist)
 // Used to signal to the user that there has been a programme error
@@ -91,171 +88,203 @@ func (p Pair) DisplayRounded() template.HTML {
 	return template.HTML(htmlString)
 }
 
-// returns the money stock of the given industry
-func (industry Industry) MoneyStock(timeStamp int) Industry_Stock {
-	username := industry.UserName
-	stockList := *Users[username].IndustryStocks(timeStamp)
-	for i := 0; i < len(stockList); i++ {
-		s := stockList[i]
-		if (s.Industry_id == industry.Id) && (s.Usage_type == `Money`) {
-			return s
-		}
+// The change from the compared to the viewed value.
+func (p Pair) Delta() float32 {
+	return p.Viewed - p.Compared
+}
+
+// The change from the compared to the viewed value, as a percentage
+// of the compared value. ok is false if the compared value is zero,
+// when there is no meaningful percentage.
+func (p Pair) PercentChange() (change float32, ok bool) {
+	if p.Compared == 0 {
+		return 0, false
 	}
-	return NotFoundIndustryStock
+	return 100 * (p.Viewed - p.Compared) / p.Compared, true
 }
 
-// returns the sales stock of the given industry
-func (industry Industry) SalesStock(timeStamp int) Industry_Stock {
-	username := industry.UserName
-	stockList := *Users[username].IndustryStocks(timeStamp)
-	for i := 0; i < len(stockList); i++ {
-		s := &stockList[i]
-		if (s.Industry_id == industry.Id) && (s.Usage_type == `Sales`) {
-			return *s
-		}
+// Displays the viewed value with, beneath it, its change since the
+// compared stage and the percentage change. Used when the two stages
+// being compared are far apart, so that the size of a change matters
+// as much as the fact that there was one.
+//
+// A ratio with a zero denominator is NaN. It is shown as a dash, and
+// no change is reported to or from it.
+func (p Pair) DisplayChange() template.HTML {
+	viewedNaN, comparedNaN := p.Viewed != p.Viewed, p.Compared != p.Compared
+	switch {
+	case viewedNaN:
+		return template.HTML("<td style=\"text-align:right\">&ndash;</td>")
+	case comparedNaN:
+		return template.HTML(fmt.Sprintf("<td style=\"text-align:right\">%0.2f<br><span class=\"w3-small w3-text-grey\">from undefined</span></td>", p.Viewed))
 	}
-	return NotFoundIndustryStock
-}
-
-// returns the Labour Power stock of the given industry
-// bit of a botch to use the name of the commodity as a search term
-func (industry Industry) VariableCapital(timeStamp int) Industry_Stock {
-	username := industry.UserName
-	stockList := *Users[username].IndustryStocks(timeStamp)
-	for i := 0; i < len(stockList); i++ {
-		s := &stockList[i]
-		if (s.Industry_id == industry.Id) && (s.Usage_type == `Production`) && (s.CommodityName() == "Labour Power") {
-			return *s
-		}
+	if p.Viewed == p.Compared {
+		return template.HTML(fmt.Sprintf("<td style=\"text-align:right\">%0.2f<br><span class=\"w3-small w3-text-grey\">no change</span></td>", p.Viewed))
 	}
-	return NotFoundIndustryStock
+	colour := "green"
+	if p.Delta() < 0 {
+		colour = "red"
+	}
+	percent := "from zero"
+	if change, ok := p.PercentChange(); ok {
+		percent = fmt.Sprintf("%+0.1f%%", change)
+	}
+	return template.HTML(fmt.Sprintf("<td style=\"text-align:right\">%0.2f<br><span class=\"w3-small\" style=\"color:%s\">%+0.2f (%s)</span></td>",
+		p.Viewed, colour, p.Delta(), percent))
 }
 
-// returns the commodity that an industry produces
-func (industry Industry) OutputCommodity(timeStamp int) *Commodity {
-	return industry.SalesStock(timeStamp).Commodity()
-}
-
-// return the productive capital stock of the given industry
-// under development - at present assumes there is only one
-func (industry Industry) ConstantCapital(timeStamp int) Industry_Stock {
-	username := industry.UserName
-	stockList := *Users[username].IndustryStocks(timeStamp)
-	for i := 0; i < len(stockList); i++ {
-		s := &stockList[i]
-		if (s.Industry_id == industry.Id) && (s.Usage_type == `Production`) && (s.CommodityName() == "Means of Production") {
-			return *s
-		}
+// A label for the name of an object that exists at only one of the two
+// stages being compared. Empty if it exists at both.
+func (p Presence) Label() template.HTML {
+	switch p {
+	case Added:
+		return template.HTML(`<span class="w3-tag w3-small w3-green">new</span>`)
+	case Removed:
+		return template.HTML(`<span class="w3-tag w3-small w3-grey">removed</span>`)
 	}
-	return NotFoundIndustryStock
+	return ``
 }
 
-// returns all the constant capitals of a given industry.
-// Under development.
-// func (industry Industry) ConstantCapitals() []Stock {
-// 	return &stocks [Programming error here]
-// }
+// True if the object no longer exists at the viewed stage, in which
+// case there is nothing to link to.
+func (p Presence) IsRemoved() bool {
+	return p == Removed
+}
 
-// METHODS OF SOCIAL CLASSES
+// returns the money stock of the given industry
+func (industry Industry) MoneyStock(ix *Index) Industry_Stock {
+	return ix.IndustryStock(industry.Id, `Money`)
+}
 
-// returns the sales stock of the given class
-// was 	err = db.SDB.QueryRowx("SELECT * FROM stocks where Owner_Id = ? AND Usage_type =?", class.Id, "Sales").StructScan(&stock)
-func (class Class) MoneyStock(timeStamp int) Class_Stock {
-	username := class.UserName
-	stockList := *Users[username].ClassStocks(timeStamp)
-
-	for i := 0; i < len(stockList); i++ {
-		s := &stockList[i]
-		if (s.Class_id == class.Id) && (s.Usage_type == `Money`) {
-			return *s
-		}
+// returns the sales stock of the given industry
+func (industry Industry) SalesStock(ix *Index) Industry_Stock {
+	return ix.IndustryStock(industry.Id, `Sales`)
+}
+
+// The money and sales stocks of the given industry. The placeholder for an
+// industry that does not exist at this stage (see NewIndustryViews) has
+// neither, so both are zero rather than NotFoundIndustryStock.
+func (industry Industry) tradingStocks(ix *Index) (money Industry_Stock, sales Industry_Stock) {
+	if ix.Industry(industry.Id) == nil {
+		return Industry_Stock{}, Industry_Stock{}
 	}
-	return NotFoundClassStock
+	return industry.MoneyStock(ix), industry.SalesStock(ix)
 }
 
-// returns the sales stock of the given class
-func (class Class) SalesStock(timeStamp int) Class_Stock {
-	username := class.UserName
-	stockList := *Users[username].ClassStocks(timeStamp)
-	for i := 0; i < len(stockList); i++ {
-		s := &stockList[i]
-		if (s.Class_id == class.Id) && (s.Usage_type == `Sales`) {
-			return *s
-		}
+// returns the labour power of the given industry, all kinds together.
+// See VariableCapitals for each kind separately.
+func (industry Industry) VariableCapital(ix *Index) Holding {
+	return industry.VariableCapitals(ix).Total()
+}
+
+// returns the commodity that an industry produces
+func (industry Industry) OutputCommodity(ix *Index) *Commodity {
+	c := ix.Commodity(industry.SalesStock(ix).Commodity_id)
+	if c == nil {
+		return &NotFoundCommodity
 	}
-	return NotFoundClassStock
+	return c
 }
 
-// returns the consumption stock of the given class
-// under development - at present assumes there is only one
-// WAS 	query := `SELECT stocks.* FROM stocks INNER JOIN commodities ON stocks.commodity_id = commodities.id where stocks.owner_id = ? AND Usage_type ="Consumption" AND commodities.name="Consumption"`
-func (class Class) ConsumerGood(timeStamp int) Class_Stock {
-	username := class.UserName
-	stockList := *Users[username].ClassStocks(timeStamp)
+// returns the means of production of the given industry, all commodities together.
+// See ConstantCapitals for each commodity separately.
+func (industry Industry) ConstantCapital(ix *Index) Holding {
+	return industry.ConstantCapitals(ix).Total()
+}
 
-	for i := 0; i < len(stockList); i++ {
-		s := &stockList[i]
-		if (s.Class_id == class.Id) && (s.Usage_type == `Consumption`) {
-			return *s
-		}
+// METHODS OF SOCIAL CLASSES
+
+// returns the money stock of the given class
+func (class Class) MoneyStock(ix *Index) Class_Stock {
+	return ix.ClassStock(class.Id, `Money`)
+}
+
+// returns the sales stock of the given class
+func (class Class) SalesStock(ix *Index) Class_Stock {
+	return ix.ClassStock(class.Id, `Sales`)
+}
+
+// The money and sales stocks of the given class, or zero stocks for the
+// placeholder of a class that does not exist at this stage (see NewClassViews).
+func (class Class) tradingStocks(ix *Index) (money Class_Stock, sales Class_Stock) {
+	if ix.Class(class.Id) == nil {
+		return Class_Stock{}, Class_Stock{}
 	}
-	return NotFoundClassStock
+	return class.MoneyStock(ix), class.SalesStock(ix)
+}
+
+// returns the consumer goods of the given class, all commodities together.
+// See ConsumerGoods for each commodity separately.
+func (class Class) ConsumerGood(ix *Index) Holding {
+	return class.ConsumerGoods(ix).Total()
 }
 
 // METHODS OF INDUSTRY STOCKS
 
 // fetches the name of the owner of this stock
-func (s Industry_Stock) OwnerName() string {
-	username := s.UserName
-	industryList := *Users[username].Industries()
-	for i := 0; i < len(industryList); i++ {
-		ind := &industryList[i]
-		if s.Industry_id == ind.Id {
-			return ind.Name
-		}
-	}
-	return `UNKNOWN OWNER`
+func (s Industry_Stock) OwnerName(ix *Index) string {
+	return s.IndustryName(ix)
 }
 
 // return the name of the commodity that the given Industry_Stock consists of
-// WAS 	rows, err := db.SDB.Queryx("SELECT * FROM commodities where Id = ?", i.Commodity_id)
-func (s Industry_Stock) CommodityName() string {
-	username := s.UserName
-	commodityList := *Users[username].Commodities()
-	for i := 0; i < len(commodityList); i++ {
-		c := commodityList[i]
-		if s.Commodity_id == c.Id {
-			return c.Name
-		}
-	}
-	return `UNKNOWN COMMODITY`
+func (s Industry_Stock) CommodityName(ix *Index) string {
+	return s.Commodity(ix).Name
 }
 
 // return the commodity object that the given stock consists of
-// WAS 	rows, err := db.SDB.Queryx("SELECT * FROM commodities where Id = ?", i.Commodity_id)
-func (s Industry_Stock) Commodity() *Commodity {
-	username := s.UserName
-	commodityList := *Users[username].Commodities()
-	for i := 0; i < len(commodityList); i++ {
-		c := commodityList[i]
-		if s.Commodity_id == c.Id {
-			return &c
-		}
-	}
-	return &NotFoundCommodity
-}
-
-// under development
-// will eventually be parameterised to yield value, price or quantity depending on a 'display' parameter
-func (stock Industry_Stock) DisplaySize(mode string) float32 {
-	switch mode {
-	case `prices`:
-		return stock.Size
-	case `quantities`:
-		return stock.Size // switch in price once this is in the model
-	default:
-		panic(`unknown display mode requested`)
+func (s Industry_Stock) Commodity(ix *Index) *Commodity {
+	c := ix.Commodity(s.Commodity_id)
+	if c == nil {
+		return &NotFoundCommodity
 	}
+	return c
+}
+
+// The size, value or price of the stock, according to the display mode.
+// A mode that shows all magnitudes gives the size.
+func (stock Industry_Stock) DisplaySize(mode DisplayMode) float32 {
+	return Select(mode, stock.Size, stock.Value, stock.Price, stock.Size)
+}
+
+// The size, value or price of the stock, according to the display mode.
+// A mode that shows all magnitudes gives the size.
+func (stock Class_Stock) DisplaySize(mode DisplayMode) float32 {
+	return Select(mode, stock.Size, stock.Value, stock.Price, stock.Size)
+}
+
+// The industry's constant capital in the given display mode, or its price if all are shown.
+func (v IndustryView) ConstantCapitalIn(mode DisplayMode) Pair {
+	return Select(mode, v.ConstantCapitalSize, v.ConstantCapitalValue, v.ConstantCapitalPrice, v.ConstantCapitalPrice)
+}
+
+// The industry's variable capital in the given display mode, or its price if all are shown.
+func (v IndustryView) VariableCapitalIn(mode DisplayMode) Pair {
+	return Select(mode, v.VariableCapitalSize, v.VariableCapitalValue, v.VariableCapitalPrice, v.VariableCapitalPrice)
+}
+
+// The industry's money in the given display mode, or its price if all are shown.
+func (v IndustryView) MoneyStockIn(mode DisplayMode) Pair {
+	return Select(mode, v.MoneyStockSize, v.MoneyStockValue, v.MoneyStockPrice, v.MoneyStockPrice)
+}
+
+// The industry's sales stock in the given display mode, or its price if all are shown.
+func (v IndustryView) SalesStockIn(mode DisplayMode) Pair {
+	return Select(mode, v.SalesStockSize, v.SalesStockValue, v.SalesStockPrice, v.SalesStockPrice)
+}
+
+// The class's consumer goods in the given display mode, or their size if all are shown.
+func (v ClassView) ConsumptionStockIn(mode DisplayMode) Pair {
+	return Select(mode, v.ConsumptionStockSize, v.ConsumptionStockValue, v.ConsumptionStockPrice, v.ConsumptionStockSize)
+}
+
+// The class's money in the given display mode, or its price if all are shown.
+func (v ClassView) MoneyStockIn(mode DisplayMode) Pair {
+	return Select(mode, v.MoneyStockSize, v.MoneyStockValue, v.MoneyStockPrice, v.MoneyStockPrice)
+}
+
+// The class's sales stock in the given display mode, or its size if all are shown.
+func (v ClassView) SalesStockIn(mode DisplayMode) Pair {
+	return Select(mode, v.SalesStockSize, v.SalesStockValue, v.SalesStockPrice, v.SalesStockSize)
 }
 
 // (Experimental) Creates a url to link to this simulation, to be used in templates such as dashboard
@@ -267,21 +296,14 @@ func (s Simulation) Link() string {
 
 // fetches the industry that owns this industry stock
 // If it has none (an error, but we need to diagnose it) return nil.
-func (s Industry_Stock) Industry() *Industry {
-	industryList := *Users[s.UserName].Industries()
-	for i := 0; i < len(industryList); i++ {
-		ind := &industryList[i]
-		if s.Industry_id == ind.Id {
-			return ind
-		}
-	}
-	return nil
+func (s Industry_Stock) Industry(ix *Index) *Industry {
+	return ix.Industry(s.Industry_id)
 }
 
 // fetches the name of the industry that owns this industry stock.
 // If it has none (an error, but we need to diagnose it) return "UNKNOWN INDUSTRY"
-func (s Industry_Stock) IndustryName() string {
-	i := s.Industry()
+func (s Industry_Stock) IndustryName(ix *Index) string {
+	i := s.Industry(ix)
 	if i == nil {
 		return "UNKNOWN INDUSTRY"
 	}
@@ -292,21 +314,14 @@ func (s Industry_Stock) IndustryName() string {
 
 // fetches the class that owns this Class_stock
 // If it has none (an error, but we need to diagnose it) return nil.
-func (s Class_Stock) Class() *Class {
-	classList := *Users[s.UserName].Classes()
-	for i := 0; i < len(classList); i++ {
-		ind := &classList[i]
-		if s.Class_id == ind.Id {
-			return ind
-		}
-	}
-	return nil
+func (s Class_Stock) Class(ix *Index) *Class {
+	return ix.Class(s.Class_id)
 }
 
 // fetches the name of the Class that owns this Class_stock.
 // If it has none (an error, but we need to diagnose it) return "UNKNOWN CLASS"
-func (s Class_Stock) ClassName() string {
-	c := s.Class()
+func (s Class_Stock) ClassName(ix *Index) string {
+	c := s.Class(ix)
 	if c == nil {
 		return "UNKNOWN CLASS"
 	}
@@ -315,16 +330,12 @@ func (s Class_Stock) ClassName() string {
 
 // Return the name of the commodity that this Class_Stock consists of.
 // Return "UNKNOWN COMMODITY" if this is not found.
-func (s Class_Stock) CommodityName() string {
-	username := s.UserName
-	commodityList := *Users[username].Commodities()
-	for i := 0; i < len(commodityList); i++ {
-		c := commodityList[i]
-		if s.Commodity_id == c.Id {
-			return c.Name
-		}
+func (s Class_Stock) CommodityName(ix *Index) string {
+	c := ix.Commodity(s.Commodity_id)
+	if c == nil {
+		return `UNKNOWN COMMODITY`
 	}
-	return `UNKNOWN COMMODITY`
+	return c.Name
 }
 
 func (u User) Get_current_state() string {
@@ -343,9 +354,15 @@ func (u User) Get_current_state() string {
 	return "UNKNOWN"
 }
 
+// The stage that the user's current simulation is waiting to carry out.
+// Unknown if the simulation cannot be found or its state is not recognised.
+func (u User) CurrentStage() Stage {
+	return ParseStage(u.Get_current_state())
+}
+
 // helper function to set the state of the current simulation
 // if we fail it's a programme error so we don't test for that
-func (u User) Set_current_state(new_state string) {
+func (u *User) Set_current_state(new_state string) {
 	id := u.CurrentSimulationID
 	sims := *u.Simulations()
 	log.Output(1, fmt.Sprintf("resetting state to %s for user %s", new_state, u.UserName))
@@ -355,17 +372,13 @@ func (u User) Set_current_state(new_state string) {
 			(*s).State = new_state
 			return
 		}
-		log.Output(1, fmt.Sprintf("simulation with id %d not found", id))
 	}
+	log.Output(1, fmt.Sprintf("simulation with id %d not found", id))
 }
 
 // Create a CommodityView object for display in a template
 // taking data from two Commodity objects; one being viewed now,
 // the other showing the state of the simulation at some time in the 'past'
-// TODO the views are not explicitly timestamped. As a result I
-// TODO suspect that links to associated items may not work if
-// TODO they point to the wrong individual objects.
-// TODO will work on the industryViews first and then return to this issue/
 func NewCommodityView(v *Commodity, c *Commodity) *CommodityView {
 	newCommodityView := CommodityView{
 		Id:                          v.Id,
@@ -387,15 +400,64 @@ func NewCommodityView(v *Commodity, c *Commodity) *CommodityView {
 	return &newCommodityView
 }
 
+// Creates a slice of CommodityViews, matching the viewed and compared
+// commodities by Id (see joinById).
 func NewCommodityViews(v *[]Commodity, c *[]Commodity) *[]CommodityView {
-	var newViews = make([]CommodityView, len(*v))
-	for i := range *v {
-		newView := NewCommodityView(&(*v)[i], &(*c)[i])
+	joined := joinById(*v, *c, func(x *Commodity) int { return x.Id })
+	var newViews = make([]CommodityView, len(joined))
+	for i, j := range joined {
+		vc, cc := j.viewed, j.compared
+		switch j.presence {
+		case Added:
+			cc = &Commodity{Id: vc.Id, Name: vc.Name, Origin: vc.Origin, Usage: vc.Usage}
+		case Removed:
+			vc = &Commodity{Id: cc.Id, Name: cc.Name, Origin: cc.Origin, Usage: cc.Usage}
+		}
+		newView := NewCommodityView(vc, cc)
+		newView.Presence = j.presence
 		newViews[i] = *newView
 	}
 	return &newViews
 }
 
+// One object of a comparison, as it was at the viewed and at the compared stage.
+// viewed is nil if the object was Removed; compared is nil if it was Added.
+type joined[T any] struct {
+	viewed   *T
+	compared *T
+	presence Presence
+}
+
+// Matches the objects of two snapshots by Id, so that each object is
+// compared with itself whatever order the server sent the lists in.
+//
+//	id extracts the Id of an object.
+//	Returns: the viewed objects in their own order, each with its counterpart,
+//	followed by the objects that exist only at the compared stage, in their order.
+func joinById[T any](v []T, c []T, id func(*T) int) []joined[T] {
+	compared := make(map[int]*T, len(c))
+	for i := range c {
+		compared[id(&c[i])] = &c[i]
+	}
+	result := make([]joined[T], 0, len(v))
+	seen := make(map[int]bool, len(v))
+	for i := range v {
+		key := id(&v[i])
+		seen[key] = true
+		if match, ok := compared[key]; ok {
+			result = append(result, joined[T]{viewed: &v[i], compared: match, presence: Matched})
+		} else {
+			result = append(result, joined[T]{viewed: &v[i], presence: Added})
+		}
+	}
+	for i := range c {
+		if !seen[id(&c[i])] {
+			result = append(result, joined[T]{compared: &c[i], presence: Removed})
+		}
+	}
+	return result
+}
+
 // Create an IndustryView object for display in a template
 // taking data from two Industry objects; one being viewed now,
 // the other showing the state of the simulation at some time in the 'past'.
@@ -406,36 +468,52 @@ func NewCommodityViews(v *[]Commodity, c *[]Commodity) *[]CommodityView {
 //
 //		v the viewed industry
 //		c the comparator industry
-//		vTimeStamp the viewed TimeStamp
-//		cTimeStamp the comparator TimeStamp
+//		vx the Index of the viewed Dataset
+//		cx the Index of the comparator Dataset
 //
 //	 Returns: a new IndustryView
 
-func NewIndustryView(vTimeStamp int, cTimeStamp int, v *Industry, c *Industry) *IndustryView {
+func NewIndustryView(vx *Index, cx *Index, v *Industry, c *Industry) *IndustryView {
+	// Look up each stock once, rather than once for each of its magnitudes.
+	vConstants, cConstants := v.ConstantCapitals(vx), c.ConstantCapitals(cx)
+	vVariables, cVariables := v.VariableCapitals(vx), c.VariableCapitals(cx)
+	vConstant, cConstant := vConstants.Total(), cConstants.Total()
+	vVariable, cVariable := vVariables.Total(), cVariables.Total()
+	vMoney, vSales := v.tradingStocks(vx)
+	cMoney, cSales := c.tradingStocks(cx)
+
+	// An industry that has been removed has no sales stock at the viewed stage.
+	outputCommodityId := vSales.Commodity_id
+	if outputCommodityId == 0 {
+		outputCommodityId = cSales.Commodity_id
+	}
+
 	newView := IndustryView{
 		Id:                   v.Id,
 		Name:                 v.Name,
 		Output:               v.Output,
-		OutputCommodityId:    v.OutputCommodity(vTimeStamp).Id, // TODO check if this causes any problems
+		OutputCommodityId:    outputCommodityId,
 		Output_Scale:         Pair{Viewed: (v.Output_Scale), Compared: (c.Output_Scale)},
 		Output_Growth_Rate:   Pair{Viewed: (v.Output_Growth_Rate), Compared: (c.Output_Growth_Rate)},
 		Initial_Capital:      Pair{Viewed: (v.Initial_Capital), Compared: (c.Initial_Capital)},
 		Work_In_Progress:     Pair{Viewed: (v.Work_In_Progress), Compared: (c.Work_In_Progress)},
 		Current_Capital:      Pair{Viewed: (v.Current_Capital), Compared: (c.Current_Capital)},
-		ConstantCapitalSize:  Pair{Viewed: (v.ConstantCapital(vTimeStamp).Size), Compared: (c.ConstantCapital(cTimeStamp).Size)},
-		ConstantCapitalValue: Pair{Viewed: (v.ConstantCapital(vTimeStamp).Value), Compared: (c.ConstantCapital(cTimeStamp).Value)},
-		ConstantCapitalPrice: Pair{Viewed: (v.ConstantCapital(vTimeStamp).Price), Compared: (c.ConstantCapital(cTimeStamp).Price)},
-		VariableCapitalSize:  Pair{Viewed: (v.VariableCapital(vTimeStamp).Size), Compared: (c.VariableCapital(cTimeStamp).Size)},
-		VariableCapitalValue: Pair{Viewed: (v.VariableCapital(vTimeStamp).Value), Compared: (c.VariableCapital(cTimeStamp).Value)},
-		VariableCapitalPrice: Pair{Viewed: (v.VariableCapital(vTimeStamp).Price), Compared: (c.VariableCapital(cTimeStamp).Price)},
-		MoneyStockSize:       Pair{Viewed: (v.MoneyStock(vTimeStamp).Size), Compared: (c.MoneyStock(cTimeStamp).Size)},
-		MoneyStockValue:      Pair{Viewed: (v.MoneyStock(vTimeStamp).Value), Compared: (c.MoneyStock(cTimeStamp).Value)},
-		MoneyStockPrice:      Pair{Viewed: (v.MoneyStock(vTimeStamp).Price), Compared: (c.MoneyStock(cTimeStamp).Price)},
-		SalesStockSize:       Pair{Viewed: (v.SalesStock(vTimeStamp).Size), Compared: (c.SalesStock(cTimeStamp).Size)},
-		SalesStockValue:      Pair{Viewed: (v.SalesStock(vTimeStamp).Value), Compared: (c.SalesStock(cTimeStamp).Value)},
-		SalesStockPrice:      Pair{Viewed: (v.SalesStock(vTimeStamp).Price), Compared: (c.SalesStock(cTimeStamp).Price)},
+		ConstantCapitalSize:  Pair{Viewed: (vConstant.Size), Compared: (cConstant.Size)},
+		ConstantCapitalValue: Pair{Viewed: (vConstant.Value), Compared: (cConstant.Value)},
+		ConstantCapitalPrice: Pair{Viewed: (vConstant.Price), Compared: (cConstant.Price)},
+		VariableCapitalSize:  Pair{Viewed: (vVariable.Size), Compared: (cVariable.Size)},
+		VariableCapitalValue: Pair{Viewed: (vVariable.Value), Compared: (cVariable.Value)},
+		VariableCapitalPrice: Pair{Viewed: (vVariable.Price), Compared: (cVariable.Price)},
+		MoneyStockSize:       Pair{Viewed: (vMoney.Size), Compared: (cMoney.Size)},
+		MoneyStockValue:      Pair{Viewed: (vMoney.Value), Compared: (cMoney.Value)},
+		MoneyStockPrice:      Pair{Viewed: (vMoney.Price), Compared: (cMoney.Price)},
+		SalesStockSize:       Pair{Viewed: (vSales.Size), Compared: (cSales.Size)},
+		SalesStockValue:      Pair{Viewed: (vSales.Value), Compared: (cSales.Value)},
+		SalesStockPrice:      Pair{Viewed: (vSales.Price), Compared: (cSales.Price)},
 		Profit:               Pair{Viewed: (v.Profit), Compared: (c.Profit)},
 		Profit_Rate:          Pair{Viewed: (v.Profit_Rate), Compared: (c.Profit_Rate)},
+		ConstantCapitals:     NewHoldingViews(vx, cx, vConstants, cConstants),
+		VariableCapitals:     NewHoldingViews(vx, cx, vVariables, cVariables),
 	}
 
 	// newViewAsString, _ := json.MarshalIndent(newView, " ", " ")
@@ -448,22 +526,38 @@ func NewIndustryView(vTimeStamp int, cTimeStamp int, v *Industry, c *Industry) *
 // simulation - viewed and compared.
 // This allows us to display, visually, changes that have
 // taken place between any two steps in the simulation.
+// Industries are matched by Id (see joinById).
 //
-//	vTimeStamp: the viewed TimeStamp.
-//	cTimeStamp: the comparator TimeStamp.
-//	v: a snapsnot industry array (Department I, Department II, etc) at state vTimeStamp.
-//	v: a snapsnot industry array (Department I, Department II, etc) at state cTimeStamp.
+//	vx: the Index of the viewed Dataset.
+//	cx: the Index of the comparator Dataset.
+//	v: a snapsnot industry array (Department I, Department II, etc) at the viewed stage.
+//	c: a snapsnot industry array (Department I, Department II, etc) at the comparator stage.
 //	returns: a slice of IndustryViews.
-func NewIndustryViews(vTimeStamp int, cTimeStamp int, v *[]Industry, c *[]Industry) *[]IndustryView {
-	var newViews = make([]IndustryView, len(*v))
-	for i := range *v {
-		newView := NewIndustryView(vTimeStamp, cTimeStamp, &(*v)[i], &(*c)[i])
+func NewIndustryViews(vx *Index, cx *Index, v *[]Industry, c *[]Industry) *[]IndustryView {
+	joined := joinById(*v, *c, func(x *Industry) int { return x.Id })
+	var newViews = make([]IndustryView, len(joined))
+	for i, j := range joined {
+		vi, ci := j.viewed, j.compared
+		switch j.presence {
+		case Added:
+			ci = &Industry{Id: vi.Id, Name: vi.Name, Output: vi.Output}
+		case Removed:
+			vi = &Industry{Id: ci.Id, Name: ci.Name, Output: ci.Output}
+		}
+		newView := NewIndustryView(vx, cx, vi, ci)
+		newView.Presence = j.presence
 		newViews[i] = *newView
 	}
 	return &newViews
 }
 
-func NewClassView(vTimeStamp int, cTimeStamp int, v *Class, c *Class) *ClassView {
+func NewClassView(vx *Index, cx *Index, v *Class, c *Class) *ClassView {
+	// Look up each stock once, rather than once for each of its magnitudes.
+	vConsumptions, cConsumptions := v.ConsumerGoods(vx), c.ConsumerGoods(cx)
+	vConsumption, cConsumption := vConsumptions.Total(), cConsumptions.Total()
+	vMoney, vSales := v.tradingStocks(vx)
+	cMoney, cSales := c.tradingStocks(cx)
+
 	newView := ClassView{
 		Id:                    v.Id,
 		Name:                  v.Name,
@@ -475,15 +569,16 @@ func NewClassView(vTimeStamp int, cTimeStamp int, v *Class, c *Class) *ClassView
 		Consumption_Ratio:     v.Consumption_Ratio,
 		Revenue:               Pair{Viewed: (v.Revenue), Compared: (c.Revenue)},
 		Assets:                Pair{Viewed: (v.Assets), Compared: (c.Assets)},
-		ConsumptionStockSize:  Pair{Viewed: (v.ConsumerGood(vTimeStamp).Size), Compared: (c.ConsumerGood(vTimeStamp).Size)},
-		ConsumptionStockValue: Pair{Viewed: (v.ConsumerGood(vTimeStamp).Value), Compared: (c.ConsumerGood(vTimeStamp).Value)},
-		ConsumptionStockPrice: Pair{Viewed: (v.ConsumerGood(vTimeStamp).Price), Compared: (c.ConsumerGood(vTimeStamp).Price)},
-		MoneyStockSize:        Pair{Viewed: (v.MoneyStock(vTimeStamp).Size), Compared: (c.MoneyStock(vTimeStamp).Size)},
-		MoneyStockValue:       Pair{Viewed: (v.MoneyStock(vTimeStamp).Value), Compared: (c.MoneyStock(vTimeStamp).Value)},
-		MoneyStockPrice:       Pair{Viewed: (v.MoneyStock(vTimeStamp).Price), Compared: (c.MoneyStock(vTimeStamp).Price)},
-		SalesStockSize:        Pair{Viewed: (v.SalesStock(vTimeStamp).Size), Compared: (c.SalesStock(vTimeStamp).Size)},
-		SalesStockValue:       Pair{Viewed: (v.SalesStock(vTimeStamp).Value), Compared: (c.SalesStock(vTimeStamp).Value)},
-		SalesStockPrice:       Pair{Viewed: (v.SalesStock(vTimeStamp).Price), Compared: (c.SalesStock(vTimeStamp).Price)},
+		ConsumptionStockSize:  Pair{Viewed: (vConsumption.Size), Compared: (cConsumption.Size)},
+		ConsumptionStockValue: Pair{Viewed: (vConsumption.Value), Compared: (cConsumption.Value)},
+		ConsumptionStockPrice: Pair{Viewed: (vConsumption.Price), Compared: (cConsumption.Price)},
+		MoneyStockSize:        Pair{Viewed: (vMoney.Size), Compared: (cMoney.Size)},
+		MoneyStockValue:       Pair{Viewed: (vMoney.Value), Compared: (cMoney.Value)},
+		MoneyStockPrice:       Pair{Viewed: (vMoney.Price), Compared: (cMoney.Price)},
+		SalesStockSize:        Pair{Viewed: (vSales.Size), Compared: (cSales.Size)},
+		SalesStockValue:       Pair{Viewed: (vSales.Value), Compared: (cSales.Value)},
+		SalesStockPrice:       Pair{Viewed: (vSales.Price), Compared: (cSales.Price)},
+		ConsumerGoods:         NewHoldingViews(vx, cx, vConsumptions, cConsumptions),
 	}
 	return &newView
 }
@@ -493,16 +588,26 @@ func NewClassView(vTimeStamp int, cTimeStamp int, v *Class, c *Class) *ClassView
 // simulation - viewed and compared.
 // This allows us to display, visually, changes that have
 // taken place between any two steps in the simulation.
+// Classes are matched by Id (see joinById).
 //
-//	vTimeStamp: the viewed TimeStamp.
-//	cTimeStamp: the comparator TimeStamp.
-//	v: a snapsnot Class array (Department I, Department II, etc) at state vTimeStamp.
-//	v: a snapsnot Class array (Department I, Department II, etc) at state cTimeStamp.
+//	vx: the Index of the viewed Dataset.
+//	cx: the Index of the comparator Dataset.
+//	v: a snapsnot Class array (Department I, Department II, etc) at the viewed stage.
+//	c: a snapsnot Class array (Department I, Department II, etc) at the comparator stage.
 //	returns: a slice of ClassViews.
-func NewClassViews(vTimeStamp int, cTimeStamp int, v *[]Class, c *[]Class) *[]ClassView {
-	var newViews = make([]ClassView, len(*v))
-	for i := range *v {
-		newView := NewClassView(vTimeStamp, cTimeStamp, &(*v)[i], &(*c)[i])
+func NewClassViews(vx *Index, cx *Index, v *[]Class, c *[]Class) *[]ClassView {
+	joined := joinById(*v, *c, func(x *Class) int { return x.Id })
+	var newViews = make([]ClassView, len(joined))
+	for i, j := range joined {
+		vc, cc := j.viewed, j.compared
+		switch j.presence {
+		case Added:
+			cc = &Class{Id: vc.Id, Name: vc.Name, Simulation_id: vc.Simulation_id, UserName: vc.UserName}
+		case Removed:
+			vc = &Class{Id: cc.Id, Name: cc.Name, Simulation_id: cc.Simulation_id, UserName: cc.UserName}
+		}
+		newView := NewClassView(vx, cx, vc, cc)
+		newView.Presence = j.presence
 		newViews[i] = *newView
 	}
 	return &newViews
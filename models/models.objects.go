@@ -12,6 +12,17 @@ type Pair struct {
 	Compared float32
 }
 
+// Says whether an object in a comparison exists at both the viewed and
+// the compared stage. Objects are matched by Id, so an industry that is
+// created or wound up between the two stages shows up as Added or Removed.
+type Presence string
+
+const (
+	Matched Presence = ``        // the object exists at both stages
+	Added   Presence = `new`     // the object exists only at the viewed stage
+	Removed Presence = `removed` // the object exists only at the compared stage
+)
+
 // This contains a record, generated by the server, of the results of the actions
 type Trace struct {
 	Id            int `json:"id" gorm:"primary_key"`
@@ -86,6 +97,7 @@ type CommodityView struct {
 	Allocation_Ratio            Pair
 	Monetarily_Effective_Demand float32
 	Investment_Proportion       float32
+	Presence                    Presence // whether the object exists at both stages
 }
 
 type Industry struct {
@@ -128,6 +140,9 @@ type IndustryView struct {
 	SalesStockPrice      Pair
 	Profit               Pair
 	Profit_Rate          Pair
+	ConstantCapitals     []HoldingView // the means of production, by commodity; ConstantCapitalSize etc are their totals
+	VariableCapitals     []HoldingView // the labour power, by kind; VariableCapitalSize etc are their totals
+	Presence             Presence      // whether the object exists at both stages
 }
 
 type Class struct {
@@ -163,6 +178,8 @@ type ClassView struct {
 	SalesStockSize        Pair
 	SalesStockValue       Pair
 	SalesStockPrice       Pair
+	ConsumerGoods         []HoldingView // the consumer goods, by commodity; ConsumptionStockSize etc are their totals
+	Presence              Presence      // whether the object exists at both stages
 }
 
 type Industry_Stock struct {
@@ -194,14 +211,6 @@ type Class_Stock struct {
 	Demand        float32 `json:"demand" `
 }
 
-// This list of templates is common to all users.
-// It would normally change only when the database is reset from
-// immutable fixtures using Refresh().
-// It is initialized when this frontend restarts.
-// In future there should be some procedure for adding new templates
-// or editing existing ones.
-var TemplateList []Simulation
-
 // a HistoryItem contains all the information describing a stage
 // of the Simulation. The UserData object contains a map[int]HistoryItem
 // which lets the user review past stages of the current Simulation
@@ -231,5 +240,3 @@ func NewHistoryItem() HistoryItem {
 	}
 	return NewItem
 }
-
-var SimulationList []Simulation
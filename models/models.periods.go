@@ -0,0 +1,93 @@
+// models.periods.go
+// Groups the stages of a user's history into periods.
+//
+// Each action creates a new Dataset, so the history is a sequence of
+// stages: demand, supply, trade, produce, consume, invest. A period is
+// one complete circuit of these. It opens at a stage waiting to Demand
+// and closes at the next stage waiting to Demand, when Invest has been
+// carried out, so the closing stage of one period is the opening stage
+// of the next. The last period is open (incomplete) until the circuit
+// is finished; it then closes at the latest stage.
+//
+// A history that was picked up part way through a circuit (when the
+// client found the user's simulation already under way, say) begins
+// with a partial period. It closes like any other, but since it does
+// not cover the whole circuit it is never complete.
+
+package models
+
+import "fmt"
+
+// One circuit of the simulation.
+type Period struct {
+	Number   int  // counting from 1
+	Opening  int  // time stamp of the stage at which the period began
+	Closing  int  // time stamp of the stage at which it ended, or the latest stage if it has not
+	Partial  bool // true if the history began part way through the period
+	Complete bool // true if the period opened at Demand and closed after Invest
+}
+
+// The stages of the period, from opening to closing inclusive.
+func (p Period) Stages() []int {
+	stages := make([]int, 0, p.Closing-p.Opening+1)
+	for ts := p.Opening; ts <= p.Closing; ts++ {
+		stages = append(stages, ts)
+	}
+	return stages
+}
+
+// Describes the period to the user.
+func (p Period) Label() string {
+	switch {
+	case p.Partial:
+		return fmt.Sprintf("Period %d (partial, stages %d to %d)", p.Number, p.Opening, p.Closing)
+	case !p.Complete:
+		return fmt.Sprintf("Period %d (in progress, stages %d to %d)", p.Number, p.Opening, p.Closing)
+	}
+	return fmt.Sprintf("Period %d (stages %d to %d)", p.Number, p.Opening, p.Closing)
+}
+
+// Lists the periods of the user's history, earliest first.
+func (u User) Periods() []Period {
+	var periods []Period
+	history := u.History()
+	for _, h := range history {
+		n := len(periods)
+		switch {
+		case n == 0 || h.Period != periods[n-1].Number:
+			if n > 0 {
+				// The stage waiting to Demand again closes the previous period
+				last := &periods[n-1]
+				last.Closing = h.TimeStamp
+				last.Complete = !last.Partial && ParseStage(history[h.TimeStamp-1].State) == Invest
+			}
+			periods = append(periods, Period{Number: h.Period, Opening: h.TimeStamp, Closing: h.TimeStamp, Partial: ParseStage(h.State) != Demand})
+		default:
+			periods[n-1].Closing = h.TimeStamp
+		}
+	}
+	return periods
+}
+
+// The period that contains the given stage. A stage that both closes
+// one period and opens the next belongs to the one it opens.
+func (u User) PeriodOf(timeStamp int) Period {
+	periods := u.Periods()
+	for i := len(periods) - 1; i >= 0; i-- {
+		if periods[i].Opening <= timeStamp {
+			return periods[i]
+		}
+	}
+	return Period{Number: 1}
+}
+
+// The number of periods in a year, from the user's current simulation
+// as recorded at the given stage. One if the simulation does not say.
+func (u User) PeriodsPerYear(timeStamp int) float64 {
+	for _, s := range u.Dataset(timeStamp).Simulations.List {
+		if s.Id == u.CurrentSimulationID && s.Periods_Per_Year > 0 {
+			return float64(s.Periods_Per_Year)
+		}
+	}
+	return 1
+}
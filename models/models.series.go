@@ -0,0 +1,208 @@
+// models.series.go
+// Time series of simulation variables, taken from a user's history.
+//
+// Any numeric field of a commodity, industry, class or simulation can
+// be followed through every stage of the history, or sampled once per
+// period. Fields are found by name using reflection, so a field added
+// to one of the objects becomes available without further work here.
+
+package models
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// The kinds of object whose fields can be followed through the history.
+type ObjectKind string
+
+const (
+	CommodityKind  ObjectKind = `commodity`
+	IndustryKind   ObjectKind = `industry`
+	ClassKind      ObjectKind = `class`
+	SimulationKind ObjectKind = `simulation`
+)
+
+var ObjectKinds = []ObjectKind{CommodityKind, IndustryKind, ClassKind, SimulationKind}
+
+// Converts a kind named in a URL into an ObjectKind.
+// ok is false if there is no such kind.
+func ParseObjectKind(kind string) (k ObjectKind, ok bool) {
+	for _, k := range ObjectKinds {
+		if string(k) == kind {
+			return k, true
+		}
+	}
+	return CommodityKind, false
+}
+
+func (k ObjectKind) objectType() reflect.Type {
+	switch k {
+	case IndustryKind:
+		return reflect.TypeOf(Industry{})
+	case ClassKind:
+		return reflect.TypeOf(Class{})
+	case SimulationKind:
+		return reflect.TypeOf(Simulation{})
+	}
+	return reflect.TypeOf(Commodity{})
+}
+
+// The numeric fields of this kind of object, in the order they are declared.
+// Ids and time stamps are numbers but not variables, so they are left out.
+func (k ObjectKind) Fields() []string {
+	t := k.objectType()
+	var fields []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !isNumeric(f.Type.Kind()) || f.Name == `Id` || f.Name == `Time_Stamp` || strings.HasSuffix(strings.ToLower(f.Name), `_id`) {
+			continue
+		}
+		fields = append(fields, f.Name)
+	}
+	return fields
+}
+
+// True if field is one of the numeric fields of this kind of object.
+func (k ObjectKind) HasField(field string) bool {
+	for _, f := range k.Fields() {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+func isNumeric(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// The value of a numeric field of obj, which must be a struct.
+// NaN if there is no such field.
+func fieldValue(obj any, field string) float64 {
+	f := reflect.ValueOf(obj).FieldByName(field)
+	if !f.IsValid() {
+		return math.NaN()
+	}
+	switch {
+	case f.CanFloat():
+		return f.Float()
+	case f.CanInt():
+		return float64(f.Int())
+	}
+	return math.NaN()
+}
+
+// An object that can be charted, as offered to the user.
+type NamedObject struct {
+	Id   int
+	Name string
+}
+
+// The objects of the given kind in the Dataset at timeStamp.
+func (u User) Objects(kind ObjectKind, timeStamp int) []NamedObject {
+	d := u.Dataset(timeStamp)
+	var list []NamedObject
+	switch kind {
+	case CommodityKind:
+		for _, c := range d.Commodities.List {
+			list = append(list, NamedObject{Id: c.Id, Name: c.Name})
+		}
+	case IndustryKind:
+		for _, i := range d.Industries.List {
+			list = append(list, NamedObject{Id: i.Id, Name: i.Name})
+		}
+	case ClassKind:
+		for _, c := range d.Classes.List {
+			list = append(list, NamedObject{Id: c.Id, Name: c.Name})
+		}
+	case SimulationKind:
+		for _, s := range d.Simulations.List {
+			if s.Id == u.CurrentSimulationID {
+				list = append(list, NamedObject{Id: s.Id, Name: s.Name})
+			}
+		}
+	}
+	return list
+}
+
+// The object of the given kind and id in the Dataset at timeStamp, or nil if there is none.
+func (u User) object(kind ObjectKind, id int, timeStamp int) any {
+	switch kind {
+	case SimulationKind:
+		for _, s := range u.Dataset(timeStamp).Simulations.List {
+			if s.Id == id {
+				return s
+			}
+		}
+		return nil
+	}
+	ix := u.Index(timeStamp)
+	switch kind {
+	case CommodityKind:
+		if c := ix.Commodity(id); c != nil {
+			return *c
+		}
+	case IndustryKind:
+		if i := ix.Industry(id); i != nil {
+			return *i
+		}
+	case ClassKind:
+		if c := ix.Class(id); c != nil {
+			return *c
+		}
+	}
+	return nil
+}
+
+// How the history is sampled along the x-axis of a chart.
+type Axis string
+
+const (
+	StageAxis  Axis = `stage`  // every stage in the history
+	PeriodAxis Axis = `period` // the start, and the end of each completed period
+)
+
+// The time stamps sampled along the given axis, with a label for each.
+//
+// On the PeriodAxis, a period ends when Invest has been carried out and
+// the simulation is waiting for Demand again. The point at the end of a
+// period is therefore the same stage as the start of the next.
+func (u User) AxisPoints(axis Axis) (timeStamps []int, labels []string) {
+	for _, h := range u.History() {
+		switch {
+		case axis != PeriodAxis:
+			timeStamps = append(timeStamps, h.TimeStamp)
+			labels = append(labels, fmt.Sprint(h.TimeStamp))
+		case h.TimeStamp == 0:
+			timeStamps = append(timeStamps, 0)
+			labels = append(labels, `start`)
+		case ParseStage(h.State) == Demand:
+			timeStamps = append(timeStamps, h.TimeStamp)
+			labels = append(labels, fmt.Sprintf("P%d", h.Period-1))
+		}
+	}
+	return timeStamps, labels
+}
+
+// The values of one field of one object at each of the given time stamps.
+// A value is NaN at a stage where the object does not exist.
+func (u User) Series(kind ObjectKind, id int, field string, timeStamps []int) []float64 {
+	values := make([]float64, len(timeStamps))
+	for i, ts := range timeStamps {
+		obj := u.object(kind, id, ts)
+		if obj == nil {
+			values[i] = math.NaN()
+			continue
+		}
+		values[i] = fieldValue(obj, field)
+	}
+	return values
+}
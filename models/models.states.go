@@ -0,0 +1,113 @@
+// models.states.go
+// The circuit of a simulation as a state machine.
+// Each stage is both a state of the simulation and the action that
+// carries it out: a simulation in state DEMAND is waiting for the
+// demand action, after which it is in state SUPPLY, and so on.
+
+package models
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// A stage of the circuit. The values are the states sent by the server.
+type Stage string
+
+const (
+	Demand  Stage = `DEMAND`
+	Supply  Stage = `SUPPLY`
+	Trade   Stage = `TRADE`
+	Produce Stage = `PRODUCE`
+	Consume Stage = `CONSUME`
+	Invest  Stage = `INVEST`
+	Unknown Stage = `UNKNOWN`
+)
+
+// The stages in the order they are carried out.
+var Circuit = []Stage{Demand, Supply, Trade, Produce, Consume, Invest}
+
+// Converts a state sent by the server into a Stage.
+// Returns Unknown if it is not one of the stages of the circuit.
+func ParseStage(state string) Stage {
+	for _, s := range Circuit {
+		if string(s) == strings.ToUpper(state) {
+			return s
+		}
+	}
+	return Unknown
+}
+
+// Converts an action, as named in a URL, into the Stage it carries out.
+// Returns Unknown if there is no such action.
+func ParseAction(action string) Stage {
+	for _, s := range Circuit {
+		if s.Action() == action {
+			return s
+		}
+	}
+	return Unknown
+}
+
+// The name of the action that carries out this stage, as used in URLs
+// and by the server's action endpoint.
+func (s Stage) Action() string {
+	return strings.ToLower(string(s))
+}
+
+// The stage which follows this one. Invest is followed by Demand,
+// which begins the next period.
+func (s Stage) Next() Stage {
+	for i, c := range Circuit {
+		if c == s {
+			return Circuit[(i+1)%len(Circuit)]
+		}
+	}
+	return Unknown
+}
+
+// True if this stage completes a period.
+func (s Stage) EndsPeriod() bool {
+	return s == Invest
+}
+
+// Reports that an action cannot be carried out in the current state.
+type StageError struct {
+	Action  string // The action that was requested
+	Current Stage  // The state the simulation is in
+}
+
+func (e *StageError) Error() string {
+	if ParseAction(e.Action) == Unknown {
+		return fmt.Sprintf("there is no action called %q", e.Action)
+	}
+	return fmt.Sprintf("cannot %s when the simulation is waiting to %s", e.Action, e.Current.Action())
+}
+
+// StageError is shown to the user through utils.DisplayError.
+func (e *StageError) HttpStatus() int {
+	if ParseAction(e.Action) == Unknown {
+		return http.StatusBadRequest
+	}
+	return http.StatusConflict
+}
+
+func (e *StageError) Explanation() string {
+	if ParseAction(e.Action) == Unknown {
+		return "The circuit consists of Demand, Supply, Trade, Produce, Consume and Invest. Choose one of these from the menu."
+	}
+	return fmt.Sprintf("The stages of the circuit must be carried out in order. The next stage is %s; choose it from the menu.", e.Current.Action())
+}
+
+// Checks that an action may be carried out when the simulation is in state current.
+//
+//	Returns: the stage the action carries out, if it is allowed.
+//	Returns: a *StageError if the action is unknown or out of order.
+func CheckAction(current Stage, action string) (Stage, error) {
+	requested := ParseAction(action)
+	if requested == Unknown || requested != current {
+		return Unknown, &StageError{Action: action, Current: current}
+	}
+	return requested, nil
+}
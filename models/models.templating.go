@@ -15,3 +15,67 @@ func (c Commodity) Format(n float64) string {
 func (c Commodity) Display_Size() string {
 	return fmt.Sprintf("%.2f", c.Size)
 }
+
+// Which magnitudes a page shows: the sizes (quantities) of things,
+// their values, or their prices. The empty mode shows all of them.
+type DisplayMode string
+
+const (
+	AllMagnitudes DisplayMode = ``
+	Quantities    DisplayMode = `quantities`
+	Values        DisplayMode = `values`
+	Prices        DisplayMode = `prices`
+)
+
+// The modes a user can choose, in the order they are offered.
+var DisplayModes = []DisplayMode{AllMagnitudes, Quantities, Values, Prices}
+
+// Converts a mode named in a URL into a DisplayMode.
+// AllMagnitudes is named `all`, though an empty name is also accepted.
+// ok is false if there is no such mode.
+func ParseDisplayMode(mode string) (m DisplayMode, ok bool) {
+	if mode == `all` {
+		return AllMagnitudes, true
+	}
+	for _, m := range DisplayModes {
+		if string(m) == mode {
+			return m, true
+		}
+	}
+	return AllMagnitudes, false
+}
+
+// The name of the mode in a URL, which ParseDisplayMode understands.
+func (m DisplayMode) Param() string {
+	if m == AllMagnitudes {
+		return `all`
+	}
+	return string(m)
+}
+
+// Chooses between the size, value and price of something according to
+// the mode. fallback is chosen when the mode shows all magnitudes.
+func Select[T any](m DisplayMode, size T, value T, price T, fallback T) T {
+	switch m {
+	case Quantities:
+		return size
+	case Values:
+		return value
+	case Prices:
+		return price
+	}
+	return fallback
+}
+
+// The name of the mode, as shown to the user.
+func (m DisplayMode) Label() string {
+	switch m {
+	case Quantities:
+		return `Quantities`
+	case Values:
+		return `Values`
+	case Prices:
+		return `Prices`
+	}
+	return `All`
+}
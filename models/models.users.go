@@ -5,65 +5,68 @@ package models
 
 import (
 	"capfront/api"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
 )
 
 // Full details of a user.
 type User struct {
-	UserName            string         `json:"username"`              // Repeats the key in the map,for ease of use
-	ApiKey              string         `json:"api_key"`               // The api key allocated to this user
-	CurrentSimulationID int            `json:"current_simulation_id"` // the id of the simulation that this user is currently using
-	LastVisitedPage     string         // Remember what the user was looking at (used when an action is requested)
-	Datasets            []*Dataset     // Repository for the data objects generated during the simulation
-	TimeStamp           int            // Indexes Datasets. Selects the stage that the simulation has reached
-	ViewedTimeStamp     int            // Indexes Datasets. Selects what the user is viewing
-	ComparatorTimeStamp int            // Indexes Datasets. Selects what Viewed items are compared with.
-	Sim                 api.DataObject // Details of the current simulation
-	IsLocked            bool           `json:"is_locked"` // Is user currently authorized to talk to the server?
-}
-
-var Users = make(map[string]*User) // Every user's simulation data
-var AdminUserList []User           // Basic user data, for use by the administrator
-type Dataset map[string]api.DataObject
+	UserName            string                `json:"username"`              // Repeats the key in the map,for ease of use
+	ApiKey              string                `json:"api_key"`               // The api key allocated to this user
+	CurrentSimulationID int                   `json:"current_simulation_id"` // the id of the simulation that this user is currently using
+	LastVisitedPage     string                // Remember what the user was looking at, with its query (used when an action is requested)
+	Datasets            []*Dataset            // Repository for the data objects generated during the simulation
+	Indexes             []*Index              `json:"-"` // Indexes[i] provides fast lookups into Datasets[i]
+	TimeStamp           int                   // Indexes Datasets. Selects the stage that the simulation has reached
+	ViewedTimeStamp     int                   // Indexes Datasets. Selects what the user is viewing
+	ComparatorTimeStamp int                   // Indexes Datasets. Selects what Viewed items are compared with.
+	DisplayMode         DisplayMode           `json:"display_mode"` // The magnitudes shown when a page does not say which
+	Sim                 api.Table[Simulation] // Details of the current simulation
+	IsLocked            bool                  `json:"is_locked"` // Is user currently authorized to talk to the server?
+	Notice              string                `json:"-"`         // Shown once, on the next page the user looks at; see TakeNotice
+	actionTokens        []string              // Issued with recent pages, not yet used; see IssueActionToken
+}
+
+// A Dataset holds all the objects of a simulation at one stage.
+// Each table has its own field, of its own type, so every accessor is
+// checked by the compiler and none of them can find a list of the wrong type.
+type Dataset struct {
+	Simulations    api.Table[Simulation]     `json:"simulations"`
+	Commodities    api.Table[Commodity]      `json:"commodities"`
+	Industries     api.Table[Industry]       `json:"industries"`
+	Classes        api.Table[Class]          `json:"classes"`
+	IndustryStocks api.Table[Industry_Stock] `json:"industry stocks"`
+	ClassStocks    api.Table[Class_Stock]    `json:"class stocks"`
+	Traces         api.Table[Trace]          `json:"trace"`
+}
 
 // Constructor for a dataset object.
 // Contains and defines all the standard objects of a simulation.
-func NewDataset(apiKey string) Dataset {
-	return map[string]api.DataObject{
-		"simulations": {
-			ApiUrl:   `simulations/current`,
-			ApiKey:   apiKey,
-			DataList: new([]Simulation),
-		},
-		"commodities": {
-			ApiUrl:   `commodity`,
-			ApiKey:   apiKey,
-			DataList: new([]Commodity),
-		},
-		"industries": {
-			ApiUrl:   `industry`,
-			ApiKey:   apiKey,
-			DataList: new([]Industry),
-		},
-		"classes": {
-			ApiUrl:   `classes`,
-			ApiKey:   apiKey,
-			DataList: new([]Class),
-		},
-		"industry stocks": {
-			ApiUrl:   `stocks/industry`,
-			ApiKey:   apiKey,
-			DataList: new([]Industry_Stock),
-		},
-		"class stocks": {
-			ApiUrl:   `stocks/class`,
-			ApiKey:   apiKey,
-			DataList: new([]Class_Stock),
-		},
-		"trace": {
-			ApiUrl:   `trace`,
-			ApiKey:   apiKey,
-			DataList: new([]Trace),
-		},
+func NewDataset(apiKey string) *Dataset {
+	return &Dataset{
+		Simulations:    api.NewTable[Simulation](`simulations/current`, apiKey),
+		Commodities:    api.NewTable[Commodity](`commodity`, apiKey),
+		Industries:     api.NewTable[Industry](`industry`, apiKey),
+		Classes:        api.NewTable[Class](`classes`, apiKey),
+		IndustryStocks: api.NewTable[Industry_Stock](`stocks/industry`, apiKey),
+		ClassStocks:    api.NewTable[Class_Stock](`stocks/class`, apiKey),
+		Traces:         api.NewTable[Trace](`trace`, apiKey),
+	}
+}
+
+// The tables of the dataset, keyed by the names used in refresh reports,
+// so that they can all be fetched together.
+func (d *Dataset) Tables() map[string]api.Fetcher {
+	return map[string]api.Fetcher{
+		"simulations":     &d.Simulations,
+		"commodities":     &d.Commodities,
+		"industries":      &d.Industries,
+		"classes":         &d.Classes,
+		"industry stocks": &d.IndustryStocks,
+		"class stocks":    &d.ClassStocks,
+		"trace":           &d.Traces,
 	}
 }
 
@@ -77,49 +80,203 @@ func NewUser(username string, current_simulation_id int, apiKey string) User {
 		TimeStamp:           0,
 		ViewedTimeStamp:     0,
 		ComparatorTimeStamp: 0,
+		DisplayMode:         AllMagnitudes,
 		Datasets:            []*Dataset{},
-		Sim: api.DataObject{
-			ApiUrl:   `simulations/current`,
-			ApiKey:   apiKey,
-			DataList: new([]Simulation),
-		},
+		Sim:                 NewSimulationsTable(apiKey),
 	}
 	new_dataset := NewDataset(new_user.ApiKey)
-	new_user.Datasets = append(new_user.Datasets, &new_dataset)
+	new_user.Datasets = append(new_user.Datasets, new_dataset)
+	new_user.Indexes = append(new_user.Indexes, NewIndex(new_dataset))
 	return new_user
 }
 
+// Constructor for the table holding the list of the user's simulations.
+func NewSimulationsTable(apiKey string) api.Table[Simulation] {
+	return api.NewTable[Simulation](`simulations/current`, apiKey)
+}
+
+// Appends a fully populated dataset to the user's history and moves
+// the time stamps to it, so that the user sees the new stage compared
+// with the one before it.
+//
+//	d is the new dataset. It must be complete: nothing checks it here.
+//	sim is the list of the user's simulations, fetched along with d.
+//
+// Nothing in the user's history changes until this is called, so a
+// caller that fails to populate d can simply discard it.
+func (u *User) CommitDataset(d *Dataset, sim api.Table[Simulation]) {
+	u.Datasets = append(u.Datasets, d)
+	u.Indexes = append(u.Indexes, NewIndex(d))
+	u.Sim = sim
+	u.ComparatorTimeStamp = u.TimeStamp
+	u.TimeStamp = len(u.Datasets) - 1
+	u.ViewedTimeStamp = u.TimeStamp
+}
+
+// Discards the user's history and starts a new one from d.
+// Used when the user starts a new simulation.
+func (u *User) StartHistory(d *Dataset, sim api.Table[Simulation]) {
+	u.Datasets = []*Dataset{d}
+	u.Indexes = []*Index{NewIndex(d)}
+	u.Sim = sim
+	u.TimeStamp = 0
+	u.ViewedTimeStamp = 0
+	u.ComparatorTimeStamp = 0
+}
+
+// One stage in the user's history, as offered for comparison.
+type HistoryEntry struct {
+	TimeStamp int    // Indexes Datasets
+	Period    int    // The period the stage belongs to, counting from 1
+	State     string // The stage the simulation was waiting to carry out
+	Label     string // Describes the stage to the user
+}
+
+// Lists the stages in the user's history, earliest first.
+//
+// The Dataset at time stamp 0 is the simulation as it was when the user
+// started it. Each later Dataset records the state after one action,
+// so a simulation waiting for DEMAND has completed a period.
+func (u User) History() []HistoryEntry {
+	history := make([]HistoryEntry, len(u.Datasets))
+	period := 1
+	for ts := range u.Datasets {
+		state := u.stateAt(ts)
+		if ts > 0 && ParseStage(state) == Demand {
+			period++
+		}
+		label := fmt.Sprintf("Stage %d: period %d, waiting to %s", ts, period, ParseStage(state).Action())
+		if ts == 0 {
+			label = fmt.Sprintf("Stage 0: start, waiting to %s", ParseStage(state).Action())
+		}
+		history[ts] = HistoryEntry{TimeStamp: ts, Period: period, State: state, Label: label}
+	}
+	return history
+}
+
+// The state of the user's current simulation as recorded in the Dataset at timeStamp.
+func (u User) stateAt(timeStamp int) string {
+	for _, s := range u.Dataset(timeStamp).Simulations.List {
+		if s.Id == u.CurrentSimulationID {
+			return s.State
+		}
+	}
+	return string(Unknown)
+}
+
+// Checks that timeStamp selects a stage in the user's history.
+func (u User) HasStage(timeStamp int) bool {
+	return timeStamp >= 0 && timeStamp < len(u.Datasets)
+}
+
+// Generates an unguessable token.
+func newToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err) // crypto/rand does not fail on any supported platform
+	}
+	return hex.EncodeToString(b)
+}
+
+// The most action tokens that a user may hold at once. Each page the
+// user looks at is issued one, so a form can still be sent from any of
+// the pages the user has looked at most recently.
+const maxActionTokens = 32
+
+// Issues a token for the forms on a page, which must accompany the
+// state-changing request that one of them sends.
+//
+// Every page has a token of its own, so that a form sent from one tab
+// does not use up the token of a form in another. Once maxActionTokens
+// newer ones have been issued, the token expires.
+func (u *User) IssueActionToken() string {
+	token := newToken()
+	if len(u.actionTokens) == maxActionTokens {
+		copy(u.actionTokens, u.actionTokens[1:])
+		u.actionTokens = u.actionTokens[:maxActionTokens-1]
+	}
+	u.actionTokens = append(u.actionTokens, token)
+	return token
+}
+
+// Checks the token submitted with a state-changing request.
+//
+// If the token was issued by IssueActionToken and has not been used,
+// it is used up and the request may go ahead. If not, the request is a
+// duplicate (a double click, or a form sent again from a page whose
+// token has been used) or comes from a page so old that its token has
+// expired, and it should be ignored.
+func (u *User) ConsumeActionToken(token string) bool {
+	for i, t := range u.actionTokens {
+		if token != "" && t == token {
+			u.actionTokens = append(u.actionTokens[:i], u.actionTokens[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// The notice for the user, if any, which is cleared so that it is shown only once.
+func (u *User) TakeNotice() string {
+	notice := u.Notice
+	u.Notice = ""
+	return notice
+}
+
 // Wrappers for the object lists.
 // The Simulations wrapper is a special case, because the dashboard
 // displays a list of user simulations which may be empty.
 // If the user has no simulationsm, we make up a fake list with nothing
 // in it, to ensure the app can display the dashboard.
 func (u User) Simulations() *[]Simulation {
-	list := u.Sim.DataList.(*[]Simulation)
-	if len(*list) == 0 {
-		var fakeList []Simulation = *new([]Simulation)
-		return &fakeList
+	list := u.Sim.List
+	if list == nil {
+		list = []Simulation{}
 	}
-	return list
+	return &list
 }
 
+// The Dataset at the given timeStamp.
+// If there is none (an error, but we need to diagnose it) return an empty
+// Dataset, so that a page with a stale time stamp shows nothing instead of panicking.
+func (u User) Dataset(timeStamp int) *Dataset {
+	if timeStamp < 0 || timeStamp >= len(u.Datasets) {
+		log.Output(2, fmt.Sprintf("User %s has no dataset at time stamp %d", u.UserName, timeStamp))
+		return &emptyDataset
+	}
+	return u.Datasets[timeStamp]
+}
+
+var emptyDataset Dataset
+var emptyIndex = NewIndex(&emptyDataset)
+
 func (u User) Commodities() *[]Commodity {
-	return (*u.Datasets[u.ViewedTimeStamp])["commodities"].DataList.(*[]Commodity)
+	return &u.Dataset(u.ViewedTimeStamp).Commodities.List
 }
 
 func (u User) CommodityViews() *[]CommodityView {
-	v := (*u.Datasets[u.ViewedTimeStamp])["commodities"].DataList.(*[]Commodity)
-	c := (*u.Datasets[u.ComparatorTimeStamp])["commodities"].DataList.(*[]Commodity)
+	return u.CommodityViewsBetween(u.ViewedTimeStamp, u.ComparatorTimeStamp)
+}
+
+// The commodities at stage viewed, compared with those at stage compared.
+func (u User) CommodityViewsBetween(viewed int, compared int) *[]CommodityView {
+	v := &u.Dataset(viewed).Commodities.List
+	c := &u.Dataset(compared).Commodities.List
 	return NewCommodityViews(v, c)
 }
 
 func (u User) Industries() *[]Industry {
-	return (*u.Datasets[u.ViewedTimeStamp])["industries"].DataList.(*[]Industry)
+	return &u.Dataset(u.ViewedTimeStamp).Industries.List
 }
 
 func (u User) IndustryViews() *[]IndustryView {
-	v := (*u.Datasets[u.ViewedTimeStamp])["industries"].DataList.(*[]Industry)
-	c := (*u.Datasets[u.ComparatorTimeStamp])["industries"].DataList.(*[]Industry)
+	return u.IndustryViewsBetween(u.ViewedTimeStamp, u.ComparatorTimeStamp)
+}
+
+// The industries at stage viewed, compared with those at stage compared.
+func (u User) IndustryViewsBetween(viewed int, compared int) *[]IndustryView {
+	v := &u.Dataset(viewed).Industries.List
+	c := &u.Dataset(compared).Industries.List
 
 	// fmt.Printf("Constructing Industry Views with ViewedTimeStamp %d and ComparatorTimeStamp %d\n", u.ViewedTimeStamp, u.ComparatorTimeStamp)
 	// vAsString, _ := json.MarshalIndent(v, " ", " ")
@@ -131,34 +288,48 @@ func (u User) IndustryViews() *[]IndustryView {
 	// fmt.Println("Compared Industry:")
 	// utils.Trace(utils.BrightGreen, string(cAsString))
 
-	// fmt.Printf("Constant Capital of Viewed Industry:\n%v\n", (*v)[0].ConstantCapital(u.ViewedTimeStamp))
-	// fmt.Printf("Constant Capital of Compared Industry:\n%v\n", (*c)[0].ConstantCapital(u.ComparatorTimeStamp))
+	// fmt.Printf("Constant Capital of Viewed Industry:\n%v\n", (*v)[0].ConstantCapital(u.Index(u.ViewedTimeStamp)))
+	// fmt.Printf("Constant Capital of Compared Industry:\n%v\n", (*c)[0].ConstantCapital(u.Index(u.ComparatorTimeStamp)))
 
-	return NewIndustryViews(u.ViewedTimeStamp, u.ComparatorTimeStamp, v, c)
+	return NewIndustryViews(u.Index(viewed), u.Index(compared), v, c)
 }
 
 func (u User) ClassViews() *[]ClassView {
-	v := (*u.Datasets[u.ViewedTimeStamp])["classes"].DataList.(*[]Class)
-	c := (*u.Datasets[u.ComparatorTimeStamp])["classes"].DataList.(*[]Class)
+	return u.ClassViewsBetween(u.ViewedTimeStamp, u.ComparatorTimeStamp)
+}
+
+// The classes at stage viewed, compared with those at stage compared.
+func (u User) ClassViewsBetween(viewed int, compared int) *[]ClassView {
+	v := &u.Dataset(viewed).Classes.List
+	c := &u.Dataset(compared).Classes.List
 
-	return NewClassViews(u.ViewedTimeStamp, u.ComparatorTimeStamp, v, c)
+	return NewClassViews(u.Index(viewed), u.Index(compared), v, c)
 }
 
 func (u User) Classes() *[]Class {
-	return (*u.Datasets[u.ViewedTimeStamp])["classes"].DataList.(*[]Class)
+	return &u.Dataset(u.ViewedTimeStamp).Classes.List
+}
+
+// The Index of the Dataset at the given timeStamp.
+// As with Dataset, an empty one if there is none.
+func (u User) Index(timeStamp int) *Index {
+	if timeStamp < 0 || timeStamp >= len(u.Indexes) {
+		return emptyIndex
+	}
+	return u.Indexes[timeStamp]
 }
 
 // Wrapper for the IndustryStockList
 func (u User) IndustryStocks(timeStamp int) *[]Industry_Stock {
-	return (*u.Datasets[timeStamp])["industry stocks"].DataList.(*[]Industry_Stock)
+	return &u.Dataset(timeStamp).IndustryStocks.List
 }
 
 // Wrapper for the ClassStockList
 func (u User) ClassStocks(timeStamp int) *[]Class_Stock {
-	return (*u.Datasets[timeStamp])["class stocks"].DataList.(*[]Class_Stock)
+	return &u.Dataset(timeStamp).ClassStocks.List
 }
 
 // Wrapper for the TraceList
 func (u User) Traces(timeStamp int) *[]Trace {
-	return (*u.Datasets[timeStamp])["trace"].DataList.(*[]Trace)
+	return &u.Dataset(timeStamp).Traces.List
 }
@@ -0,0 +1,502 @@
+package models
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// A stage with one industry and one class, each with money and sales stocks.
+func stageWith(industryId int, classId int) (*Dataset, *Index) {
+	d := NewDataset(``)
+	d.Industries.List = []Industry{{Id: industryId, Name: `Department I`}}
+	d.Classes.List = []Class{{Id: classId, Name: `Workers`}}
+	d.IndustryStocks.List = []Industry_Stock{
+		{Id: 1, Industry_id: industryId, Commodity_id: 4, Usage_type: `Money`, Size: 100, Value: 100, Price: 100},
+		{Id: 2, Industry_id: industryId, Commodity_id: 1, Usage_type: `Sales`, Size: 50, Value: 60, Price: 70},
+	}
+	d.ClassStocks.List = []Class_Stock{
+		{Id: 1, Class_id: classId, Commodity_id: 4, Usage_type: `Money`, Size: 100, Value: 100, Price: 100},
+		{Id: 2, Class_id: classId, Commodity_id: 3, Usage_type: `Sales`, Size: 20, Value: 20, Price: 20},
+	}
+	return d, NewIndex(d)
+}
+
+// An industry or class that exists at only one of the two stages is
+// compared with nothing, not with NotFoundIndustryStock or NotFoundClassStock.
+func TestViewsOfAddedAndRemovedObjects(t *testing.T) {
+	before, beforeIx := stageWith(1, 1)
+	after, afterIx := stageWith(2, 2)
+	tests := []struct {
+		name     string
+		v, c     *Dataset
+		vx, cx   *Index
+		presence Presence
+		viewed   float32 // the money stock at the viewed stage
+		compared float32 // and at the compared stage
+	}{
+		{"added", after, before, afterIx, beforeIx, Added, 100, 0},
+		{"removed", before, after, beforeIx, afterIx, Removed, 0, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			industries := *NewIndustryViews(tt.vx, tt.cx, &tt.v.Industries.List, &tt.c.Industries.List)
+			for _, v := range industries {
+				if v.Presence != tt.presence {
+					continue
+				}
+				if v.MoneyStockSize.Viewed != tt.viewed || v.MoneyStockSize.Compared != tt.compared {
+					t.Errorf("industry %d money stock is %v, want %v then %v", v.Id, v.MoneyStockSize, tt.compared, tt.viewed)
+				}
+				for _, p := range []Pair{v.SalesStockSize, v.SalesStockValue, v.SalesStockPrice} {
+					if p.Viewed < 0 || p.Compared < 0 {
+						t.Errorf("industry %d sales stock is %v", v.Id, p)
+					}
+				}
+			}
+
+			classes := *NewClassViews(tt.vx, tt.cx, &tt.v.Classes.List, &tt.c.Classes.List)
+			for _, v := range classes {
+				if v.Presence != tt.presence {
+					continue
+				}
+				if v.MoneyStockSize.Viewed != tt.viewed || v.MoneyStockSize.Compared != tt.compared {
+					t.Errorf("class %d money stock is %v, want %v then %v", v.Id, v.MoneyStockSize, tt.compared, tt.viewed)
+				}
+				for _, p := range []Pair{v.SalesStockSize, v.SalesStockValue, v.SalesStockPrice} {
+					if p.Viewed < 0 || p.Compared < 0 {
+						t.Errorf("class %d sales stock is %v", v.Id, p)
+					}
+				}
+			}
+		})
+	}
+}
+
+// A user whose history passes through the given states, one stage each.
+func userWithStates(states ...Stage) User {
+	u := User{UserName: `tester`, CurrentSimulationID: 1}
+	for _, s := range states {
+		d := NewDataset(``)
+		d.Simulations.List = []Simulation{{Id: 1, State: string(s)}}
+		u.Datasets = append(u.Datasets, d)
+	}
+	return u
+}
+
+func TestPeriods(t *testing.T) {
+	tests := []struct {
+		name   string
+		states []Stage
+		want   []Period
+	}{
+		{"from the start", []Stage{Demand, Supply, Trade, Produce, Consume, Invest, Demand, Supply},
+			[]Period{{Number: 1, Opening: 0, Closing: 6, Complete: true}, {Number: 2, Opening: 6, Closing: 7}}},
+		{"in progress", []Stage{Demand, Supply, Trade},
+			[]Period{{Number: 1, Opening: 0, Closing: 2}}},
+		{"picked up part way", []Stage{Trade, Produce, Consume, Invest, Demand, Supply, Trade, Produce, Consume, Invest, Demand},
+			[]Period{{Number: 1, Opening: 0, Closing: 4, Partial: true}, {Number: 2, Opening: 4, Closing: 10, Complete: true}, {Number: 3, Opening: 10, Closing: 10}}},
+		{"picked up part way and still in it", []Stage{Consume, Invest},
+			[]Period{{Number: 1, Opening: 0, Closing: 1, Partial: true}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := userWithStates(tt.states...).Periods()
+			if len(got) != len(tt.want) {
+				t.Fatalf("Periods() = %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("period %d is %+v, want %+v", i+1, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestActionTokens(t *testing.T) {
+	u := NewUser(`tester`, 0, `testerkey`)
+	first, second := u.IssueActionToken(), u.IssueActionToken()
+
+	// Two pages, in two tabs, each with a token of its own.
+	if !u.ConsumeActionToken(second) || !u.ConsumeActionToken(first) {
+		t.Errorf("the tokens of two pages cannot both be used")
+	}
+	for _, token := range []string{first, second, ``, `forged`} {
+		if u.ConsumeActionToken(token) {
+			t.Errorf("token %q was accepted twice, or was never issued", token)
+		}
+	}
+
+	// A page so old that maxActionTokens newer ones have been issued since.
+	old := u.IssueActionToken()
+	for i := 0; i < maxActionTokens; i++ {
+		u.IssueActionToken()
+	}
+	if u.ConsumeActionToken(old) {
+		t.Errorf("an expired token was accepted")
+	}
+	if len(u.actionTokens) != maxActionTokens {
+		t.Errorf("the user holds %d tokens, want at most %d", len(u.actionTokens), maxActionTokens)
+	}
+}
+
+func TestJoinById(t *testing.T) {
+	type want struct {
+		id       int
+		presence Presence
+	}
+	tests := []struct {
+		name     string
+		viewed   []int
+		compared []int
+		want     []want
+	}{
+		{"same objects in another order", []int{1, 2, 3}, []int{3, 1, 2},
+			[]want{{1, Matched}, {2, Matched}, {3, Matched}}},
+		{"added", []int{2, 5, 1}, []int{1, 2},
+			[]want{{2, Matched}, {5, Added}, {1, Matched}}},
+		{"removed", []int{1}, []int{4, 1, 3},
+			[]want{{1, Matched}, {4, Removed}, {3, Removed}}},
+		{"added and removed", []int{3, 1, 2}, []int{2, 4, 1},
+			[]want{{3, Added}, {1, Matched}, {2, Matched}, {4, Removed}}},
+		{"nothing to compare with", []int{1, 2}, nil,
+			[]want{{1, Added}, {2, Added}}},
+	}
+	commodities := func(ids []int) []Commodity {
+		list := make([]Commodity, len(ids))
+		for i, id := range ids {
+			list[i] = Commodity{Id: id, Name: string(rune('A' + id))}
+		}
+		return list
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, c := commodities(tt.viewed), commodities(tt.compared)
+			got := joinById(v, c, func(x *Commodity) int { return x.Id })
+			if len(got) != len(tt.want) {
+				t.Fatalf("joinById returned %d objects, want %d", len(got), len(tt.want))
+			}
+			for i, w := range tt.want {
+				j := got[i]
+				if j.presence != w.presence {
+					t.Errorf("object %d is %q, want %q", w.id, j.presence, w.presence)
+				}
+				if (j.viewed == nil) != (w.presence == Removed) || (j.compared == nil) != (w.presence == Added) {
+					t.Errorf("object %d, which is %q, has viewed %v and compared %v", w.id, w.presence, j.viewed, j.compared)
+				}
+				for _, x := range []*Commodity{j.viewed, j.compared} {
+					if x != nil && x.Id != w.id {
+						t.Errorf("object %d is matched with object %d", w.id, x.Id)
+					}
+				}
+			}
+
+			// The views carry the same marks.
+			views := *NewCommodityViews(&v, &c)
+			for i, w := range tt.want {
+				if views[i].Id != w.id || views[i].Presence != w.presence {
+					t.Errorf("view %d is of commodity %d marked %q, want %d marked %q", i, views[i].Id, views[i].Presence, w.id, w.presence)
+				}
+			}
+		})
+	}
+}
+
+// An industry using two means of production, one of them in two stocks,
+// and a class consuming two goods. The commodities' names are misleading,
+// because holdings are grouped by commodity, not by name.
+func holdingsStage() (*Dataset, *Index) {
+	d := NewDataset(``)
+	d.Commodities.List = []Commodity{
+		{Id: 1, Name: `Labour Power`, Origin: `INDUSTRIAL`, Usage: `PRODUCTIVE`},
+		{Id: 2, Name: `Consumption`, Origin: `INDUSTRIAL`, Usage: `CONSUMPTION`},
+		{Id: 3, Name: `Means of Production`, Origin: `SOCIAL`, Usage: `PRODUCTIVE`},
+		{Id: 5, Name: `Machines`, Origin: `INDUSTRIAL`, Usage: `PRODUCTIVE`},
+		{Id: 6, Name: `Luxuries`, Origin: `INDUSTRIAL`, Usage: `CONSUMPTION`},
+	}
+	d.Industries.List = []Industry{{Id: 1, Name: `Department I`}}
+	d.Classes.List = []Class{{Id: 1, Name: `Workers`}}
+	d.IndustryStocks.List = []Industry_Stock{
+		{Id: 1, Industry_id: 1, Commodity_id: 1, Usage_type: `Production`, Size: 10, Value: 20, Price: 30},
+		{Id: 2, Industry_id: 1, Commodity_id: 5, Usage_type: `Production`, Size: 1, Value: 2, Price: 3},
+		{Id: 3, Industry_id: 1, Commodity_id: 1, Usage_type: `Production`, Size: 5, Value: 10, Price: 15},
+		{Id: 4, Industry_id: 1, Commodity_id: 3, Usage_type: `Production`, Size: 7, Value: 7, Price: 7},
+		{Id: 5, Industry_id: 1, Commodity_id: 1, Usage_type: `Sales`, Size: 100, Value: 100, Price: 100},
+	}
+	d.ClassStocks.List = []Class_Stock{
+		{Id: 1, Class_id: 1, Commodity_id: 2, Usage_type: `Consumption`, Size: 4, Value: 4, Price: 4},
+		{Id: 2, Class_id: 1, Commodity_id: 6, Usage_type: `Consumption`, Size: 1, Value: 9, Price: 9},
+		{Id: 3, Class_id: 1, Commodity_id: 2, Usage_type: `Consumption`, Size: 6, Value: 6, Price: 6},
+		{Id: 4, Class_id: 1, Commodity_id: 3, Usage_type: `Sales`, Size: 20, Value: 20, Price: 20},
+	}
+	return d, NewIndex(d)
+}
+
+func TestHoldings(t *testing.T) {
+	d, ix := holdingsStage()
+	industry, class := d.Industries.List[0], d.Classes.List[0]
+	tests := []struct {
+		name  string
+		got   Holdings
+		want  Holdings
+		total Holding
+	}{
+		{"means of production", industry.ConstantCapitals(ix),
+			Holdings{{Commodity_id: 1, Size: 15, Value: 30, Price: 45}, {Commodity_id: 5, Size: 1, Value: 2, Price: 3}},
+			Holding{Size: 16, Value: 32, Price: 48}},
+		{"labour power", industry.VariableCapitals(ix),
+			Holdings{{Commodity_id: 3, Size: 7, Value: 7, Price: 7}},
+			Holding{Commodity_id: 3, Size: 7, Value: 7, Price: 7}},
+		{"consumer goods", class.ConsumerGoods(ix),
+			Holdings{{Commodity_id: 2, Size: 10, Value: 10, Price: 10}, {Commodity_id: 6, Size: 1, Value: 9, Price: 9}},
+			Holding{Size: 11, Value: 19, Price: 19}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if len(tt.got) != len(tt.want) {
+				t.Fatalf("got %+v, want %+v", tt.got, tt.want)
+			}
+			for i := range tt.want {
+				if tt.got[i] != tt.want[i] {
+					t.Errorf("holding %d is %+v, want %+v", i, tt.got[i], tt.want[i])
+				}
+				if tt.got.Of(tt.want[i].Commodity_id) != tt.want[i] {
+					t.Errorf("the holding of commodity %d is %+v, want %+v", tt.want[i].Commodity_id, tt.got.Of(tt.want[i].Commodity_id), tt.want[i])
+				}
+			}
+			if tt.got.Total() != tt.total {
+				t.Errorf("the total is %+v, want %+v", tt.got.Total(), tt.total)
+			}
+			if none := tt.got.Of(99); none != (Holding{Commodity_id: 99}) {
+				t.Errorf("the holding of a commodity that is not held is %+v", none)
+			}
+		})
+	}
+
+	// Compared with a stage at which the industry had no machines, and
+	// used a commodity it no longer uses.
+	earlier := Holdings{{Commodity_id: 1, Size: 12, Value: 24, Price: 36}, {Commodity_id: 2, Size: 3, Value: 3, Price: 3}}
+	views := NewHoldingViews(ix, ix, industry.ConstantCapitals(ix), earlier)
+	wantViews := []struct {
+		id       int
+		name     string
+		presence Presence
+		size     Pair
+	}{
+		{1, `Labour Power`, Matched, Pair{Viewed: 15, Compared: 12}},
+		{5, `Machines`, Added, Pair{Viewed: 1, Compared: 0}},
+		{2, `Consumption`, Removed, Pair{Viewed: 0, Compared: 3}},
+	}
+	if len(views) != len(wantViews) {
+		t.Fatalf("NewHoldingViews returned %+v", views)
+	}
+	for i, w := range wantViews {
+		v := views[i]
+		if v.Commodity_id != w.id || v.Name != w.name || v.Presence != w.presence || v.Size != w.size {
+			t.Errorf("view %d is %+v, want commodity %d (%s) %q of size %v", i, v, w.id, w.name, w.presence, w.size)
+		}
+	}
+	if total := TotalHoldingView(views); total.Size != (Pair{Viewed: 16, Compared: 15}) {
+		t.Errorf("the total size is %v, want 16 compared with 15", total.Size)
+	}
+}
+
+// The Index finds each object by id, and each owner's stocks by usage,
+// in the order the server sent them, pointing into the Dataset itself.
+func TestIndex(t *testing.T) {
+	d, ix := holdingsStage()
+	if c := ix.Commodity(5); c != &d.Commodities.List[3] {
+		t.Errorf("Commodity(5) is %+v, want the Machines in the dataset", c)
+	}
+	if ind := ix.Industry(1); ind != &d.Industries.List[0] {
+		t.Errorf("Industry(1) is %+v, want Department I in the dataset", ind)
+	}
+	if c := ix.Class(1); c != &d.Classes.List[0] {
+		t.Errorf("Class(1) is %+v, want the Workers in the dataset", c)
+	}
+	if ix.Commodity(4) != nil || ix.Industry(2) != nil || ix.Class(2) != nil {
+		t.Errorf("objects that are not in the dataset were found")
+	}
+
+	industryStocks := []struct {
+		usage string
+		want  []int // the ids of the stocks, in order
+	}{
+		{`Production`, []int{1, 2, 3, 4}},
+		{`Sales`, []int{5}},
+		{`Money`, nil},
+	}
+	for _, tt := range industryStocks {
+		got := ix.IndustryStocks(1, tt.usage)
+		if len(got) != len(tt.want) {
+			t.Errorf("the %s stocks of industry 1 are %d, want %d", tt.usage, len(got), len(tt.want))
+			continue
+		}
+		for i, s := range got {
+			if s.Id != tt.want[i] || s != &d.IndustryStocks.List[s.Id-1] {
+				t.Errorf("%s stock %d is %+v, want stock %d of the dataset", tt.usage, i, *s, tt.want[i])
+			}
+		}
+	}
+	if got := ix.ClassStocks(1, `Consumption`); len(got) != 3 || got[0].Id != 1 || got[2].Id != 3 {
+		t.Errorf("the Consumption stocks of class 1 are %d, want stocks 1, 2 and 3", len(got))
+	}
+	if len(ix.IndustryStocks(2, `Production`)) != 0 {
+		t.Errorf("an industry that is not in the dataset has stocks")
+	}
+
+	if s := ix.IndustryStock(1, `Sales`); s.Id != 5 {
+		t.Errorf("IndustryStock(1, Sales) is %+v, want stock 5", s)
+	}
+	if s := ix.ClassStock(1, `Sales`); s.Id != 4 {
+		t.Errorf("ClassStock(1, Sales) is %+v, want stock 4", s)
+	}
+	if s := ix.IndustryStock(1, `Money`); s != NotFoundIndustryStock {
+		t.Errorf("IndustryStock(1, Money) is %+v, want NotFoundIndustryStock", s)
+	}
+	if s := ix.ClassStock(2, `Sales`); s != NotFoundClassStock {
+		t.Errorf("ClassStock(2, Sales) is %+v, want NotFoundClassStock", s)
+	}
+}
+
+// Every table of a dataset is fetched, from its own endpoint, into the
+// dataset itself.
+func TestDatasetTables(t *testing.T) {
+	d := NewDataset(`key`)
+	want := map[string]any{
+		"simulations":     &d.Simulations,
+		"commodities":     &d.Commodities,
+		"industries":      &d.Industries,
+		"classes":         &d.Classes,
+		"industry stocks": &d.IndustryStocks,
+		"class stocks":    &d.ClassStocks,
+		"trace":           &d.Traces,
+	}
+	tables := d.Tables()
+	if len(tables) != len(want) {
+		t.Errorf("the dataset has %d tables, want %d", len(tables), len(want))
+	}
+	for key, table := range want {
+		if tables[key] != table {
+			t.Errorf("the %s table is not the dataset's own", key)
+		}
+	}
+
+	for _, endpoint := range []struct{ url, key, want string }{
+		{d.Simulations.ApiUrl, d.Simulations.ApiKey, `simulations/current`},
+		{d.Commodities.ApiUrl, d.Commodities.ApiKey, `commodity`},
+		{d.Industries.ApiUrl, d.Industries.ApiKey, `industry`},
+		{d.Classes.ApiUrl, d.Classes.ApiKey, `classes`},
+		{d.IndustryStocks.ApiUrl, d.IndustryStocks.ApiKey, `stocks/industry`},
+		{d.ClassStocks.ApiUrl, d.ClassStocks.ApiKey, `stocks/class`},
+		{d.Traces.ApiUrl, d.Traces.ApiKey, `trace`},
+	} {
+		if endpoint.url != endpoint.want || endpoint.key != `key` {
+			t.Errorf("a table is fetched from %s with key %q, want %s with the user's key", endpoint.url, endpoint.key, endpoint.want)
+		}
+	}
+}
+
+func TestPairChange(t *testing.T) {
+	nan := float32(math.NaN())
+	tests := []struct {
+		name    string
+		p       Pair
+		delta   float32
+		percent float32
+		ok      bool
+		shown   string // in the cell that DisplayChange writes
+	}{
+		{"up", Pair{Viewed: 150, Compared: 100}, 50, 50, true, `+50.00 (+50.0%)`},
+		{"down", Pair{Viewed: 75, Compared: 100}, -25, -25, true, `color:red">-25.00 (-25.0%)`},
+		{"from zero", Pair{Viewed: 10, Compared: 0}, 10, 0, false, `+10.00 (from zero)`},
+		{"unchanged", Pair{Viewed: 10, Compared: 10}, 0, 0, true, `no change`},
+		{"from undefined", Pair{Viewed: 10, Compared: nan}, 0, 0, false, `from undefined`},
+		{"undefined", Pair{Viewed: nan, Compared: 10}, 0, 0, false, `&ndash;`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.p.Viewed == tt.p.Viewed && tt.p.Compared == tt.p.Compared {
+				if got := tt.p.Delta(); got != tt.delta {
+					t.Errorf("Delta() = %g, want %g", got, tt.delta)
+				}
+				if got, ok := tt.p.PercentChange(); got != tt.percent || ok != tt.ok {
+					t.Errorf("PercentChange() = %g, %v, want %g, %v", got, ok, tt.percent, tt.ok)
+				}
+			}
+			if got := string(tt.p.DisplayChange()); !strings.Contains(got, tt.shown) {
+				t.Errorf("DisplayChange() = %s, want it to show %s", got, tt.shown)
+			}
+		})
+	}
+}
+
+// A period and two stages more, in which industry 1 grows by one at
+// each stage and industry 2 appears at stage 2.
+func TestSeries(t *testing.T) {
+	u := User{UserName: `tester`, CurrentSimulationID: 1}
+	for ts, s := range []Stage{Demand, Supply, Trade, Produce, Consume, Invest, Demand, Supply} {
+		d := NewDataset(``)
+		d.Simulations.List = []Simulation{{Id: 1, State: string(s)}}
+		d.Industries.List = []Industry{{Id: 1, Name: `Department I`, Output_Scale: float32(100 + ts)}}
+		if ts >= 2 {
+			d.Industries.List = append(d.Industries.List, Industry{Id: 2, Name: `Department II`, Output_Scale: 50})
+		}
+		u.CommitDataset(d, d.Simulations)
+	}
+
+	fields := IndustryKind.Fields()
+	if !IndustryKind.HasField(`Output_Scale`) || !IndustryKind.HasField(`Profit_Rate`) {
+		t.Errorf("the fields of an industry are %v, want Output_Scale and Profit_Rate among them", fields)
+	}
+	for _, f := range []string{`Id`, `Simulation_id`, `Time_Stamp`, `Name`, `Nonsense`} {
+		if IndustryKind.HasField(f) {
+			t.Errorf("%s is one of the fields that can be charted", f)
+		}
+	}
+	if k, ok := ParseObjectKind(`class`); k != ClassKind || !ok {
+		t.Errorf("ParseObjectKind(class) = %s, %v", k, ok)
+	}
+	if _, ok := ParseObjectKind(`planet`); ok {
+		t.Errorf("ParseObjectKind(planet) is ok")
+	}
+	if got := u.Objects(IndustryKind, 1); len(got) != 1 || got[0] != (NamedObject{Id: 1, Name: `Department I`}) {
+		t.Errorf("the industries at stage 1 are %+v", got)
+	}
+
+	tests := []struct {
+		name   string
+		axis   Axis
+		id     int
+		stamps []int
+		labels []string
+		want   []float64 // -1 where the industry does not exist
+	}{
+		{"by stage", StageAxis, 1, []int{0, 1, 2, 3, 4, 5, 6, 7}, []string{`0`, `1`, `2`, `3`, `4`, `5`, `6`, `7`},
+			[]float64{100, 101, 102, 103, 104, 105, 106, 107}},
+		{"by period", PeriodAxis, 1, []int{0, 6}, []string{`start`, `P1`}, []float64{100, 106}},
+		{"appearing", StageAxis, 2, []int{0, 1, 2, 3, 4, 5, 6, 7}, []string{`0`, `1`, `2`, `3`, `4`, `5`, `6`, `7`},
+			[]float64{-1, -1, 50, 50, 50, 50, 50, 50}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stamps, labels := u.AxisPoints(tt.axis)
+			if strings.Join(labels, ` `) != strings.Join(tt.labels, ` `) || len(stamps) != len(tt.stamps) {
+				t.Fatalf("the %s axis is %v labelled %v, want %v labelled %v", tt.axis, stamps, labels, tt.stamps, tt.labels)
+			}
+			values := u.Series(IndustryKind, tt.id, `Output_Scale`, stamps)
+			for i, want := range tt.want {
+				if stamps[i] != tt.stamps[i] {
+					t.Errorf("point %d is at stage %d, want %d", i, stamps[i], tt.stamps[i])
+				}
+				if got := values[i]; want < 0 && !math.IsNaN(got) || want >= 0 && got != want {
+					t.Errorf("at stage %d the output scale is %g, want %g", stamps[i], got, want)
+				}
+			}
+		})
+	}
+	if got := u.Series(IndustryKind, 1, `Nonsense`, []int{0}); !math.IsNaN(got[0]) {
+		t.Errorf("a field that does not exist has the value %g", got[0])
+	}
+}
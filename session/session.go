@@ -0,0 +1,108 @@
+// session.go
+// The client's record of who is playing and what they can play with.
+// These are filled in by fetch.Initialise when the client starts, and
+// are the only global simulation state in the client. The models
+// package holds no global state, so its objects can be used outside
+// a logged-in user (in a CLI, say, or an export).
+//
+// Requests are handled concurrently, so the users are kept in a registry
+// guarded by a lock, and each user has a lock of its own. A request made
+// as a user holds that user's lock from the moment it starts to change
+// the user's record until it has finished (see display.SynchWithServer).
+// Two browser tabs playing as the same user therefore take turns,
+// while different users never wait for each other.
+
+package session
+
+import (
+	"capfront/models"
+	"sort"
+	"sync"
+)
+
+// A user's record, together with the lock that serializes the
+// requests made as that user.
+type entry struct {
+	mu   sync.Mutex
+	user *models.User
+}
+
+var (
+	registryLock sync.RWMutex
+	users        = make(map[string]*entry) // Every user's simulation data, keyed by username
+)
+
+var AdminUserList []models.User // Basic user data, for use by the administrator
+
+// This list of templates is common to all users.
+// It would normally change only when the database is reset from
+// immutable fixtures using Refresh().
+// It is initialized when this frontend restarts.
+// In future there should be some procedure for adding new templates
+// or editing existing ones.
+var TemplateList []models.Simulation
+
+// Adds a user to the registry, replacing any user of the same name.
+func Register(user *models.User) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	users[user.UserName] = &entry{user: user}
+}
+
+// Finds a user in the registry.
+// The caller must hold the user's lock (see Lock) before reading or
+// changing anything in the record that a request can change.
+func Lookup(username string) (*models.User, bool) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	e, ok := users[username]
+	if !ok {
+		return nil, false
+	}
+	return e.user, true
+}
+
+// Acquires the lock of the named user, waiting for any other request
+// made as that user to finish.
+//
+//	Returns: a function that releases the lock.
+//	Returns: nil if there is no such user.
+//
+// The lock is not reentrant. A request that holds it must not hand
+// itself on (with Router.HandleContext, say) to a handler that takes it again.
+func Lock(username string) (unlock func()) {
+	registryLock.RLock()
+	e, ok := users[username]
+	registryLock.RUnlock()
+	if !ok {
+		return nil
+	}
+	e.mu.Lock()
+	return e.mu.Unlock
+}
+
+// A copy of every user's record, in order of username.
+// Each record is copied under its user's lock, so the copies are
+// consistent, and may be read (by a template or the JSON encoder, say)
+// while the users go on playing.
+//
+// The copies share their Datasets with the originals. This is safe,
+// because a Dataset is never modified once it is committed, and new
+// Datasets are only ever appended beyond the end of the copied slice.
+func Snapshot() []models.User {
+	registryLock.RLock()
+	entries := make([]*entry, 0, len(users))
+	for _, e := range users {
+		entries = append(entries, e)
+	}
+	registryLock.RUnlock()
+
+	list := make([]models.User, len(entries))
+	for i, e := range entries {
+		e.mu.Lock()
+		list[i] = *e.user
+		e.mu.Unlock()
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].UserName < list[j].UserName })
+	return list
+}
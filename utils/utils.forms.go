@@ -2,19 +2,51 @@
 package utils
 
 import (
+	"errors"
 	"log"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 )
 
+// An error which knows what HTTP status it should be reported with,
+// and how to explain itself to the user. Errors from the api package
+// satisfy this; anything else is treated as a programme error.
+type UserFacingError interface {
+	error
+	HttpStatus() int
+	Explanation() string
+}
+
 // Function to handle errors that need to be displayed to the user.
 // Wrapped here to save space and also because error handling may
 // be changed later.
-
-func DisplayError(ctx *gin.Context, message string) {
-	log.Output(1, message)
-	ctx.HTML(http.StatusBadRequest, "errors.html", gin.H{
-		"message": message,
+//
+//	message says what the client was trying to do
+//	err is the cause, or nil if there is none
+//
+// If err (or anything it wraps) is a UserFacingError, the page is sent
+// with that error's status and explanation. Otherwise it is reported
+// as a bad request, which most likely means a programme error.
+func DisplayError(ctx *gin.Context, message string, err error) {
+	status := http.StatusBadRequest
+	explanation := ""
+	detail := ""
+	if err != nil {
+		detail = err.Error()
+		var ufe UserFacingError
+		if errors.As(err, &ufe) {
+			status = ufe.HttpStatus()
+			explanation = ufe.Explanation()
+		}
+		log.Output(2, message+": "+detail)
+	} else {
+		log.Output(2, message)
+	}
+	ctx.HTML(status, "errors.html", gin.H{
+		"message":     message,
+		"explanation": explanation,
+		"detail":      detail,
+		"status":      status,
 	})
 }